@@ -19,6 +19,8 @@ type AggregateHandler interface {
 	HandleCommand(Command) error
 	AddEvent(Event)
 	AttachCommandID(id string)
+	AttachTenantID(id string)
+	AttachMetadata(metadata map[string]string)
 	Uncommited() []Event
 	ClearUncommited()
 	IncrementVersion()
@@ -116,3 +118,30 @@ func (b *BaseAggregate) AttachCommandID(id string) {
 		b.Changes[i].CommandID = id
 	}
 }
+
+// AttachTenantID to every change, the events belong to the tenant of the command
+func (b *BaseAggregate) AttachTenantID(id string) {
+	for i := range b.Changes {
+		b.Changes[i].TenantID = id
+	}
+}
+
+// AttachMetadata to every change, the values already present in a change are kept
+func (b *BaseAggregate) AttachMetadata(metadata map[string]string) {
+	if len(metadata) == 0 {
+		return
+	}
+
+	for i := range b.Changes {
+		merged := make(map[string]string, len(metadata)+len(b.Changes[i].Metadata))
+		for key, value := range metadata {
+			merged[key] = value
+		}
+
+		for key, value := range b.Changes[i].Metadata {
+			merged[key] = value
+		}
+
+		b.Changes[i].Metadata = merged
+	}
+}
@@ -0,0 +1,248 @@
+package triper
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrForbidden is returned when a policy denies a command
+var ErrForbidden = errors.New("forbidden")
+
+// Principal is who sends a command
+type Principal struct {
+	ID         string            `json:"id"`
+	Roles      []string          `json:"roles,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// HasRole returns true if the principal has any of the roles
+func (p *Principal) HasRole(roles ...string) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, role := range p.Roles {
+		for _, wanted := range roles {
+			if role == wanted {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CommandPrincipal is implemented by the commands that carry a principal,
+// it must be set by trusted code like the http gateway, never decoded from
+// the command payload
+type CommandPrincipal interface {
+	GetPrincipal() *Principal
+	SetPrincipal(principal *Principal)
+}
+
+// PrincipalOf returns the principal of a command, nil if it has none
+func PrincipalOf(command Command) *Principal {
+	if c, ok := command.(CommandPrincipal); ok {
+		return c.GetPrincipal()
+	}
+
+	return nil
+}
+
+// Policy decides if a command can be handled, aggregate contains the
+// state loaded from the event store, it has no ID if it does not exist yet.
+// A denial is reported returning an error
+type Policy interface {
+	Authorize(principal *Principal, command Command, aggregate AggregateHandler) error
+}
+
+// PolicyFunc is an adapter to use functions as policies
+type PolicyFunc func(principal *Principal, command Command, aggregate AggregateHandler) error
+
+// Authorize calls f
+func (f PolicyFunc) Authorize(principal *Principal, command Command, aggregate AggregateHandler) error {
+	return f(principal, command, aggregate)
+}
+
+// RequireRoles allows the principals with any of the roles
+func RequireRoles(roles ...string) Policy {
+	return PolicyFunc(func(principal *Principal, command Command, aggregate AggregateHandler) error {
+		if principal.HasRole(roles...) {
+			return nil
+		}
+
+		return fmt.Errorf("%w: requires any of the roles %v", ErrForbidden, roles)
+	})
+}
+
+// RequireOwner allows the principal returned by owner for the aggregate
+func RequireOwner(owner func(aggregate AggregateHandler) string) Policy {
+	return PolicyFunc(func(principal *Principal, command Command, aggregate AggregateHandler) error {
+		if principal != nil && principal.ID != "" && principal.ID == owner(aggregate) {
+			return nil
+		}
+
+		return fmt.Errorf("%w: principal is not the owner of %s", ErrForbidden, command.GetAggregateID())
+	})
+}
+
+// AnyOf allows the command if any of the policies allows it
+func AnyOf(policies ...Policy) Policy {
+	return PolicyFunc(func(principal *Principal, command Command, aggregate AggregateHandler) error {
+		err := fmt.Errorf("%w: no policies", ErrForbidden)
+		for _, policy := range policies {
+			if err = policy.Authorize(principal, command, aggregate); err == nil {
+				return nil
+			}
+		}
+
+		return err
+	})
+}
+
+// AuditRecord describes an authorization decision
+type AuditRecord struct {
+	Time          time.Time
+	PrincipalID   string
+	CommandID     string
+	CommandType   string
+	AggregateID   string
+	AggregateType string
+	Allowed       bool
+	Reason        string
+}
+
+// Auditor records the authorization decisions
+type Auditor interface {
+	Record(record AuditRecord)
+}
+
+// AuditorFunc is an adapter to use functions as auditors
+type AuditorFunc func(record AuditRecord)
+
+// Record calls f
+func (f AuditorFunc) Record(record AuditRecord) {
+	f(record)
+}
+
+// LogAuditor records the decisions in a logger, the denials with warn level
+func LogAuditor(logger Logger) Auditor {
+	return AuditorFunc(func(record AuditRecord) {
+		fields := []interface{}{
+			"principal_id", record.PrincipalID,
+			LogCommandID, record.CommandID,
+			LogCommandType, record.CommandType,
+			LogAggregateID, record.AggregateID,
+			LogAggregateType, record.AggregateType,
+		}
+
+		if record.Allowed {
+			logger.Info("command allowed", fields...)
+			return
+		}
+
+		logger.Warn("command denied", append(fields, "reason", record.Reason)...)
+	})
+}
+
+// Authorizer evaluates the policies of a command before it is handled,
+// all of them must allow it. The commands without policies are allowed
+// unless DenyUnlisted is true
+type Authorizer struct {
+	mu       sync.RWMutex
+	global   []Policy
+	policies map[string][]Policy
+	auditor  Auditor
+
+	// DenyUnlisted commands without policies
+	DenyUnlisted bool
+	// AuditAllowed records the allowed commands too, the denials are
+	// always recorded
+	AuditAllowed bool
+}
+
+// NewAuthorizer returns an authorizer that records the decisions in
+// auditor, it can be nil
+func NewAuthorizer(auditor Auditor) *Authorizer {
+	return &Authorizer{
+		policies: make(map[string][]Policy),
+		auditor:  auditor,
+	}
+}
+
+// Use policies for every command
+func (a *Authorizer) Use(policies ...Policy) {
+	a.mu.Lock()
+	a.global = append(a.global, policies...)
+	a.mu.Unlock()
+}
+
+// Add policies for a command type
+func (a *Authorizer) Add(command interface{}, policies ...Policy) {
+	_, name := GetTypeName(command)
+
+	a.mu.Lock()
+	a.policies[name] = append(a.policies[name], policies...)
+	a.mu.Unlock()
+}
+
+// Authorize the command over the loaded aggregate, the denials are errors
+// that wrap ErrForbidden
+func (a *Authorizer) Authorize(command Command, aggregate AggregateHandler) error {
+	_, name := GetTypeName(command)
+
+	a.mu.RLock()
+	policies := append(append([]Policy{}, a.global...), a.policies[name]...)
+	listed := len(a.policies[name]) > 0
+	a.mu.RUnlock()
+
+	principal := PrincipalOf(command)
+
+	var err error
+	if !listed && a.DenyUnlisted {
+		err = fmt.Errorf("%w: command %s has no policies", ErrForbidden, name)
+	}
+
+	for _, policy := range policies {
+		if err != nil {
+			break
+		}
+
+		err = policy.Authorize(principal, command, aggregate)
+	}
+
+	// a policy can return its own error, it is still a denial
+	if err != nil && !errors.Is(err, ErrForbidden) {
+		err = fmt.Errorf("%w: %s", ErrForbidden, err)
+	}
+
+	a.audit(principal, command, name, err)
+	return err
+}
+
+func (a *Authorizer) audit(principal *Principal, command Command, name string, err error) {
+	if a.auditor == nil || (err == nil && !a.AuditAllowed) {
+		return
+	}
+
+	record := AuditRecord{
+		Time:          time.Now(),
+		CommandID:     command.GetID(),
+		CommandType:   name,
+		AggregateID:   command.GetAggregateID(),
+		AggregateType: command.GetAggregateType(),
+		Allowed:       err == nil,
+	}
+
+	if principal != nil {
+		record.PrincipalID = principal.ID
+	}
+
+	if err != nil {
+		record.Reason = err.Error()
+	}
+
+	a.auditor.Record(record)
+}
@@ -0,0 +1,69 @@
+package triper
+
+import (
+	"errors"
+	"testing"
+)
+
+type Withdraw struct {
+	BaseCommand
+	Amount int
+}
+
+type OwnedAccount struct {
+	MockAggregate
+	Owner string
+}
+
+func TestAuthorizer(t *testing.T) {
+	var records []AuditRecord
+	authorizer := NewAuthorizer(AuditorFunc(func(record AuditRecord) {
+		records = append(records, record)
+	}))
+
+	owner := RequireOwner(func(aggregate AggregateHandler) string {
+		return aggregate.(*OwnedAccount).Owner
+	})
+	authorizer.Add(&Withdraw{}, AnyOf(RequireRoles("admin"), owner))
+
+	account := &OwnedAccount{Owner: "ana"}
+	command := &Withdraw{Amount: 10}
+	command.AggregateID = "account-1"
+
+	if err := authorizer.Authorize(command, account); !errors.Is(err, ErrForbidden) {
+		t.Error("expected ErrForbidden without principal, got", err)
+	}
+
+	command.SetPrincipal(&Principal{ID: "ana"})
+	if err := authorizer.Authorize(command, account); err != nil {
+		t.Error("expected the owner to be allowed, got", err)
+	}
+
+	command.SetPrincipal(&Principal{ID: "bob", Roles: []string{"admin"}})
+	if err := authorizer.Authorize(command, account); err != nil {
+		t.Error("expected the admin to be allowed, got", err)
+	}
+
+	command.SetPrincipal(&Principal{ID: "bob"})
+	if err := authorizer.Authorize(command, account); !errors.Is(err, ErrForbidden) {
+		t.Error("expected ErrForbidden, got", err)
+	}
+
+	if len(records) != 2 || records[1].PrincipalID != "bob" || records[1].Allowed || records[1].CommandType != "withdraw" {
+		t.Errorf("expected 2 denials recorded, got: %+v", records)
+	}
+
+	// commands without policies
+	if err := authorizer.Authorize(&MockCommand{}, account); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	authorizer.DenyUnlisted = true
+	if err := authorizer.Authorize(&MockCommand{}, account); !errors.Is(err, ErrForbidden) {
+		t.Error("expected ErrForbidden, got", err)
+	}
+}
+
+type MockCommand struct {
+	BaseCommand
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// streams returns the streams sorted by key, the tenant and aggregate id
+func streams(client triper.EventStoreInspector) ([]triper.Stream, error) {
+	list, err := client.Streams()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key() < list[j].Key()
+	})
+
+	return list, nil
+}
+
+// listAggregates prints the aggregates and their versions
+func listAggregates(w io.Writer, client triper.EventStoreInspector) error {
+	list, err := streams(client)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TENANT\tAGGREGATE ID\tTYPE\tVERSION")
+	for _, stream := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", stream.TenantID, stream.AggregateID, stream.AggregateType, stream.Version)
+	}
+
+	return tw.Flush()
+}
+
+// dump prints the events of an aggregate, one json document per line
+func dump(w io.Writer, client triper.EventStore, tenantID, aggregateID string) error {
+	events, err := triper.LoadTenant(client, tenantID, aggregateID)
+	if err != nil {
+		return err
+	}
+
+	return printEvents(w, events)
+}
+
+func printEvents(w io.Writer, events []triper.Event) error {
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tail prints the events saved after it started, the streams are polled
+// every interval until stop is closed, a nil stop means forever
+func tail(w io.Writer, client triper.EventStoreInspector, interval time.Duration, stop <-chan struct{}) error {
+	versions := make(map[string]int)
+
+	list, err := client.Streams()
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range list {
+		versions[stream.Key()] = stream.Version
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		list, err = streams(client)
+		if err != nil {
+			return err
+		}
+
+		for _, stream := range list {
+			last := versions[stream.Key()]
+			if stream.Version <= last {
+				continue
+			}
+
+			events, err := triper.LoadTenant(client, stream.TenantID, stream.AggregateID)
+			if err != nil {
+				return err
+			}
+
+			var fresh []triper.Event
+			for _, event := range events {
+				if event.Version > last {
+					fresh = append(fresh, event)
+				}
+			}
+
+			if err = printEvents(w, fresh); err != nil {
+				return err
+			}
+
+			versions[stream.Key()] = stream.Version
+		}
+	}
+}
+
+// count prints the number of events by type
+func count(w io.Writer, client triper.EventStoreInspector) error {
+	list, err := client.Streams()
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, stream := range list {
+		events, err := triper.LoadTenant(client, stream.TenantID, stream.AggregateID)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			counts[event.Type]++
+		}
+	}
+
+	types := make([]string, 0, len(counts))
+	for typ := range counts {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "EVENT TYPE\tCOUNT")
+	for _, typ := range types {
+		fmt.Fprintf(tw, "%s\t%d\n", typ, counts[typ])
+	}
+
+	return tw.Flush()
+}
+
+// verify checks every stream and prints the problems found:
+// versions must go from 1 to the stream version without gaps, the events
+// must belong to the stream and their ids must be unique. The events saved
+// before the stores kept their versions have version 0, a stream where all
+// of them are 0 is reported as legacy instead of as a gap
+func verify(w io.Writer, client triper.EventStoreInspector) (problems int, err error) {
+	list, err := streams(client)
+	if err != nil {
+		return 0, err
+	}
+
+	report := func(stream triper.Stream, format string, args ...interface{}) {
+		problems++
+		fmt.Fprintf(w, "%s: %s\n", stream.Key(), fmt.Sprintf(format, args...))
+	}
+
+	legacy := 0
+	ids := make(map[string]string)
+	for _, stream := range list {
+		events, err := triper.LoadTenant(client, stream.TenantID, stream.AggregateID)
+		if err != nil {
+			report(stream, "events can't be loaded: %s", err)
+			continue
+		}
+
+		old := unversioned(events)
+		if old {
+			legacy++
+			fmt.Fprintf(w, "%s: legacy stream, its %d events have no version\n", stream.Key(), len(events))
+		}
+
+		for i, event := range events {
+			switch {
+			case old:
+			case event.Version == 0:
+				report(stream, "event %s has no version, expected %d", event.ID, i+1)
+			case event.Version != i+1:
+				report(stream, "event %s has version %d, expected %d", event.ID, event.Version, i+1)
+			}
+
+			if event.AggregateID != stream.AggregateID {
+				report(stream, "event %s belongs to aggregate %s", event.ID, event.AggregateID)
+			}
+
+			if stream.AggregateType != "" && event.AggregateType != stream.AggregateType {
+				report(stream, "event %s has aggregate type %s, expected %s", event.ID, event.AggregateType, stream.AggregateType)
+			}
+
+			if event.ID == "" {
+				report(stream, "event with version %d has no id", event.Version)
+				continue
+			}
+
+			if other, ok := ids[event.ID]; ok {
+				report(stream, "event id %s is also used in %s", event.ID, other)
+			}
+			ids[event.ID] = stream.Key()
+		}
+
+		if len(events) != stream.Version {
+			report(stream, "stream version is %d, but it has %d events", stream.Version, len(events))
+		}
+	}
+
+	fmt.Fprintf(w, "%d streams verified, %d legacy, %d problems found\n", len(list), legacy, problems)
+	return problems, nil
+}
+
+// unversioned returns true when all the events have version 0
+func unversioned(events []triper.Event) bool {
+	for _, event := range events {
+		if event.Version != 0 {
+			return false
+		}
+	}
+
+	return len(events) > 0
+}
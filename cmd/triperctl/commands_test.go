@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mishudark/triper"
+)
+
+type memStore struct {
+	events map[string][]triper.Event
+}
+
+func (m *memStore) Save(events []triper.Event, version int) error {
+	for _, event := range events {
+		m.events[event.AggregateID] = append(m.events[event.AggregateID], event)
+	}
+
+	return nil
+}
+
+func (m *memStore) SafeSave(events []triper.Event, version int) error {
+	return m.Save(events, version)
+}
+
+func (m *memStore) Load(aggregateID string) ([]triper.Event, error) {
+	return m.events[aggregateID], nil
+}
+
+func (m *memStore) Streams() ([]triper.Stream, error) {
+	var list []triper.Stream
+	for id, events := range m.events {
+		list = append(list, triper.Stream{
+			AggregateID:   id,
+			AggregateType: "account",
+			Version:       len(events),
+		})
+	}
+
+	return list, nil
+}
+
+func newStore() *memStore {
+	store := &memStore{events: make(map[string][]triper.Event)}
+	store.Save([]triper.Event{
+		{ID: "e1", AggregateID: "a", AggregateType: "account", Version: 1, Type: "account_created"},
+		{ID: "e2", AggregateID: "a", AggregateType: "account", Version: 2, Type: "deposit_performed"},
+		{ID: "e3", AggregateID: "b", AggregateType: "account", Version: 1, Type: "account_created"},
+	}, 0)
+
+	return store
+}
+
+func TestCount(t *testing.T) {
+	var out bytes.Buffer
+	if err := count(&out, newStore()); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if !strings.Contains(out.String(), "account_created    2") {
+		t.Error("expected 2 account_created events, got", out.String())
+	}
+}
+
+func TestVerify(t *testing.T) {
+	store := newStore()
+
+	var out bytes.Buffer
+	problems, err := verify(&out, store)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if problems != 0 {
+		t.Error("expected 0 problems, got", problems, out.String())
+	}
+
+	// a gap in the versions and a repeated id
+	store.Save([]triper.Event{
+		{ID: "e1", AggregateID: "b", AggregateType: "account", Version: 3, Type: "deposit_performed"},
+	}, 1)
+
+	out.Reset()
+	problems, err = verify(&out, store)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if problems != 2 {
+		t.Error("expected 2 problems, got", problems, out.String())
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	store := newStore()
+
+	// the events saved before the versions were kept
+	store.Save([]triper.Event{
+		{ID: "e4", AggregateID: "c", AggregateType: "account", Type: "account_created"},
+		{ID: "e5", AggregateID: "c", AggregateType: "account", Type: "deposit_performed"},
+	}, 0)
+
+	var out bytes.Buffer
+	problems, err := verify(&out, store)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if problems != 0 || !strings.Contains(out.String(), "c: legacy stream") {
+		t.Error("expected a legacy stream without problems, got", problems, out.String())
+	}
+
+	// a missing version in a versioned stream is a problem
+	store.Save([]triper.Event{
+		{ID: "e6", AggregateID: "a", AggregateType: "account", Type: "deposit_performed"},
+	}, 2)
+
+	out.Reset()
+	if problems, _ = verify(&out, store); problems != 1 || !strings.Contains(out.String(), "event e6 has no version, expected 3") {
+		t.Error("expected the missing version, got", problems, out.String())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/eventstore/jsonl"
+)
+
+// copyOptions are set by the flags used by export and import
+var copyOptions struct {
+	types  string
+	resume bool
+}
+
+func jsonlOptions() jsonl.Options {
+	var opts jsonl.Options
+	if copyOptions.types != "" {
+		opts.AggregateTypes = strings.Split(copyOptions.types, ",")
+	}
+
+	return opts
+}
+
+// export writes the events to stdout or to the file in args
+func export(client triper.EventStoreInspector, args []string) error {
+	opts := jsonlOptions()
+
+	var w io.Writer = os.Stdout
+	if len(args) == 1 {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if copyOptions.resume {
+			flags = os.O_CREATE | os.O_RDWR
+		}
+
+		f, err := os.OpenFile(args[0], flags, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if copyOptions.resume {
+			if opts.From, err = jsonl.Resume(f); err != nil {
+				return err
+			}
+		}
+
+		w = f
+	}
+
+	result, err := jsonl.Export(w, client, opts)
+	fmt.Fprintf(os.Stderr, "%d streams, %d events exported\n", result.Streams, result.Events)
+	return err
+}
+
+// importFile saves the events of an exported file, "-" reads stdin
+func importFile(client triper.EventStore, name string) error {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	result, err := jsonl.Import(r, client, jsonlOptions())
+	fmt.Fprintf(os.Stderr, "%d streams, %d events imported, %d skipped\n", result.Streams, result.Events, result.Skipped)
+	return err
+}
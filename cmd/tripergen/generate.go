@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"strings"
+	"text/template"
+)
+
+// imports used by the generated code, the schema can't repeat them
+var generatedImports = map[string]bool{
+	`"github.com/mishudark/triper"`:        true,
+	`"github.com/mishudark/triper/config"`: true,
+}
+
+var funcs = template.FuncMap{
+	"receiver": receiver,
+	"unexport": unexport,
+}
+
+var typesTemplate = template.Must(template.New("types").Funcs(funcs).Parse(`// Code generated by tripergen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/config"
+{{range .Imports}}	{{.}}
+{{end}})
+{{range $a := .Aggregates}}
+{{range .Doc}}{{.}}
+{{end}}type {{.Name}} struct {
+	triper.BaseAggregate
+	{{.Fields}}
+}
+{{range .Commands}}
+{{range .Doc}}{{.}}
+{{end}}type {{.Name}} struct {
+	triper.BaseCommand
+	{{.Fields}}
+}
+{{end}}{{range .Events}}
+{{range .Doc}}{{.}}
+{{end}}type {{.Name}} struct {
+	{{.Fields}}
+}
+{{end}}
+// {{unexport .Name}}Dispatcher calls the Apply and Handle methods of {{.Name}}
+var {{unexport .Name}}Dispatcher = new{{.Name}}Dispatcher()
+
+func new{{.Name}}Dispatcher() *triper.Dispatcher {
+	reg := triper.NewEventRegister()
+	for _, event := range {{.Name}}Events() {
+		reg.Set(event)
+	}
+
+	return triper.MustDispatcher(&{{.Name}}{}, reg, {{.Name}}Events()...)
+}
+
+// Reduce applies an event to {{.Name}}
+func ({{receiver .Name}} *{{.Name}}) Reduce(event triper.Event) error {
+	return {{unexport .Name}}Dispatcher.Reduce({{receiver .Name}}, event)
+}
+
+// HandleCommand handles a command sent to {{.Name}}
+func ({{receiver .Name}} *{{.Name}}) HandleCommand(command triper.Command) error {
+	return {{unexport .Name}}Dispatcher.HandleCommand({{receiver .Name}}, command)
+}
+
+// {{.Name}}Commands returns the commands handled by {{.Name}}
+func {{.Name}}Commands() []interface{} {
+	return []interface{}{
+{{- range .Commands}}
+		&{{.Name}}{},
+{{- end}}
+	}
+}
+
+// {{.Name}}Events returns the events applied to {{.Name}}
+func {{.Name}}Events() []interface{} {
+	return []interface{}{
+{{- range .Events}}
+		&{{.Name}}{},
+{{- end}}
+	}
+}
+
+// Wire{{.Name}} wires the commands of {{.Name}} to the handler returned by constructor
+func Wire{{.Name}}(constructor func(*triper.Repository, triper.AggregateHandler, string, string) triper.CommandHandler, bucket, subset string) config.CommandConfig {
+	return config.WireCommands(&{{.Name}}{}, constructor, bucket, subset, {{.Name}}Commands()...)
+}
+{{end}}
+// RegisterEvents sets the events of every aggregate in reg
+func RegisterEvents(reg triper.EventTypeRegister) {
+{{- range .Aggregates}}
+	for _, event := range {{.Name}}Events() {
+		reg.Set(event)
+	}
+{{- end}}
+}
+`))
+
+var handlersTemplate = template.Must(template.New("handlers").Funcs(funcs).Parse(`{{if .Header}}package {{.Package}}
+
+import (
+	"errors"
+{{- if .Events}}
+
+	"github.com/mishudark/triper"
+{{- end}}
+)
+{{end}}{{$a := .Aggregate}}{{range .Events}}
+// Apply{{.Name}} changes the state of {{$a}}, {{unexport $a}}Dispatcher calls it
+// from Reduce
+func ({{receiver $a}} *{{$a}}) Apply{{.Name}}(event triper.Event, data *{{.Name}}) error {
+	return errors.New("{{$a}}: Apply{{.Name}} not implemented")
+}
+{{end}}{{range .Commands}}
+// Handle{{.Name}} validates the command, {{unexport $a}}Dispatcher calls it from
+// HandleCommand. The events are recorded with triper.ReduceHelper, that
+// applies them with the Apply methods
+func ({{receiver $a}} *{{$a}}) Handle{{.Name}}(command *{{.Name}}) error {
+	return errors.New("{{$a}}: Handle{{.Name}} not implemented")
+}
+{{end}}`))
+
+// GenerateTypes returns the source of the types and the registration code
+func GenerateTypes(schema *Schema) ([]byte, error) {
+	var imports []string
+	for _, imp := range schema.Imports {
+		if !generatedImports[imp] {
+			imports = append(imports, imp)
+		}
+	}
+
+	data := *schema
+	data.Imports = imports
+
+	var buf bytes.Buffer
+	if err := typesTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+// GenerateHandlers returns the skeleton of the handlers of an aggregate
+// that are not in existing, header adds the package clause and imports
+func GenerateHandlers(schema *Schema, aggregate *Aggregate, existing map[string]bool, header bool) ([]byte, error) {
+	data := struct {
+		Package   string
+		Aggregate string
+		Header    bool
+		Events    []Type
+		Commands  []Type
+	}{
+		Package:   schema.Package,
+		Aggregate: aggregate.Name,
+		Header:    header,
+	}
+
+	for _, event := range aggregate.Events {
+		if !existing["Apply"+event.Name] {
+			data.Events = append(data.Events, event)
+		}
+	}
+
+	for _, command := range aggregate.Commands {
+		if !existing["Handle"+command.Name] {
+			data.Commands = append(data.Commands, command)
+		}
+	}
+
+	if len(data.Events) == 0 && len(data.Commands) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := handlersTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	if !header {
+		// a fragment appended to a file can't be formatted alone
+		return buf.Bytes(), nil
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+// receiver name for a type, the first letter in lower case
+func receiver(name string) string {
+	return strings.ToLower(name[:1])
+}
+
+// unexport returns name with the first letter in lower case
+func unexport(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
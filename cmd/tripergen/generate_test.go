@@ -0,0 +1,153 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parseTestSchema(t *testing.T) *Schema {
+	src, err := ioutil.ReadFile("testdata/schema.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := ParseSchema("schema.go", src)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	return schema
+}
+
+func TestParseSchema(t *testing.T) {
+	schema := parseTestSchema(t)
+
+	if schema.Package != "bank" || len(schema.Aggregates) != 1 {
+		t.Fatalf("unexpected schema: %+v", schema)
+	}
+
+	account := schema.Aggregates[0]
+	if len(account.Commands) != 2 || len(account.Events) != 2 {
+		t.Errorf("expected 2 commands and 2 events, got %d %d", len(account.Commands), len(account.Events))
+	}
+
+	if account.Events[1].Fields != "Amount int `json:\"amount\"`" {
+		t.Error("unexpected fields, got", account.Events[1].Fields)
+	}
+
+	if len(schema.Imports) != 1 || schema.Imports[0] != `"time"` {
+		t.Error("expected time import, got", schema.Imports)
+	}
+
+	_, err := ParseSchema("schema.go", []byte("package bank\n\n// triper:event Missing\ntype X struct{}\n\n// triper:aggregate\ntype A struct{}\n"))
+	if err == nil || !strings.Contains(err.Error(), `unknown aggregate "Missing"`) {
+		t.Error("expected unknown aggregate error, got", err)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	schema := parseTestSchema(t)
+
+	code, err := GenerateTypes(schema)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if _, err = parser.ParseFile(token.NewFileSet(), "triper_gen.go", code, 0); err != nil {
+		t.Error("expected valid code, got", err)
+	}
+
+	for _, expected := range []string{
+		"triper.BaseCommand\n\tAmount int",
+		"triper.MustDispatcher(&Account{}, reg, AccountEvents()...)",
+		"return accountDispatcher.Reduce(a, event)",
+		"return accountDispatcher.HandleCommand(a, command)",
+		"func WireAccount(",
+	} {
+		if !strings.Contains(string(code), expected) {
+			t.Errorf("expected %q in:\n%s", expected, code)
+		}
+	}
+
+	existing := map[string]bool{"ApplyAccountCreated": true, "HandleCreateAccount": true}
+	code, err = GenerateHandlers(schema, schema.Aggregates[0], existing, true)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if strings.Contains(string(code), "ApplyAccountCreated") || !strings.Contains(string(code), "HandlePerformDeposit(command *PerformDeposit) error") {
+		t.Errorf("expected only the missing handlers, got:\n%s", code)
+	}
+
+	existing["ApplyDepositPerformed"] = true
+	existing["HandlePerformDeposit"] = true
+	if code, _ = GenerateHandlers(schema, schema.Aggregates[0], existing, false); code != nil {
+		t.Errorf("expected nothing, got:\n%s", code)
+	}
+}
+
+// skeletonTest checks the generated package, the dispatcher panics when it
+// is created if the generated methods don't match the types
+const skeletonTest = `package bank
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSkeleton(t *testing.T) {
+	err := (&Account{}).HandleCommand(&CreateAccount{})
+	if err == nil || !strings.Contains(err.Error(), "HandleCreateAccount not implemented") {
+		t.Error("expected the skeleton error, got", err)
+	}
+}
+`
+
+func TestGeneratedPackage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the generated package is built with the go tool")
+	}
+
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+
+	// inside the module, so the package imports this version of triper
+	dir, err := ioutil.TempDir("testdata", "bank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := ioutil.ReadFile("testdata/schema.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string][]byte{
+		"schema.go":    src,
+		"bank_test.go": []byte(skeletonTest),
+	} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = run(filepath.Join(dir, "schema.go"), "triper_gen.go", true); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	for _, args := range [][]string{{"vet"}, {"test"}} {
+		cmd := exec.Command(gobin, append(args, "./"+filepath.ToSlash(dir))...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("go %s of the generated package failed: %s\n%s", args[0], err, out)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// tripergen generates the commands, events and aggregates declared in a
+// schema, see Schema for its format. It is meant to be used with go generate:
+//
+//	//go:generate go run github.com/mishudark/triper/cmd/tripergen -schema schema.go
+//
+// The types, the Reduce and HandleCommand methods and the registration
+// code are written to triper_gen.go. The skeleton of the handlers is
+// written to <aggregate>_handlers.go, the handlers already implemented in
+// the package are not generated again
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	schemaFile := flag.String("schema", "", "schema file with the annotated types")
+	out := flag.String("out", "triper_gen.go", "file for the generated types, relative to the schema")
+	handlers := flag.Bool("handlers", true, "generate the skeleton of the missing handlers")
+	flag.Parse()
+
+	if *schemaFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*schemaFile, *out, *handlers); err != nil {
+		fmt.Fprintln(os.Stderr, "tripergen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(schemaFile, out string, handlers bool) error {
+	src, err := ioutil.ReadFile(schemaFile)
+	if err != nil {
+		return err
+	}
+
+	schema, err := ParseSchema(schemaFile, src)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(schemaFile)
+	if !filepath.IsAbs(out) {
+		out = filepath.Join(dir, out)
+	}
+
+	code, err := GenerateTypes(schema)
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(out, code, 0644); err != nil {
+		return err
+	}
+
+	if !handlers {
+		return nil
+	}
+
+	methods, err := existingMethods(dir, out)
+	if err != nil {
+		return err
+	}
+
+	for _, aggregate := range schema.Aggregates {
+		filename := filepath.Join(dir, strings.ToLower(aggregate.Name)+"_handlers.go")
+
+		_, err := os.Stat(filename)
+		header := os.IsNotExist(err)
+
+		code, err := GenerateHandlers(schema, aggregate, methods[aggregate.Name], header)
+		if err != nil {
+			return err
+		}
+
+		if len(code) == 0 {
+			continue
+		}
+
+		f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(code)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// existingMethods returns the methods declared in dir by receiver type,
+// the generated file is skipped
+func existingMethods(dir, generated string) (map[string]map[string]bool, error) {
+	fset := token.NewFileSet()
+	skip := filepath.Base(generated)
+
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return info.Name() != skip && !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	methods := make(map[string]map[string]bool)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv == nil || len(fn.Recv.List) == 0 {
+					continue
+				}
+
+				recv := fn.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+
+				ident, ok := recv.(*ast.Ident)
+				if !ok {
+					continue
+				}
+
+				if methods[ident.Name] == nil {
+					methods[ident.Name] = make(map[string]bool)
+				}
+				methods[ident.Name][fn.Name.Name] = true
+			}
+		}
+	}
+
+	return methods, nil
+}
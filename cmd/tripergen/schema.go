@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// annotation prefix used in the doc comments of the schema
+const annotation = "triper:"
+
+// Schema of a package, it is read from a Go file with annotated types:
+//
+//	// +build ignore
+//
+//	package bank
+//
+//	// Account of bank
+//	// triper:aggregate
+//	type Account struct {
+//		Owner   string
+//		Balance int
+//	}
+//
+//	// PerformDeposit to an account
+//	// triper:command Account
+//	type PerformDeposit struct {
+//		Amount int
+//	}
+//
+//	// DepositPerformed event
+//	// triper:event Account
+//	type DepositPerformed struct {
+//		Amount int `json:"amount"`
+//	}
+//
+// The aggregate can be omitted if the schema contains only one
+type Schema struct {
+	Package    string
+	Imports    []string
+	Aggregates []*Aggregate
+}
+
+// Aggregate with its commands and events
+type Aggregate struct {
+	Type
+	Commands []Type
+	Events   []Type
+}
+
+// Type declared in the schema
+type Type struct {
+	Name   string
+	Doc    []string
+	Fields string
+}
+
+// ParseSchema reads the schema from src, filename is used in the errors
+func ParseSchema(filename string, src []byte) (*Schema, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{Package: file.Name.Name}
+	aggregates := make(map[string]*Aggregate)
+
+	type member struct {
+		kind, aggregate string
+		typ             Type
+		pos             token.Position
+	}
+	var members []member
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.TypeSpec)
+
+			doc := spec.Doc
+			if doc == nil && len(gen.Specs) == 1 {
+				doc = gen.Doc
+			}
+
+			lines, kind, args := parseDoc(doc)
+			if kind == "" {
+				continue
+			}
+
+			st, ok := spec.Type.(*ast.StructType)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s must be a struct", fset.Position(spec.Pos()), spec.Name.Name)
+			}
+
+			fields, err := formatFields(fset, st)
+			if err != nil {
+				return nil, err
+			}
+
+			typ := Type{Name: spec.Name.Name, Doc: lines, Fields: fields}
+			pos := fset.Position(spec.Pos())
+
+			switch kind {
+			case "aggregate":
+				if _, ok := aggregates[typ.Name]; ok {
+					return nil, fmt.Errorf("%s: aggregate %s declared twice", pos, typ.Name)
+				}
+
+				aggregate := &Aggregate{Type: typ}
+				aggregates[typ.Name] = aggregate
+				schema.Aggregates = append(schema.Aggregates, aggregate)
+			case "command", "event":
+				var name string
+				if len(args) > 0 {
+					name = args[0]
+				}
+
+				members = append(members, member{kind, name, typ, pos})
+			default:
+				return nil, fmt.Errorf("%s: unknown annotation %s%s", pos, annotation, kind)
+			}
+		}
+	}
+
+	if len(schema.Aggregates) == 0 {
+		return nil, fmt.Errorf("%s: no aggregates found", filename)
+	}
+
+	for _, m := range members {
+		name := m.aggregate
+		if name == "" && len(schema.Aggregates) == 1 {
+			name = schema.Aggregates[0].Name
+		}
+
+		aggregate, ok := aggregates[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: %s %s, unknown aggregate %q", m.pos, m.kind, m.typ.Name, name)
+		}
+
+		if m.kind == "command" {
+			aggregate.Commands = append(aggregate.Commands, m.typ)
+		} else {
+			aggregate.Events = append(aggregate.Events, m.typ)
+		}
+	}
+
+	schema.Imports = usedImports(file)
+	return schema, nil
+}
+
+// parseDoc splits the doc comment in the regular lines and the annotation
+func parseDoc(doc *ast.CommentGroup) (lines []string, kind string, args []string) {
+	if doc == nil {
+		return nil, "", nil
+	}
+
+	for _, comment := range doc.List {
+		text := strings.TrimSpace(strings.TrimPrefix(comment.Text, "//"))
+		if !strings.HasPrefix(text, annotation) {
+			lines = append(lines, comment.Text)
+			continue
+		}
+
+		parts := strings.Fields(strings.TrimPrefix(text, annotation))
+		if len(parts) > 0 {
+			kind, args = parts[0], parts[1:]
+		}
+	}
+
+	return lines, kind, args
+}
+
+// formatFields returns the source of the fields of a struct
+func formatFields(fset *token.FileSet, st *ast.StructType) (string, error) {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, st); err != nil {
+		return "", err
+	}
+
+	src := buf.String()
+	src = strings.TrimSpace(src[strings.Index(src, "{")+1 : strings.LastIndex(src, "}")])
+	return src, nil
+}
+
+// usedImports returns the imports of file used by the declared types
+func usedImports(file *ast.File) []string {
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				used[ident.Name] = true
+			}
+		}
+
+		return true
+	})
+
+	var imports []string
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+
+		if used[name] {
+			if spec.Name != nil {
+				imports = append(imports, spec.Name.Name+" "+spec.Path.Value)
+			} else {
+				imports = append(imports, spec.Path.Value)
+			}
+		}
+	}
+
+	return imports
+}
@@ -0,0 +1,38 @@
+//go:build ignore
+// +build ignore
+
+package bank
+
+import "time"
+
+// Account of bank
+// triper:aggregate
+type Account struct {
+	Owner   string
+	Balance int
+	Opened  time.Time
+}
+
+// CreateAccount assigned to an owner
+// triper:command
+type CreateAccount struct {
+	Owner string
+}
+
+// PerformDeposit to a given account
+// triper:command Account
+type PerformDeposit struct {
+	Amount int
+}
+
+// AccountCreated event
+// triper:event
+type AccountCreated struct {
+	Owner string `json:"owner"`
+}
+
+// DepositPerformed event
+// triper:event Account
+type DepositPerformed struct {
+	Amount int `json:"amount"`
+}
@@ -11,6 +11,13 @@ type Command interface {
 	GetVersion() int
 }
 
+// CommandMetadata is implemented by the commands that carry metadata,
+// like the trace context, it is attached to the events they produce
+type CommandMetadata interface {
+	GetMetadata() map[string]string
+	SetMetadata(key, value string)
+}
+
 // BaseCommand contains the basic info  that all commands should have
 type BaseCommand struct {
 	ID            string
@@ -18,6 +25,12 @@ type BaseCommand struct {
 	AggregateID   string
 	AggregateType string
 	Version       int
+	Metadata      map[string]string
+	// TenantID is the tenant of the aggregate, empty for the default one.
+	// It is set by the trusted code, it is never decoded
+	TenantID string `json:"-"`
+	// Principal is set by the trusted code, it is never decoded
+	Principal *Principal `json:"-"`
 }
 
 // GetAggregateID returns the command aggregate ID
@@ -54,3 +67,37 @@ func (b *BaseCommand) GetID() string {
 func (b *BaseCommand) GenerateUUID() {
 	b.ID = GenerateUUID()
 }
+
+// GetMetadata returns the command metadata
+func (b *BaseCommand) GetMetadata() map[string]string {
+	return b.Metadata
+}
+
+// SetMetadata adds a value to the command metadata
+func (b *BaseCommand) SetMetadata(key, value string) {
+	if b.Metadata == nil {
+		b.Metadata = make(map[string]string)
+	}
+
+	b.Metadata[key] = value
+}
+
+// GetPrincipal returns who sends the command
+func (b *BaseCommand) GetPrincipal() *Principal {
+	return b.Principal
+}
+
+// SetPrincipal sets who sends the command
+func (b *BaseCommand) SetPrincipal(principal *Principal) {
+	b.Principal = principal
+}
+
+// GetTenantID returns the tenant of the command
+func (b *BaseCommand) GetTenantID() string {
+	return b.TenantID
+}
+
+// SetTenantID sets the tenant of the command
+func (b *BaseCommand) SetTenantID(id string) {
+	b.TenantID = id
+}
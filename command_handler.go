@@ -20,15 +20,41 @@ type CommandHandlerRegister interface {
 type CommandRegister struct {
 	mu       sync.RWMutex
 	registry map[string]CommandHandler
+	types    CommandTypeRegister
 }
 
 // NewCommandRegister creates a new CommandHandler
 func NewCommandRegister() *CommandRegister {
 	return &CommandRegister{
 		registry: make(map[string]CommandHandler),
+		types:    NewCommandTypeRegister(),
 	}
 }
 
+// Types returns the register of the command types
+func (c *CommandRegister) Types() CommandTypeRegister {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.types
+}
+
+// UseTypes replaces the register of the command types, the types
+// already registered are copied to it
+func (c *CommandRegister) UseTypes(types CommandTypeRegister) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, name := range c.types.Commands() {
+		command, err := c.types.New(name)
+		if err == nil {
+			types.Set(command)
+		}
+	}
+
+	c.types = types
+}
+
 // Add a new command with its handler
 func (c *CommandRegister) Add(command interface{}, handler CommandHandler) {
 	c.mu.Lock()
@@ -0,0 +1,159 @@
+package triper
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// commandInterface is used to check that the registered types are commands
+var commandInterface = reflect.TypeOf((*Command)(nil)).Elem()
+
+// CommandTypeRegister defines the register for the command types, it
+// creates new commands from the name returned by GetTypeName
+type CommandTypeRegister interface {
+	Register
+	Commands() []string
+	New(name string) (Command, error)
+}
+
+// CommandType implements the CommandTypeRegister interface
+type CommandType struct {
+	mu       sync.RWMutex
+	registry map[string]reflect.Type
+}
+
+// NewCommandTypeRegister gets a CommandTypeRegister interface
+func NewCommandTypeRegister() CommandTypeRegister {
+	return &CommandType{
+		registry: make(map[string]reflect.Type),
+	}
+}
+
+// Set a new type, it panics if a pointer to the type does not implement Command
+func (c *CommandType) Set(source interface{}) {
+	rawType, name := GetTypeName(source)
+	if !reflect.PtrTo(rawType).Implements(commandInterface) {
+		panic(fmt.Sprintf("triper: %s does not implement triper.Command", rawType))
+	}
+
+	c.mu.Lock()
+	c.registry[name] = rawType
+	c.mu.Unlock()
+}
+
+// Get a new command based on its name
+func (c *CommandType) Get(name string) (interface{}, error) {
+	return c.New(name)
+}
+
+// New returns a new command based on its name
+func (c *CommandType) New(name string) (Command, error) {
+	c.mu.RLock()
+	rawType, ok := c.registry[name]
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("can't find %s in registry", name)
+	}
+
+	return reflect.New(rawType).Interface().(Command), nil
+}
+
+// Count the quantity of commands registered
+func (c *CommandType) Count() int {
+	c.mu.RLock()
+	count := len(c.registry)
+	c.mu.RUnlock()
+
+	return count
+}
+
+// Commands registered
+func (c *CommandType) Commands() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	values := make([]string, 0, len(c.registry))
+	for key := range c.registry {
+		values = append(values, key)
+	}
+
+	return values
+}
+
+// CommandCodec encodes and decodes commands as json
+type CommandCodec struct {
+	reg CommandTypeRegister
+}
+
+// commandEnvelope keeps the type name, the tenant and the principal next
+// to the command, they are not part of the command json
+type commandEnvelope struct {
+	Type      string          `json:"type"`
+	TenantID  string          `json:"tenant_id,omitempty"`
+	Principal *Principal      `json:"principal,omitempty"`
+	Command   json.RawMessage `json:"command"`
+}
+
+// NewCommandCodec returns a codec for the commands in reg
+func NewCommandCodec(reg CommandTypeRegister) *CommandCodec {
+	return &CommandCodec{
+		reg: reg,
+	}
+}
+
+// Marshal encodes a command with its type name, like:
+// {"type": "perform_deposit", "command": {...}}
+func (c *CommandCodec) Marshal(command Command) ([]byte, error) {
+	blob, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+
+	_, name := GetTypeName(command)
+	return json.Marshal(commandEnvelope{
+		Type:      name,
+		TenantID:  TenantOf(command),
+		Principal: PrincipalOf(command),
+		Command:   blob,
+	})
+}
+
+// Unmarshal decodes a command encoded by Marshal
+func (c *CommandCodec) Unmarshal(blob []byte) (Command, error) {
+	var envelope commandEnvelope
+	if err := json.Unmarshal(blob, &envelope); err != nil {
+		return nil, err
+	}
+
+	command, err := c.UnmarshalType(envelope.Type, envelope.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	if t, ok := command.(CommandTenant); ok {
+		t.SetTenantID(envelope.TenantID)
+	}
+
+	if p, ok := command.(CommandPrincipal); ok {
+		p.SetPrincipal(envelope.Principal)
+	}
+
+	return command, nil
+}
+
+// UnmarshalType decodes a command of a known type, blob contains only the command
+func (c *CommandCodec) UnmarshalType(name string, blob []byte) (Command, error) {
+	command, err := c.reg.New(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(blob, command); err != nil {
+		return nil, err
+	}
+
+	return command, nil
+}
@@ -0,0 +1,67 @@
+package triper
+
+import "testing"
+
+type OpenAccount struct {
+	BaseCommand
+	Owner string
+}
+
+func TestCommandTypeRegister(t *testing.T) {
+	reg := NewCommandTypeRegister()
+	reg.Set(OpenAccount{})
+
+	if count := reg.Count(); count != 1 {
+		t.Error("expected: 1, got: ", count)
+	}
+
+	if _, err := reg.New("close_account"); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	command, err := reg.New("open_account")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if _, ok := command.(*OpenAccount); !ok {
+		t.Errorf("expected *OpenAccount, got %T", command)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic registering a type that is not a command")
+		}
+	}()
+	reg.Set(SubEvent{})
+}
+
+func TestCommandCodec(t *testing.T) {
+	reg := NewCommandTypeRegister()
+	reg.Set(&OpenAccount{})
+	codec := NewCommandCodec(reg)
+
+	command := &OpenAccount{Owner: "mishudark"}
+	command.AggregateID = "account-1"
+	command.SetTenantID("acme")
+	command.SetPrincipal(&Principal{ID: "ana", Roles: []string{"teller"}})
+
+	blob, err := codec.Marshal(command)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	decoded, err := codec.Unmarshal(blob)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	account, ok := decoded.(*OpenAccount)
+	if !ok || account.Owner != "mishudark" || account.AggregateID != "account-1" || account.TenantID != "acme" {
+		t.Errorf("unexpected command: %+v", decoded)
+	}
+
+	if !account.GetPrincipal().HasRole("teller") || account.GetPrincipal().ID != "ana" {
+		t.Errorf("expected the principal, got %+v", account.GetPrincipal())
+	}
+}
@@ -5,3 +5,9 @@ package triper
 type CommandBus interface {
 	HandleCommand(command Command) (id string)
 }
+
+// CommandDispatcher is implemented by the synchronous command buses, they
+// return the Failure produced by the handler
+type CommandDispatcher interface {
+	Dispatch(command Command) (id string, err error)
+}
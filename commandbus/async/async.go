@@ -1,73 +1,331 @@
 package async
 
-import "github.com/mishudark/triper"
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
 
-var workerPool = make(chan chan triper.Command)
+	"github.com/mishudark/triper"
+)
+
+// ErrQueueFull is returned when the queue has no room for the command
+var ErrQueueFull = errors.New("async: command queue is full")
+
+// ErrQueueTimeout is returned when the queue had no room for the command during the timeout
+var ErrQueueTimeout = errors.New("async: timeout waiting for room in the command queue")
+
+// Policy defines what to do with a command when the queue is full
+type Policy int
+
+// nolint
+const (
+	// PolicyBlock waits until there is room in the queue
+	PolicyBlock Policy = iota
+	// PolicyBlockWithTimeout waits until there is room in the queue or the timeout expires
+	PolicyBlockWithTimeout
+	// PolicyReject returns an error inmediately
+	PolicyReject
+)
+
+// DefaultCapacity is the number of commands the queue holds by default
+const DefaultCapacity = 1000
+
+// DefaultTimeout is the wait of PolicyBlockWithTimeout when Options.Timeout
+// is not set
+const DefaultTimeout = 5 * time.Second
+
+// Options to configure the command queue
+type Options struct {
+	// Capacity is the max number of commands waiting for a worker
+	Capacity int
+	// Policy applied when the queue is full
+	Policy Policy
+	// Timeout used by PolicyBlockWithTimeout, DefaultTimeout when it is
+	// zero, so the policy never rejects without waiting
+	Timeout time.Duration
+	// Logger used by the workers, it can be replaced later with SetLogger
+	Logger triper.Logger
+}
+
+// DefaultOptions returns a queue that blocks when it is full
+func DefaultOptions() Options {
+	return Options{
+		Capacity: DefaultCapacity,
+		Policy:   PolicyBlock,
+		Timeout:  DefaultTimeout,
+	}
+}
+
+// Stats contains the state of the queue
+type Stats struct {
+	// Depth is the number of commands waiting for a worker
+	Depth    int
+	Capacity int
+	// Rejected commands because the queue was full
+	Rejected uint64
+	// Dequeued commands taken by a worker
+	Dequeued uint64
+	// WaitTime is the total time the dequeued commands spent in the queue
+	WaitTime time.Duration
+	// MaxWaitTime is the longest time a command spent in the queue
+	MaxWaitTime time.Duration
+}
+
+// AverageWaitTime of the dequeued commands
+func (s Stats) AverageWaitTime() time.Duration {
+	if s.Dequeued == 0 {
+		return 0
+	}
+
+	return s.WaitTime / time.Duration(s.Dequeued)
+}
+
+// Job is a command waiting in the queue
+type Job struct {
+	Command  triper.Command
+	QueuedAt time.Time
+}
 
 // Worker contains the basic info to manage commands
 type Worker struct {
-	WorkerPool     chan chan triper.Command
-	JobChannel     chan triper.Command
+	JobChannel     chan Job
 	CommandHandler triper.CommandHandlerRegister
+	dequeued       func(Job)
+	failed         func(Job, string, error)
 }
 
 // Bus stores the command handler
 type Bus struct {
+	// counters are accessed atomically, keep them first to be 64-bit aligned
+	rejected    uint64
+	dequeued    uint64
+	waitTime    int64
+	maxWaitTime int64
+
 	CommandHandler triper.CommandHandlerRegister
 	maxWorkers     int
+	options        Options
+	workers        []*Worker
+	next           uint32
+
+	// slots has a buffer for each command in the queue
+	slots chan struct{}
+
+	mu     sync.RWMutex
+	logger triper.Logger
 }
 
-// Start initialize a worker ready to receive jobs
+// Start initialize a worker ready to receive jobs, the jobs are processed
+// in the same order they arrive. The commands are validated by the
+// handler, so the failure can be published
 func (w *Worker) Start() {
 	go func() {
-		for {
-			w.WorkerPool <- w.JobChannel
-
-			job := <-w.JobChannel
-			handler, err := w.CommandHandler.GetHandler(job)
-			if err != nil {
-				continue
+		for job := range w.JobChannel {
+			if w.dequeued != nil {
+				w.dequeued(job)
 			}
 
-			if !job.IsValid() {
+			handler, err := w.CommandHandler.GetHandler(job.Command)
+			if err != nil {
+				w.fail(job, "command handler not found", err)
 				continue
 			}
 
-			if err = handler.Handle(job); err != nil {
-				//TODO: log the error
+			if err = handler.Handle(job.Command); err != nil {
+				w.fail(job, "command not handled", err)
 			}
 		}
 	}()
 }
 
-// NewWorker initialize the values of worker and start it
-func NewWorker(commandHandler triper.CommandHandlerRegister) {
-	w := Worker{
-		WorkerPool:     workerPool,
+func (w *Worker) fail(job Job, msg string, err error) {
+	if w.failed != nil {
+		w.failed(job, msg, err)
+	}
+}
+
+// NewWorker initialize the values of worker and start it, dequeued is called
+// every time the worker takes a job from its channel and failed when a job
+// can't be handled
+func NewWorker(commandHandler triper.CommandHandlerRegister, queueSize int, dequeued func(Job), failed func(Job, string, error)) *Worker {
+	w := &Worker{
 		CommandHandler: commandHandler,
-		JobChannel:     make(chan triper.Command),
+		JobChannel:     make(chan Job, queueSize),
+		dequeued:       dequeued,
+		failed:         failed,
 	}
 
 	w.Start()
+	return w
 }
 
-// HandleCommand ad a job to the queue
+// HandleCommand ad a job to the queue, commands for the same aggregate
+// are always handled by the same worker to keep them in arrival order.
+// It returns an empty id if the command was rejected, use Enqueue to
+// get the reason
 func (b *Bus) HandleCommand(command triper.Command) (id string) {
+	id, _ = b.Enqueue(command)
+	return id
+}
+
+// Enqueue ad a job to the queue applying the configured policy when the
+// queue is full, the returned error is a triper.Failure of type
+// triper.FailureQueueFull
+func (b *Bus) Enqueue(command triper.Command) (id string, err error) {
 	// generate an unique identifier to trace the command
 	command.GenerateUUID()
-	go func(c triper.Command) {
-		workerJobQueue := <-workerPool
-		workerJobQueue <- c
-	}(command)
 
-	return command.GetID()
+	if err = b.acquire(); err != nil {
+		atomic.AddUint64(&b.rejected, 1)
+		err = triper.NewFailure(err, triper.FailureQueueFull, command)
+
+		fields := append(triper.CommandFields(command), triper.ErrorFields(err)...)
+		b.getLogger().Warn("command rejected", fields...)
+		return "", err
+	}
+
+	b.shard(command.GetAggregateID()).JobChannel <- Job{
+		Command:  command,
+		QueuedAt: time.Now(),
+	}
+
+	return command.GetID(), nil
+}
+
+// acquire a slot in the queue according to the policy
+func (b *Bus) acquire() error {
+	switch b.options.Policy {
+	case PolicyReject:
+		select {
+		case b.slots <- struct{}{}:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	case PolicyBlockWithTimeout:
+		timer := time.NewTimer(b.options.Timeout)
+		defer timer.Stop()
+
+		select {
+		case b.slots <- struct{}{}:
+			return nil
+		case <-timer.C:
+			return ErrQueueTimeout
+		}
+	default:
+		b.slots <- struct{}{}
+		return nil
+	}
+}
+
+// release the slot used by a job and record the time it waited
+func (b *Bus) release(job Job) {
+	<-b.slots
+
+	wait := int64(time.Since(job.QueuedAt))
+	atomic.AddUint64(&b.dequeued, 1)
+	atomic.AddInt64(&b.waitTime, wait)
+
+	for {
+		max := atomic.LoadInt64(&b.maxWaitTime)
+		if wait <= max || atomic.CompareAndSwapInt64(&b.maxWaitTime, max, wait) {
+			break
+		}
+	}
+}
+
+// failed logs the jobs that can't be handled, handlers log their own
+// failures so those are logged with debug level
+func (b *Bus) failed(job Job, msg string, err error) {
+	fields := triper.CommandFields(job.Command)
+	if err != nil {
+		fields = append(fields, triper.ErrorFields(err)...)
+	}
+
+	if _, ok := err.(triper.Failure); ok {
+		b.getLogger().Debug(msg, fields...)
+		return
+	}
+
+	b.getLogger().Error(msg, fields...)
+}
+
+// SetLogger used by the bus and its workers
+func (b *Bus) SetLogger(logger triper.Logger) {
+	b.mu.Lock()
+	b.logger = logger
+	b.mu.Unlock()
+}
+
+func (b *Bus) getLogger() triper.Logger {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.logger
+}
+
+// Depth returns the number of commands waiting for a worker
+func (b *Bus) Depth() int {
+	return len(b.slots)
+}
+
+// Stats returns the current state of the queue
+func (b *Bus) Stats() Stats {
+	return Stats{
+		Depth:       len(b.slots),
+		Capacity:    cap(b.slots),
+		Rejected:    atomic.LoadUint64(&b.rejected),
+		Dequeued:    atomic.LoadUint64(&b.dequeued),
+		WaitTime:    time.Duration(atomic.LoadInt64(&b.waitTime)),
+		MaxWaitTime: time.Duration(atomic.LoadInt64(&b.maxWaitTime)),
+	}
+}
+
+// shard returns the worker in charge of an aggregate, commands without
+// aggregate id are spread across all the workers
+func (b *Bus) shard(aggregateID string) *Worker {
+	if aggregateID == "" {
+		n := atomic.AddUint32(&b.next, 1)
+		return b.workers[n%uint32(len(b.workers))]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(aggregateID))
+	return b.workers[h.Sum32()%uint32(len(b.workers))]
 }
 
 // NewBus return a bus with command handler register
 func NewBus(register triper.CommandHandlerRegister, maxWorkers int) *Bus {
+	return NewBusWithOptions(register, maxWorkers, DefaultOptions())
+}
+
+// NewBusWithOptions return a bus with command handler register and a queue
+// configured with options
+func NewBusWithOptions(register triper.CommandHandlerRegister, maxWorkers int, options Options) *Bus {
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+
+	if options.Capacity < 1 {
+		options.Capacity = DefaultCapacity
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+
+	if options.Logger == nil {
+		options.Logger = triper.NopLogger{}
+	}
+
 	b := &Bus{
 		CommandHandler: register,
 		maxWorkers:     maxWorkers,
+		options:        options,
+		slots:          make(chan struct{}, options.Capacity),
+		logger:         options.Logger,
 	}
 
 	// start the bus
@@ -77,7 +335,9 @@ func NewBus(register triper.CommandHandlerRegister, maxWorkers int) *Bus {
 
 // Start the bus
 func (b *Bus) Start() {
+	b.workers = make([]*Worker, b.maxWorkers)
 	for i := 0; i < b.maxWorkers; i++ {
-		NewWorker(b.CommandHandler)
+		// every worker can hold the whole queue, the slots limit the total
+		b.workers[i] = NewWorker(b.CommandHandler, b.options.Capacity, b.release, b.failed)
 	}
 }
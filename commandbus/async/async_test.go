@@ -0,0 +1,193 @@
+package async
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+type testCommand struct {
+	triper.BaseCommand
+}
+
+type handlerStub struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	handled map[string][]int
+}
+
+func (h *handlerStub) Handle(command triper.Command) error {
+	defer h.wg.Done()
+
+	// give other workers the chance to run
+	time.Sleep(time.Microsecond)
+
+	h.mu.Lock()
+	h.handled[command.GetAggregateID()] = append(h.handled[command.GetAggregateID()], command.GetVersion())
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *handlerStub) Add(command interface{}, handler triper.CommandHandler) {}
+
+func (h *handlerStub) GetHandler(command interface{}) (triper.CommandHandler, error) {
+	return h, nil
+}
+
+func TestBusKeepsAggregateOrder(t *testing.T) {
+	stub := &handlerStub{
+		handled: make(map[string][]int),
+	}
+	bus := NewBus(stub, 4)
+
+	aggregates := []string{"a", "b", "c", "d", "e", "f"}
+	commands := 50
+
+	stub.wg.Add(len(aggregates) * commands)
+	for version := 0; version < commands; version++ {
+		for _, id := range aggregates {
+			command := &testCommand{}
+			command.AggregateID = id
+			command.Version = version
+			bus.HandleCommand(command)
+		}
+	}
+	stub.wg.Wait()
+
+	for _, id := range aggregates {
+		versions := stub.handled[id]
+		if len(versions) != commands {
+			t.Errorf("[%s] expected: %d commands, got: %d", id, commands, len(versions))
+			continue
+		}
+
+		for i, version := range versions {
+			if version != i {
+				t.Errorf("[%s] expected version: %d, got: %d", id, i, version)
+				break
+			}
+		}
+	}
+}
+
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) Handle(command triper.Command) error {
+	h.started <- struct{}{}
+	<-h.release
+	return nil
+}
+
+func (h *blockingHandler) Add(command interface{}, handler triper.CommandHandler) {}
+
+func (h *blockingHandler) GetHandler(command interface{}) (triper.CommandHandler, error) {
+	return h, nil
+}
+
+func TestBusRejectsWhenFull(t *testing.T) {
+	stub := &blockingHandler{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	bus := NewBusWithOptions(stub, 1, Options{
+		Capacity: 1,
+		Policy:   PolicyReject,
+	})
+
+	// the first command is taken by the worker, the second one waits in the queue
+	if _, err := bus.Enqueue(&testCommand{}); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	<-stub.started
+
+	if _, err := bus.Enqueue(&testCommand{}); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	id, err := bus.Enqueue(&testCommand{})
+	failure, ok := err.(triper.Failure)
+	if !ok {
+		t.Fatal("expected triper.Failure, got", err)
+	}
+
+	if failure.Type != triper.FailureQueueFull || failure.Err != ErrQueueFull {
+		t.Error("expected queue full failure, got", failure)
+	}
+
+	if id != "" {
+		t.Error("expected empty id, got", id)
+	}
+
+	stats := bus.Stats()
+	if stats.Depth != 1 || stats.Rejected != 1 {
+		t.Errorf("expected depth: 1 rejected: 1, got depth: %d rejected: %d", stats.Depth, stats.Rejected)
+	}
+
+	close(stub.release)
+}
+
+func TestBusTimeoutWhenFull(t *testing.T) {
+	stub := &blockingHandler{
+		started: make(chan struct{}, 2),
+		release: make(chan struct{}),
+	}
+	bus := NewBusWithOptions(stub, 1, Options{
+		Capacity: 1,
+		Policy:   PolicyBlockWithTimeout,
+		Timeout:  10 * time.Millisecond,
+	})
+
+	bus.Enqueue(&testCommand{})
+	<-stub.started
+	bus.Enqueue(&testCommand{})
+
+	_, err := bus.Enqueue(&testCommand{})
+	if failure, ok := err.(triper.Failure); !ok || failure.Err != ErrQueueTimeout {
+		t.Error("expected timeout failure, got", err)
+	}
+
+	close(stub.release)
+}
+
+func TestBusZeroTimeout(t *testing.T) {
+	stub := &blockingHandler{
+		started: make(chan struct{}, 3),
+		release: make(chan struct{}),
+	}
+	bus := NewBusWithOptions(stub, 1, Options{
+		Capacity: 1,
+		Policy:   PolicyBlockWithTimeout,
+	})
+
+	if bus.options.Timeout != DefaultTimeout {
+		t.Error("expected DefaultTimeout, got", bus.options.Timeout)
+	}
+
+	bus.Enqueue(&testCommand{})
+	<-stub.started
+	bus.Enqueue(&testCommand{})
+
+	// the command waits for room instead of being rejected
+	errs := make(chan error, 1)
+	go func() {
+		_, err := bus.Enqueue(&testCommand{})
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		t.Fatal("expected the command to wait, got", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(stub.release)
+
+	if err := <-errs; err != nil {
+		t.Error("expected nil, got", err)
+	}
+}
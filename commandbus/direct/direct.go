@@ -0,0 +1,37 @@
+package direct
+
+import "github.com/mishudark/triper"
+
+// Bus handles the commands synchronously in the caller goroutine
+type Bus struct {
+	CommandHandler triper.CommandHandlerRegister
+}
+
+var _ triper.CommandDispatcher = (*Bus)(nil)
+
+// NewBus return a bus with command handler register
+func NewBus(register triper.CommandHandlerRegister) *Bus {
+	return &Bus{
+		CommandHandler: register,
+	}
+}
+
+// HandleCommand handles the command and returns its id, use Dispatch to
+// get the failure
+func (b *Bus) HandleCommand(command triper.Command) (id string) {
+	id, _ = b.Dispatch(command)
+	return id
+}
+
+// Dispatch handles the command and returns the error produced by its handler
+func (b *Bus) Dispatch(command triper.Command) (id string, err error) {
+	// generate an unique identifier to trace the command
+	command.GenerateUUID()
+
+	handler, err := b.CommandHandler.GetHandler(command)
+	if err != nil {
+		return command.GetID(), err
+	}
+
+	return command.GetID(), handler.Handle(command)
+}
@@ -3,7 +3,6 @@ package basic
 import (
 	"errors"
 	"fmt"
-	"github.com/golang/glog"
 	"reflect"
 
 	"github.com/mishudark/triper"
@@ -29,7 +28,8 @@ func NewCommandHandler(repository *triper.Repository, aggregate triper.Aggregate
 	}
 }
 
-// Handle a command, if any error is produced, it will be published to the errors bucket
+// Handle a command, if any error is produced, it will be published as a failure
+// of the aggregate, the router of the repository chooses the destination
 func (h *Handler) Handle(command triper.Command) (err error) {
 
 	version := command.GetVersion()
@@ -37,13 +37,22 @@ func (h *Handler) Handle(command triper.Command) (err error) {
 
 	defer func() {
 		if err != nil {
-			glog.Errorln(err)
-			h.repository.PublishError(err, command, h.bucket, "errors")
+			fields := append(triper.CommandFields(command), triper.ErrorFields(err)...)
+			h.repository.Logger().Error("command failed", fields...)
+
+			if perr := h.repository.PublishError(err, command, h.bucket, h.subset); perr != nil {
+				fields = append(triper.CommandFields(command), triper.LogError, perr)
+				h.repository.Logger().Error("failure not published", fields...)
+			}
 		}
 	}()
 
+	if err = triper.ValidateCommand(command); err != nil {
+		return triper.NewFailure(err, triper.FailureInvalidCommand, command)
+	}
+
 	if version != 0 {
-		if err = h.repository.Load(aggregate, command.GetAggregateID()); err != nil {
+		if err = h.repository.LoadTenant(aggregate, triper.TenantOf(command), command.GetAggregateID()); err != nil {
 			return triper.NewFailure(err, triper.FailureLoadingEvents, command)
 		}
 
@@ -57,6 +66,13 @@ func (h *Handler) Handle(command triper.Command) (err error) {
 		return triper.NewFailure(aggregate.GetError(), triper.FailureReplayingEvents, command)
 	}
 
+	// the policies can check the loaded aggregate, like its owner
+	if authorizer := h.repository.Authorizer(); authorizer != nil {
+		if err = authorizer.Authorize(command, aggregate); err != nil {
+			return triper.NewFailure(err, triper.FailureForbidden, command)
+		}
+	}
+
 	if err = aggregate.HandleCommand(command); err != nil {
 		return triper.NewFailure(err, triper.FailureProcessingCommand, command)
 	}
@@ -71,8 +87,13 @@ func (h *Handler) Handle(command triper.Command) (err error) {
 		return triper.NewFailure(ErrInvalidID, triper.FailureInvalidID, command)
 	}
 
-	// add the command id for traceability
+	// add the command id and metadata for traceability, the events
+	// belong to the tenant of the command
 	aggregate.AttachCommandID(command.GetID())
+	aggregate.AttachTenantID(triper.TenantOf(command))
+	if c, ok := command.(triper.CommandMetadata); ok {
+		aggregate.AttachMetadata(c.GetMetadata())
+	}
 
 	// save the changes using the repository
 	if err = h.repository.Save(aggregate, version); err != nil {
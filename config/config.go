@@ -1,12 +1,22 @@
 package config
 
 import (
+	"os"
+
 	"github.com/mishudark/triper"
 	"github.com/mishudark/triper/commandbus/async"
+	"github.com/mishudark/triper/commandbus/direct"
+	"github.com/mishudark/triper/eventbus"
+	"github.com/mishudark/triper/eventbus/jetstream"
+	"github.com/mishudark/triper/eventbus/kafka"
 	"github.com/mishudark/triper/eventbus/mosquitto"
 	"github.com/mishudark/triper/eventbus/nats"
 	"github.com/mishudark/triper/eventbus/rabbitmq"
+	"github.com/mishudark/triper/eventbus/webhook"
 	"github.com/mishudark/triper/eventstore/badger"
+	"github.com/mishudark/triper/logger"
+	"github.com/mishudark/triper/metrics"
+	"github.com/mishudark/triper/tracing"
 )
 
 // EventBus returns an triper.EventBus impl
@@ -30,30 +40,77 @@ func WireCommands(aggregate triper.AggregateHandler, handler commandHandler, buc
 		h := handler(repository, aggregate, bucket, subset)
 		for _, command := range commands {
 			register.Add(command, h)
+			register.Types().Set(command)
 		}
 	}
 }
 
-// NewClient returns a command bus properly configured
+// CommandTypes makes WireCommands register the command types in types,
+// so they can be decoded by name, like in the http gateway or the scheduler
+func CommandTypes(types triper.CommandTypeRegister) CommandConfig {
+	return func(repository *triper.Repository, register *triper.CommandRegister) {
+		register.UseTypes(types)
+	}
+}
+
+// Authorization makes the command handlers authorize the commands with
+// authorizer before handling them
+func Authorization(authorizer *triper.Authorizer) CommandConfig {
+	return func(repository *triper.Repository, register *triper.CommandRegister) {
+		repository.SetAuthorizer(authorizer)
+	}
+}
+
+// Routing makes the repository publish the events where router says,
+// like a triper.Router with templates
+func Routing(router triper.EventRouter) CommandConfig {
+	return func(repository *triper.Repository, register *triper.CommandRegister) {
+		repository.SetRouter(router)
+	}
+}
+
+// NewClient returns a command bus properly configured, the errors are
+// logged to stderr
 func NewClient(es EventStore, eb EventBus, cb CommandBus, cmdConfigs ...CommandConfig) (triper.CommandBus, error) {
+	return NewClientWithLogger(logger.New(os.Stderr, logger.LevelInfo), es, eb, cb, cmdConfigs...)
+}
+
+// NewClientWithLogger returns a command bus properly configured, the
+// logger is injected in every component that implements triper.Loggable
+func NewClientWithLogger(l triper.Logger, es EventStore, eb EventBus, cb CommandBus, cmdConfigs ...CommandConfig) (triper.CommandBus, error) {
 	store, err := es()
 	if err != nil {
 		return nil, err
 	}
+	setLogger(store, l)
 
 	bus, err := eb()
 	if err != nil {
 		return nil, err
 	}
+	setLogger(bus, l)
 
 	repository := triper.NewRepository(store, bus)
+	repository.SetLogger(l)
 	register := triper.NewCommandRegister()
 
 	for _, conf := range cmdConfigs {
 		conf(repository, register)
 	}
 
-	return cb(register)
+	commandBus, err := cb(register)
+	if err != nil {
+		return nil, err
+	}
+	setLogger(commandBus, l)
+
+	return commandBus, nil
+}
+
+func setLogger(component interface{}, l triper.Logger) {
+	if loggable, ok := component.(triper.Loggable); ok {
+		loggable.SetLogger(l)
+	}
 }
 
 // RabbitMq generates a RabbitMq implementation of EventBus
@@ -63,6 +120,15 @@ func RabbitMq(username, password, host string, port int) EventBus {
 	}
 }
 
+// RabbitMqWithOptions generates a RabbitMq implementation of EventBus with
+// the exchange type, channel pool, confirmations and TLS configured by
+// options
+func RabbitMqWithOptions(username, password, host string, port int, options rabbitmq.Options) EventBus {
+	return func() (triper.EventBus, error) {
+		return rabbitmq.NewClientWithOptions(username, password, host, port, options)
+	}
+}
+
 // Nats generates a Nats implementation of EventBus
 func Nats(urls string, useTLS bool) EventBus {
 	return func() (triper.EventBus, error) {
@@ -70,13 +136,54 @@ func Nats(urls string, useTLS bool) EventBus {
 	}
 }
 
-// Mosquitto generates a Mosquitto implementation of EventBus
+// NatsWithOptions generates a Nats implementation of EventBus with the
+// reconnection and buffer configured by options
+func NatsWithOptions(urls string, useTLS bool, options nats.Options) EventBus {
+	return func() (triper.EventBus, error) {
+		return nats.NewClientWithOptions(urls, useTLS, options)
+	}
+}
+
+// JetStream generates a NATS JetStream implementation of EventBus, the
+// events are stored in a stream per bucket
+func JetStream(urls string, useTLS bool, options jetstream.Options) EventBus {
+	return func() (triper.EventBus, error) {
+		return jetstream.NewClient(urls, useTLS, options)
+	}
+}
+
+// Kafka generates a Kafka implementation of EventBus, the events of an
+// aggregate are published to the same partition
+func Kafka(brokers []string, options kafka.Options) EventBus {
+	return func() (triper.EventBus, error) {
+		return kafka.NewClient(brokers, options)
+	}
+}
+
+// Webhook generates an EventBus that posts the events to http endpoints,
+// the deliveries are retried in the background
+func Webhook(options webhook.Options) EventBus {
+	return func() (triper.EventBus, error) {
+		return webhook.NewClient(options)
+	}
+}
+
+// Mosquitto generates a Mosquitto implementation of EventBus, a fixed
+// clientID must be unique, an empty one is generated for every instance
 func Mosquitto(method string, host string, port int, clientID string) EventBus {
 	return func() (triper.EventBus, error) {
 		return mosquitto.NewClientWithPort(method, host, port, clientID)
 	}
 }
 
+// MosquittoWithOptions generates a Mosquitto implementation of EventBus
+// with the reconnection and buffer configured by options
+func MosquittoWithOptions(method string, host string, port int, clientID string, options mosquitto.Options) EventBus {
+	return func() (triper.EventBus, error) {
+		return mosquitto.NewClientWithOptions(method, host, port, clientID, options)
+	}
+}
+
 // Badger generates a BadgerDB implementation of EventStore
 func Badger(dbDir string, reg triper.Register) EventStore {
 	return func() (triper.EventStore, error) {
@@ -90,3 +197,135 @@ func AsyncCommandBus(workers int) CommandBus {
 		return async.NewBus(register, workers), nil
 	}
 }
+
+// AsyncCommandBusWithOptions generates a CommandBus with a bounded queue
+func AsyncCommandBusWithOptions(workers int, options async.Options) CommandBus {
+	return func(register triper.CommandHandlerRegister) (triper.CommandBus, error) {
+		return async.NewBusWithOptions(register, workers, options), nil
+	}
+}
+
+// InstrumentedEventStore records the latency and errors of an EventStore
+func InstrumentedEventStore(es EventStore, m *metrics.Metrics) EventStore {
+	return func() (triper.EventStore, error) {
+		store, err := es()
+		if err != nil {
+			return nil, err
+		}
+
+		return m.EventStore(store), nil
+	}
+}
+
+// RetryingEventBus publishes again the events that eb fails to publish,
+// with a jittered exponential backoff
+func RetryingEventBus(eb EventBus, options eventbus.RetryOptions) EventBus {
+	return func() (triper.EventBus, error) {
+		bus, err := eb()
+		if err != nil {
+			return nil, err
+		}
+
+		return eventbus.NewRetry(bus, options), nil
+	}
+}
+
+// CircuitBreakerEventBus fails fast with eventbus.ErrCircuitOpen while eb
+// keeps failing
+func CircuitBreakerEventBus(eb EventBus, options eventbus.BreakerOptions) EventBus {
+	return func() (triper.EventBus, error) {
+		bus, err := eb()
+		if err != nil {
+			return nil, err
+		}
+
+		return eventbus.NewBreaker(bus, options), nil
+	}
+}
+
+// SpillingEventBus writes to disk the events that eb fails to publish and
+// replays them once it recovers
+func SpillingEventBus(eb EventBus, options eventbus.SpillOptions) EventBus {
+	return func() (triper.EventBus, error) {
+		bus, err := eb()
+		if err != nil {
+			return nil, err
+		}
+
+		spill, err := eventbus.NewSpill(bus, options)
+		if err != nil {
+			return nil, err
+		}
+
+		return spill, nil
+	}
+}
+
+// InstrumentedEventBus records the latency and errors of an EventBus, name
+// is used as label to tell apart the buses
+func InstrumentedEventBus(eb EventBus, name string, m *metrics.Metrics) EventBus {
+	return func() (triper.EventBus, error) {
+		bus, err := eb()
+		if err != nil {
+			return nil, err
+		}
+
+		return m.EventBus(bus, name), nil
+	}
+}
+
+// InstrumentedCommandBus counts the commands received by a CommandBus
+func InstrumentedCommandBus(cb CommandBus, m *metrics.Metrics) CommandBus {
+	return func(register triper.CommandHandlerRegister) (triper.CommandBus, error) {
+		bus, err := cb(register)
+		if err != nil {
+			return nil, err
+		}
+
+		return m.CommandBus(bus), nil
+	}
+}
+
+// TracedEventStore traces the saves and loads of an EventStore
+func TracedEventStore(es EventStore, t *tracing.Tracer) EventStore {
+	return func() (triper.EventStore, error) {
+		store, err := es()
+		if err != nil {
+			return nil, err
+		}
+
+		return t.EventStore(store), nil
+	}
+}
+
+// TracedEventBus traces the events published by an EventBus and propagates
+// the trace context to the consumers
+func TracedEventBus(eb EventBus, name string, t *tracing.Tracer) EventBus {
+	return func() (triper.EventBus, error) {
+		bus, err := eb()
+		if err != nil {
+			return nil, err
+		}
+
+		return t.EventBus(bus, name), nil
+	}
+}
+
+// TracedCommandBus traces the commands received by a CommandBus
+func TracedCommandBus(cb CommandBus, t *tracing.Tracer) CommandBus {
+	return func(register triper.CommandHandlerRegister) (triper.CommandBus, error) {
+		bus, err := cb(register)
+		if err != nil {
+			return nil, err
+		}
+
+		return t.CommandBus(bus), nil
+	}
+}
+
+// DirectCommandBus generates a CommandBus that handles the commands synchronously
+func DirectCommandBus() CommandBus {
+	return func(register triper.CommandHandlerRegister) (triper.CommandBus, error) {
+		return direct.NewBus(register), nil
+	}
+}
@@ -0,0 +1,257 @@
+package triper
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// nolint
+var (
+	ErrUnhandledEvent   = errors.New("event without Apply method")
+	ErrUnhandledCommand = errors.New("command without Handle method")
+)
+
+var (
+	eventType = reflect.TypeOf(Event{})
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Dispatcher routes the events and commands of an aggregate to its methods
+// by convention, so the aggregate does not need a type switch:
+//
+//	ApplyDepositPerformed(*DepositPerformed) [error]
+//	ApplyDepositPerformed(Event, *DepositPerformed) [error]
+//	HandlePerformDeposit(*PerformDeposit) error
+//
+// The aggregate opts in delegating Reduce and HandleCommand to it:
+//
+//	var accountDispatcher = triper.MustDispatcher(&Account{}, reg)
+//
+//	func (a *Account) Reduce(event triper.Event) error {
+//		return accountDispatcher.Reduce(a, event)
+//	}
+type Dispatcher struct {
+	aggregate reflect.Type
+	events    map[reflect.Type]method
+	commands  map[reflect.Type]method
+}
+
+// method is an entry of the dispatch table
+type method struct {
+	fn        reflect.Value
+	withEvent bool
+	withError bool
+}
+
+// DispatcherOptions configures the events that must have an Apply method
+type DispatcherOptions struct {
+	// Events that must have an Apply method
+	Events []interface{}
+	// Strict requires an Apply method for every event of the register, it
+	// is meant for a register used by a single aggregate
+	Strict bool
+}
+
+// NewDispatcher builds the dispatch table of the aggregate type. Every
+// Apply method must receive an event registered in reg, and the given
+// events must have an Apply method. The register can be shared by many
+// aggregates, its other events are not required
+func NewDispatcher(aggregate AggregateHandler, reg EventTypeRegister, events ...interface{}) (*Dispatcher, error) {
+	return NewDispatcherWithOptions(aggregate, reg, DispatcherOptions{Events: events})
+}
+
+// NewDispatcherWithOptions is like NewDispatcher, with options.Strict every
+// event of reg must have an Apply method
+func NewDispatcherWithOptions(aggregate AggregateHandler, reg EventTypeRegister, options DispatcherOptions) (*Dispatcher, error) {
+	d := &Dispatcher{
+		aggregate: reflect.TypeOf(aggregate),
+		events:    make(map[reflect.Type]method),
+		commands:  make(map[reflect.Type]method),
+	}
+
+	if d.aggregate.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("triper: aggregate %s must be a pointer", d.aggregate)
+	}
+
+	for i := 0; i < d.aggregate.NumMethod(); i++ {
+		m := d.aggregate.Method(i)
+
+		var err error
+		switch {
+		case m.Name == "HandleCommand":
+			continue
+		case strings.HasPrefix(m.Name, "Apply"):
+			err = d.addEvent(m, reg)
+		case strings.HasPrefix(m.Name, "Handle"):
+			err = d.addCommand(m)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("triper: %s.%s, %s", d.aggregate.Elem().Name(), m.Name, err)
+		}
+	}
+
+	required := options.Events
+	if options.Strict {
+		for _, name := range reg.Events() {
+			event, err := reg.Get(name)
+			if err != nil {
+				return nil, err
+			}
+
+			required = append(required, event)
+		}
+	}
+
+	for _, event := range required {
+		rawType, _ := GetTypeName(event)
+		if _, ok := d.events[rawType]; !ok {
+			return nil, fmt.Errorf("triper: %s has no method Apply%s", d.aggregate.Elem().Name(), rawType.Name())
+		}
+	}
+
+	return d, nil
+}
+
+// MustDispatcher is like NewDispatcher but it panics on error, it is meant
+// to initialize package variables
+func MustDispatcher(aggregate AggregateHandler, reg EventTypeRegister, events ...interface{}) *Dispatcher {
+	return MustDispatcherWithOptions(aggregate, reg, DispatcherOptions{Events: events})
+}
+
+// MustDispatcherWithOptions is like NewDispatcherWithOptions but it panics
+// on error
+func MustDispatcherWithOptions(aggregate AggregateHandler, reg EventTypeRegister, options DispatcherOptions) *Dispatcher {
+	d, err := NewDispatcherWithOptions(aggregate, reg, options)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
+
+func (d *Dispatcher) addEvent(m reflect.Method, reg EventTypeRegister) error {
+	// the receiver is the first argument
+	in := m.Type.NumIn() - 1
+	entry := method{fn: m.Func, withEvent: in == 2}
+
+	if in < 1 || in > 2 || (entry.withEvent && m.Type.In(1) != eventType) {
+		return errors.New("expected arguments ([triper.Event,] *EventType)")
+	}
+
+	switch m.Type.NumOut() {
+	case 0:
+	case 1:
+		if m.Type.Out(0) != errorType {
+			return errors.New("expected no results or error")
+		}
+
+		entry.withError = true
+	default:
+		return errors.New("expected no results or error")
+	}
+
+	data := m.Type.In(in)
+	if data.Kind() != reflect.Ptr || "Apply"+data.Elem().Name() != m.Name {
+		return fmt.Errorf("expected argument *%s", strings.TrimPrefix(m.Name, "Apply"))
+	}
+
+	_, name := GetTypeName(reflect.New(data.Elem()).Interface())
+	registered, err := reg.Get(name)
+	if err != nil || reflect.TypeOf(registered) != data {
+		return fmt.Errorf("event %s is not registered", data.Elem())
+	}
+
+	d.events[data.Elem()] = entry
+	return nil
+}
+
+func (d *Dispatcher) addCommand(m reflect.Method) error {
+	if m.Type.NumIn() != 2 || m.Type.NumOut() != 1 || m.Type.Out(0) != errorType {
+		return errors.New("expected signature (*CommandType) error")
+	}
+
+	data := m.Type.In(1)
+	if data.Kind() != reflect.Ptr || "Handle"+data.Elem().Name() != m.Name {
+		return fmt.Errorf("expected argument *%s", strings.TrimPrefix(m.Name, "Handle"))
+	}
+
+	if !data.Implements(commandInterface) {
+		return fmt.Errorf("%s does not implement triper.Command", data)
+	}
+
+	d.commands[data.Elem()] = method{fn: m.Func, withError: true}
+	return nil
+}
+
+// pointer returns value as a pointer to its type, the values that are not
+// pointers are copied
+func pointer(value interface{}) (reflect.Type, reflect.Value) {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr {
+		return v.Type().Elem(), v
+	}
+
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return v.Type(), ptr
+}
+
+// Reduce calls the Apply method for event.Data
+func (d *Dispatcher) Reduce(aggregate AggregateHandler, event Event) error {
+	if event.Data == nil {
+		return errors.New("event.Data should not be nil")
+	}
+
+	if err := d.check(aggregate); err != nil {
+		return err
+	}
+
+	rawType, data := pointer(event.Data)
+	entry, ok := d.events[rawType]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnhandledEvent, rawType)
+	}
+
+	args := []reflect.Value{reflect.ValueOf(aggregate), data}
+	if entry.withEvent {
+		args = []reflect.Value{args[0], reflect.ValueOf(event), data}
+	}
+
+	return entry.call(args)
+}
+
+// HandleCommand calls the Handle method for command
+func (d *Dispatcher) HandleCommand(aggregate AggregateHandler, command Command) error {
+	if err := d.check(aggregate); err != nil {
+		return err
+	}
+
+	rawType, data := pointer(command)
+	entry, ok := d.commands[rawType]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnhandledCommand, rawType)
+	}
+
+	return entry.call([]reflect.Value{reflect.ValueOf(aggregate), data})
+}
+
+// check that aggregate is the type used to build the dispatch table
+func (d *Dispatcher) check(aggregate AggregateHandler) error {
+	if t := reflect.TypeOf(aggregate); t != d.aggregate {
+		return fmt.Errorf("triper: dispatcher for %s used with %s", d.aggregate, t)
+	}
+
+	return nil
+}
+
+func (m method) call(args []reflect.Value) error {
+	out := m.fn.Call(args)
+	if !m.withError || out[0].IsNil() {
+		return nil
+	}
+
+	return out[0].Interface().(error)
+}
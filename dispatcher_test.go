@@ -0,0 +1,139 @@
+package triper
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type Opened struct {
+	Owner string
+}
+
+type Deposited struct {
+	Amount int
+}
+
+type Deposit struct {
+	BaseCommand
+	Amount int
+}
+
+type DispatchedAccount struct {
+	BaseAggregate
+	Owner   string
+	Balance int
+}
+
+var errNegative = errors.New("negative amount")
+
+func (a *DispatchedAccount) ApplyOpened(event Event, e *Opened) {
+	a.ID = event.AggregateID
+	a.Owner = e.Owner
+}
+
+func (a *DispatchedAccount) ApplyDeposited(e *Deposited) error {
+	a.Balance += e.Amount
+	return nil
+}
+
+func (a *DispatchedAccount) HandleDeposit(c *Deposit) error {
+	if c.Amount < 0 {
+		return errNegative
+	}
+
+	Dispatch(a, Event{AggregateID: a.ID, Data: &Deposited{c.Amount}})
+	return nil
+}
+
+func (a *DispatchedAccount) Reduce(event Event) error {
+	return accountDispatcher.Reduce(a, event)
+}
+
+func (a *DispatchedAccount) HandleCommand(command Command) error {
+	return accountDispatcher.HandleCommand(a, command)
+}
+
+var accountDispatcher *Dispatcher
+
+func TestDispatcher(t *testing.T) {
+	reg := NewEventRegister()
+	reg.Set(Opened{})
+	reg.Set(Deposited{})
+
+	var err error
+	accountDispatcher, err = NewDispatcher(&DispatchedAccount{}, reg)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	var account DispatchedAccount
+	ReduceHelper(&account, Event{AggregateID: "a1", Data: &Opened{"mishudark"}}, false)
+	ReduceHelper(&account, Event{AggregateID: "a1", Data: Deposited{5}}, false)
+
+	if account.ID != "a1" || account.Owner != "mishudark" || account.Balance != 5 {
+		t.Errorf("unexpected aggregate: %+v", account)
+	}
+
+	if err = account.HandleCommand(&Deposit{Amount: 10}); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if account.Balance != 15 || len(account.Uncommited()) != 1 {
+		t.Errorf("unexpected aggregate: %+v", account)
+	}
+
+	if err = account.HandleCommand(&Deposit{Amount: -1}); err != errNegative {
+		t.Error("expected errNegative, got", err)
+	}
+
+	if err = account.Reduce(Event{Data: &struct{}{}}); !errors.Is(err, ErrUnhandledEvent) {
+		t.Error("expected ErrUnhandledEvent, got", err)
+	}
+}
+
+type BrokenAccount struct {
+	DispatchedAccount
+}
+
+func (a *BrokenAccount) ApplyWithdrawn(e *Deposited) {}
+
+func TestDispatcherValidation(t *testing.T) {
+	reg := NewEventRegister()
+	reg.Set(Opened{})
+	reg.Set(Deposited{})
+
+	_, err := NewDispatcher(&BrokenAccount{}, reg)
+	if err == nil || !strings.Contains(err.Error(), "expected argument *Withdrawn") {
+		t.Error("expected argument error, got", err)
+	}
+
+	// the register is shared with an aggregate that handles MockEvent
+	reg.Set(MockEvent{})
+	if _, err = NewDispatcher(&DispatchedAccount{}, reg); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if _, err = NewDispatcher(&DispatchedAccount{}, reg, Opened{}, Deposited{}); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	_, err = NewDispatcher(&DispatchedAccount{}, reg, Opened{}, MockEvent{})
+	if err == nil || !strings.Contains(err.Error(), "ApplyMockEvent") {
+		t.Error("expected missing ApplyMockEvent, got", err)
+	}
+
+	// the strict dispatcher needs every registered event
+	_, err = NewDispatcherWithOptions(&DispatchedAccount{}, reg, DispatcherOptions{Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "ApplyMockEvent") {
+		t.Error("expected missing ApplyMockEvent, got", err)
+	}
+
+	// the events must be registered
+	_, err = NewDispatcher(&DispatchedAccount{}, NewEventRegister(), Opened{})
+	if err == nil || !strings.Contains(err.Error(), "is not registered") {
+		t.Error("expected not registered error, got", err)
+	}
+}
+
+type MockEvent struct{}
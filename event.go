@@ -1,6 +1,7 @@
 package triper
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"regexp"
@@ -11,12 +12,47 @@ import (
 // Event stores the data for every event
 type Event struct {
 	ID            string      `json:"id"`
+	TenantID      string      `json:"tenant_id,omitempty"`
 	AggregateID   string      `json:"aggregate_id"`
 	AggregateType string      `json:"aggregate_type"`
 	CommandID     string      `json:"command_id"`
 	Version       int         `json:"version"`
 	Type          string      `json:"type"`
 	Data          interface{} `json:"data"`
+	// Metadata contains info about the event that is not part of the
+	// domain, like the trace context
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// UnmarshalEvent decodes an event encoded as json, Data is created from
+// the type registered in reg, if reg is nil it is kept as json.RawMessage
+func UnmarshalEvent(blob []byte, reg Register) (Event, error) {
+	var (
+		event Event
+		data  json.RawMessage
+	)
+
+	event.Data = &data
+	if err := json.Unmarshal(blob, &event); err != nil {
+		return event, err
+	}
+
+	event.Data = data
+	if reg == nil {
+		return event, nil
+	}
+
+	value, err := reg.Get(event.Type)
+	if err != nil {
+		return event, err
+	}
+
+	if err = json.Unmarshal(data, value); err != nil {
+		return event, err
+	}
+
+	event.Data = value
+	return event, nil
 }
 
 // GetTypeName of given struct
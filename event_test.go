@@ -1,6 +1,9 @@
 package triper
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
+)
 
 type SubEvent struct {
 	Name string
@@ -32,3 +35,38 @@ func TestEventTypeRegister(t *testing.T) {
 	}
 
 }
+
+func TestUnmarshalEvent(t *testing.T) {
+	reg := NewEventRegister()
+	reg.Set(SubEvent{})
+
+	blob, _ := json.Marshal(Event{
+		ID:       "01",
+		Type:     "sub_event",
+		Data:     SubEvent{Name: "muñeca", SKU: "123"},
+		Metadata: map[string]string{"key": "value"},
+	})
+
+	event, err := UnmarshalEvent(blob, reg)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	data, ok := event.Data.(*SubEvent)
+	if !ok || data.SKU != "123" {
+		t.Errorf("expected *SubEvent, got %#v", event.Data)
+	}
+
+	if event.Metadata["key"] != "value" {
+		t.Error("expected metadata to be decoded, got", event.Metadata)
+	}
+
+	event, err = UnmarshalEvent(blob, nil)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if _, ok = event.Data.(json.RawMessage); !ok {
+		t.Errorf("expected json.RawMessage, got %#v", event.Data)
+	}
+}
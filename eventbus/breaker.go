@@ -0,0 +1,189 @@
+package eventbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// ErrCircuitOpen is returned by a Breaker while the bus is failing
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState of a circuit breaker
+type BreakerState int
+
+// The states of a Breaker
+const (
+	// BreakerClosed publishes the events
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails fast with ErrCircuitOpen
+	BreakerOpen
+	// BreakerHalfOpen lets a publish try if the bus recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+// BreakerOptions configures when a Breaker opens
+type BreakerOptions struct {
+	// FailureThreshold of consecutive failures that opens the breaker
+	FailureThreshold int
+	// OpenTimeout before a publish tries if the bus recovered
+	OpenTimeout time.Duration
+	// OnStateChange is called when the state changes, it can be nil
+	OnStateChange func(from, to BreakerState)
+}
+
+// DefaultBreakerOptions opens after 5 failures for 30 seconds
+func DefaultBreakerOptions() BreakerOptions {
+	return BreakerOptions{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+	}
+}
+
+// Breaker stops publishing to a bus that keeps failing, so the publishes
+// fail fast instead of waiting for the timeouts of the bus
+type Breaker struct {
+	bus     triper.EventBus
+	options BreakerOptions
+	now     func() time.Time
+
+	mu       sync.Mutex
+	logger   triper.Logger
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewBreaker wraps bus
+func NewBreaker(bus triper.EventBus, options BreakerOptions) *Breaker {
+	defaults := DefaultBreakerOptions()
+	if options.FailureThreshold <= 0 {
+		options.FailureThreshold = defaults.FailureThreshold
+	}
+
+	if options.OpenTimeout <= 0 {
+		options.OpenTimeout = defaults.OpenTimeout
+	}
+
+	return &Breaker{
+		bus:     bus,
+		options: options,
+		now:     time.Now,
+		logger:  triper.NopLogger{},
+	}
+}
+
+// SetLogger used by the breaker and the wrapped bus
+func (b *Breaker) SetLogger(logger triper.Logger) {
+	b.mu.Lock()
+	b.logger = logger
+	b.mu.Unlock()
+
+	forwardLogger(b.bus, logger)
+}
+
+// State returns the current state, an open breaker whose timeout expired
+// is reported as half open
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.options.OpenTimeout {
+		return BreakerHalfOpen
+	}
+
+	return b.state
+}
+
+// setState changes the state, b.mu must be held. It returns the callback
+// to call after b.mu is released
+func (b *Breaker) setState(state BreakerState) func() {
+	from := b.state
+	if from == state {
+		return func() {}
+	}
+
+	b.state = state
+	if state == BreakerOpen {
+		b.openedAt = b.now()
+		b.logger.Warn("circuit breaker opened", "failures", b.failures)
+	} else {
+		b.logger.Info("circuit breaker " + state.String())
+	}
+
+	return func() {
+		if b.options.OnStateChange != nil {
+			b.options.OnStateChange(from, state)
+		}
+	}
+}
+
+// Publish the event, ErrCircuitOpen is returned while the breaker is open
+// or another publish is trying the bus
+func (b *Breaker) Publish(event triper.Event, bucket, subset string) error {
+	b.mu.Lock()
+	notify := func() {}
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.options.OpenTimeout {
+			b.mu.Unlock()
+			return ErrCircuitOpen
+		}
+
+		notify = b.setState(BreakerHalfOpen)
+		b.trial = true
+	case BreakerHalfOpen:
+		if b.trial {
+			b.mu.Unlock()
+			return ErrCircuitOpen
+		}
+
+		b.trial = true
+	}
+
+	b.mu.Unlock()
+	notify()
+
+	err := b.bus.Publish(event, bucket, subset)
+
+	b.mu.Lock()
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+
+	if err != nil {
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.options.FailureThreshold {
+			notify = b.setState(BreakerOpen)
+		}
+	} else {
+		b.failures = 0
+		notify = b.setState(BreakerClosed)
+	}
+	b.mu.Unlock()
+
+	notify()
+	return err
+}
+
+// Close the wrapped bus
+func (b *Breaker) Close() error {
+	return closeBus(b.bus)
+}
@@ -0,0 +1,74 @@
+package eventbus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+func TestBreaker(t *testing.T) {
+	bus := &producerStub{err: errors.New("broker down")}
+	now := time.Now()
+
+	var changes []string
+	sut := NewBreaker(bus, BreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+		OnStateChange: func(from, to BreakerState) {
+			changes = append(changes, from.String()+">"+to.String())
+		},
+	})
+	sut.now = func() time.Time { return now }
+
+	sut.Publish(triper.Event{}, "banks", "accounts")
+	if sut.State() != BreakerClosed {
+		t.Error("expected closed after 1 failure, got", sut.State())
+	}
+
+	sut.Publish(triper.Event{}, "banks", "accounts")
+	if sut.State() != BreakerOpen {
+		t.Error("expected open after 2 failures, got", sut.State())
+	}
+
+	// it fails fast without calling the bus
+	if err := sut.Publish(triper.Event{}, "banks", "accounts"); err != ErrCircuitOpen || len(bus.entries) != 2 {
+		t.Error("expected ErrCircuitOpen without publishing, got", err, len(bus.entries))
+	}
+
+	// the trial fails, so it is open again
+	now = now.Add(time.Minute)
+	if sut.State() != BreakerHalfOpen {
+		t.Error("expected half-open after the timeout, got", sut.State())
+	}
+
+	sut.Publish(triper.Event{}, "banks", "accounts")
+	if sut.State() != BreakerOpen || len(bus.entries) != 3 {
+		t.Error("expected open after the failed trial, got", sut.State())
+	}
+
+	// the bus recovered
+	now = now.Add(time.Minute)
+	bus.err = nil
+
+	if err := sut.Publish(triper.Event{}, "banks", "accounts"); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if sut.State() != BreakerClosed {
+		t.Error("expected closed after the trial, got", sut.State())
+	}
+
+	expected := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if len(changes) != len(expected) {
+		t.Fatal("expected", expected, "got", changes)
+	}
+
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Error("expected", expected, "got", changes)
+			break
+		}
+	}
+}
@@ -1,11 +1,41 @@
 package eventbus
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/mishudark/triper"
 )
 
+// ErrPublishTimeout is returned when a publisher does not finish in time
+var ErrPublishTimeout = errors.New("publisher timed out")
+
+// PublisherError says which publisher of a MultiPublisher failed
+type PublisherError struct {
+	// Index of the publisher, in the primaries or in the fallbacks
+	Index int
+	// Name is the type of the publisher, like *nats.Client
+	Name     string
+	Fallback bool
+	Err      error
+}
+
+// Error returns the error of the publisher with its name
+func (e PublisherError) Error() string {
+	kind := "publisher"
+	if e.Fallback {
+		kind = "fallback"
+	}
+
+	return fmt.Sprintf("%s %d (%s): %s", kind, e.Index, e.Name, e.Err)
+}
+
+// Unwrap returns the error of the publisher
+func (e PublisherError) Unwrap() error {
+	return e.Err
+}
+
 // MultiPublisherError is returned from publish when
 // there is an error from a publisher.
 type MultiPublisherError struct {
@@ -35,27 +65,140 @@ func (e *MultiPublisherError) Len() int {
 	return len(e.Errors)
 }
 
-// MultiPublisher ...
+// Policy decides when a MultiPublisher publish succeeds
+type Policy int
+
+// nolint
+const (
+	// PolicyAll needs every publisher
+	PolicyAll Policy = iota
+	// PolicyAny needs one publisher
+	PolicyAny
+	// PolicyQuorum needs MultiPublisherOptions.Quorum publishers
+	PolicyQuorum
+)
+
+// MultiPublisherOptions configures the fan out of a MultiPublisher
+type MultiPublisherOptions struct {
+	Policy Policy
+	// Quorum of publishers that must succeed with PolicyQuorum, a majority
+	// when it is zero
+	Quorum int
+	// Timeout of every publisher, zero waits until they return. A publisher
+	// that times out keeps running in the background
+	Timeout time.Duration
+	// Fallbacks are tried in order when the failed primary publishers break
+	// the policy, every one that succeeds replaces a failed primary
+	Fallbacks []triper.EventBus
+}
+
+// MultiPublisher publishes the events to many publishers at the same time
 type MultiPublisher struct {
 	publishers []triper.EventBus
+	options    MultiPublisherOptions
 }
 
-// NewMultiPublisher ...
+// NewMultiPublisher needs every publisher to succeed
 func NewMultiPublisher(all ...triper.EventBus) *MultiPublisher {
+	return NewMultiPublisherWithOptions(MultiPublisherOptions{}, all...)
+}
+
+// NewMultiPublisherWithOptions succeeds as options.Policy says
+func NewMultiPublisherWithOptions(options MultiPublisherOptions, all ...triper.EventBus) *MultiPublisher {
 	return &MultiPublisher{
 		publishers: all,
+		options:    options,
 	}
 }
 
-// Publish an event through all registered publishers.
+// required returns the number of publishers that must succeed
+func (c MultiPublisher) required() int {
+	required := len(c.publishers)
+
+	switch c.options.Policy {
+	case PolicyAny:
+		required = 1
+	case PolicyQuorum:
+		required = len(c.publishers)/2 + 1
+		if c.options.Quorum > 0 {
+			required = c.options.Quorum
+		}
+	}
+
+	if required > len(c.publishers) {
+		return len(c.publishers)
+	}
+
+	return required
+}
+
+// publish calls p with the timeout of the options
+func (c MultiPublisher) publish(p triper.EventBus, event triper.Event, bucket, subset string) error {
+	if c.options.Timeout <= 0 {
+		return p.Publish(event, bucket, subset)
+	}
+
+	// buffered so the goroutine ends when the publisher returns late
+	result := make(chan error, 1)
+	go func() {
+		result <- p.Publish(event, bucket, subset)
+	}()
+
+	timer := time.NewTimer(c.options.Timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return ErrPublishTimeout
+	}
+}
+
+// Publish an event through all registered publishers at the same time, the
+// returned MultiPublisherError has a PublisherError for every failure
 func (c MultiPublisher) Publish(event triper.Event, bucket, subset string) error {
+	results := make([]error, len(c.publishers))
+	done := make(chan struct{}, len(c.publishers))
+
+	for i, p := range c.publishers {
+		go func(i int, p triper.EventBus) {
+			results[i] = c.publish(p, event, bucket, subset)
+			done <- struct{}{}
+		}(i, p)
+	}
+
+	for range c.publishers {
+		<-done
+	}
+
 	errs := MultiPublisherError{}
+	failed := 0
+
+	for i, err := range results {
+		if err != nil {
+			failed++
+			errs.Add(PublisherError{Index: i, Name: fmt.Sprintf("%T", c.publishers[i]), Err: err})
+		}
+	}
+
+	// a fallback that succeeds replaces one failed primary, they are tried
+	// until the policy is met
+	for i, p := range c.options.Fallbacks {
+		if len(c.publishers)-failed >= c.required() {
+			break
+		}
+
+		err := c.publish(p, event, bucket, subset)
+		if err == nil {
+			failed--
+			continue
+		}
 
-	for _, p := range c.publishers {
-		errs.Add(p.Publish(event, bucket, subset))
+		errs.Add(PublisherError{Index: i, Name: fmt.Sprintf("%T", p), Fallback: true, Err: err})
 	}
 
-	if errs.Len() > 0 {
+	if len(c.publishers)-failed < c.required() {
 		return errs
 	}
 
@@ -3,6 +3,7 @@ package eventbus
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/mishudark/triper"
 )
@@ -98,3 +99,147 @@ func Test_NewMultiPublisher_AggregatesErrors(t *testing.T) {
 		t.Error("error length was expected to be 2 got:", err.Len())
 	}
 }
+
+type slowStub struct {
+	wait time.Duration
+}
+
+func (s slowStub) Publish(event triper.Event, bucket, subset string) error {
+	time.Sleep(s.wait)
+	return nil
+}
+
+func Test_MultiPublisher_Policies(t *testing.T) {
+	failing := errors.New("broker down")
+	tests := []struct {
+		policy   Policy
+		quorum   int
+		failures int
+		fail     bool
+	}{
+		{PolicyAll, 0, 0, false},
+		{PolicyAll, 0, 1, true},
+		{PolicyAny, 0, 2, false},
+		{PolicyAny, 0, 3, true},
+		{PolicyQuorum, 0, 1, false},
+		{PolicyQuorum, 0, 2, true},
+		{PolicyQuorum, 1, 2, false},
+	}
+
+	for _, test := range tests {
+		var publishers []triper.EventBus
+		for i := 0; i < 3; i++ {
+			p := &producerStub{}
+			if i < test.failures {
+				p.err = failing
+			}
+
+			publishers = append(publishers, p)
+		}
+
+		sut := NewMultiPublisherWithOptions(MultiPublisherOptions{Policy: test.policy, Quorum: test.quorum}, publishers...)
+		err := sut.Publish(triper.Event{}, "banks", "accounts")
+
+		if (err != nil) != test.fail {
+			t.Error("policy", test.policy, "with", test.failures, "failures, expected failure", test.fail, "got", err)
+		}
+	}
+}
+
+func Test_MultiPublisher_Timeout(t *testing.T) {
+	fast := &producerStub{}
+	sut := NewMultiPublisherWithOptions(MultiPublisherOptions{Timeout: 10 * time.Millisecond}, slowStub{time.Second}, fast)
+
+	start := time.Now()
+	aerr := sut.Publish(triper.Event{}, "banks", "accounts")
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Error("expected the timeout to stop waiting, took", elapsed)
+	}
+
+	err, ok := aerr.(MultiPublisherError)
+	if !ok || err.Len() != 1 {
+		t.Fatal("expected 1 error, got", aerr)
+	}
+
+	perr := err.Errors[0].(PublisherError)
+	if perr.Index != 0 || perr.Name != "eventbus.slowStub" || perr.Err != ErrPublishTimeout {
+		t.Errorf("expected the timeout of the publisher 0, got %+v", perr)
+	}
+
+	if len(fast.entries) != 1 {
+		t.Error("expected the fast publisher to receive the event")
+	}
+}
+
+func Test_MultiPublisher_Fallbacks(t *testing.T) {
+	primary := &producerStub{err: errors.New("broker down")}
+	brokenFallback := &producerStub{err: errors.New("fallback down")}
+	fallback := &producerStub{}
+
+	sut := NewMultiPublisherWithOptions(MultiPublisherOptions{Fallbacks: []triper.EventBus{brokenFallback, fallback}}, primary)
+	if err := sut.Publish(triper.Event{}, "banks", "accounts"); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if len(brokenFallback.entries) != 1 || len(fallback.entries) != 1 {
+		t.Error("expected the fallbacks to be tried in order")
+	}
+
+	sut = NewMultiPublisherWithOptions(MultiPublisherOptions{Fallbacks: []triper.EventBus{brokenFallback}}, primary)
+	aerr := sut.Publish(triper.Event{}, "banks", "accounts")
+
+	err, ok := aerr.(MultiPublisherError)
+	if !ok || err.Len() != 2 {
+		t.Fatal("expected the errors of the primary and the fallback, got", aerr)
+	}
+
+	if perr := err.Errors[1].(PublisherError); !perr.Fallback {
+		t.Errorf("expected the error of the fallback, got %+v", perr)
+	}
+}
+
+func Test_MultiPublisher_FallbacksReplaceEveryFailure(t *testing.T) {
+	down := errors.New("broker down")
+	first := &producerStub{}
+	second := &producerStub{}
+	third := &producerStub{}
+
+	// 2 failed primaries need 2 fallbacks with PolicyAll
+	sut := NewMultiPublisherWithOptions(
+		MultiPublisherOptions{Fallbacks: []triper.EventBus{first, second, third}},
+		&producerStub{err: down}, &producerStub{err: down}, &producerStub{},
+	)
+
+	if err := sut.Publish(triper.Event{}, "banks", "accounts"); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if len(first.entries) != 1 || len(second.entries) != 1 || len(third.entries) != 0 {
+		t.Error("expected 2 fallbacks, got", len(first.entries), len(second.entries), len(third.entries))
+	}
+
+	// a single fallback does not replace 2 failures
+	sut = NewMultiPublisherWithOptions(
+		MultiPublisherOptions{Fallbacks: []triper.EventBus{first}},
+		&producerStub{err: down}, &producerStub{err: down}, &producerStub{},
+	)
+
+	if err := sut.Publish(triper.Event{}, "banks", "accounts"); err == nil {
+		t.Error("expected an error, got nil")
+	}
+
+	// the fallbacks are not needed when the policy is met
+	sut = NewMultiPublisherWithOptions(
+		MultiPublisherOptions{Policy: PolicyAny, Fallbacks: []triper.EventBus{third}},
+		&producerStub{err: down}, &producerStub{},
+	)
+
+	if err := sut.Publish(triper.Event{}, "banks", "accounts"); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if len(third.entries) != 0 {
+		t.Error("expected no fallback, got", len(third.entries))
+	}
+}
@@ -0,0 +1,266 @@
+// Package jetstream publishes the events to NATS JetStream, the events are
+// stored in a stream per bucket so they are not lost when nobody listens.
+// The event id is sent as Nats-Msg-Id, the server drops the duplicates
+// published inside the duplicate window
+package jetstream
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mishudark/triper"
+	natsbus "github.com/mishudark/triper/eventbus/nats"
+	nats "github.com/nats-io/nats.go"
+)
+
+// Options to configure the streams
+type Options struct {
+	// Buckets provisioned when the client starts, the other buckets are
+	// provisioned the first time an event is published to them
+	Buckets []string
+	// Storage of the streams, file by default
+	Storage nats.StorageType
+	// Replicas of the streams in a cluster
+	Replicas int
+	// MaxAge of the events in the streams, zero keeps them forever
+	MaxAge time.Duration
+	// DuplicateWindow in which the server drops the events with the same id
+	DuplicateWindow time.Duration
+	// Timeout waiting for the publish ack
+	Timeout time.Duration
+	// Connection used by the nats client
+	Connection natsbus.Options
+}
+
+// DefaultOptions stores the events in files with a duplicate window of 2
+// minutes
+func DefaultOptions() Options {
+	return Options{
+		Storage:         nats.FileStorage,
+		Replicas:        1,
+		DuplicateWindow: 2 * time.Minute,
+		Timeout:         5 * time.Second,
+		Connection:      natsbus.DefaultOptions(),
+	}
+}
+
+// StreamName returns the name of the stream of bucket, the characters not
+// allowed in a stream name are replaced by `_`
+func StreamName(bucket string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t':
+			return '_'
+		}
+
+		return r
+	}, bucket)
+}
+
+// Client publishes the events to jetstream and waits for the ack, it can
+// be used by many goroutines
+type Client struct {
+	bus     *natsbus.Client
+	js      nats.JetStreamContext
+	options Options
+
+	mu          sync.Mutex
+	logger      triper.Logger
+	provisioned map[string]bool
+}
+
+// NewClient connects to nats and provisions the streams of options.Buckets
+func NewClient(urls string, useTLS bool, options Options) (*Client, error) {
+	defaults := DefaultOptions()
+	if options.Timeout <= 0 {
+		options.Timeout = defaults.Timeout
+	}
+
+	if options.Replicas <= 0 {
+		options.Replicas = defaults.Replicas
+	}
+
+	bus, err := natsbus.NewClientWithOptions(urls, useTLS, options.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := bus.Conn().JetStream(nats.MaxWait(options.Timeout))
+	if err != nil {
+		bus.Close()
+		return nil, err
+	}
+
+	c := &Client{
+		bus:         bus,
+		js:          js,
+		options:     options,
+		logger:      options.Connection.Logger,
+		provisioned: make(map[string]bool),
+	}
+
+	if c.logger == nil {
+		c.logger = triper.NopLogger{}
+	}
+
+	for _, bucket := range options.Buckets {
+		if err = c.provision(bucket); err != nil {
+			bus.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// SetLogger used by the client
+func (c *Client) SetLogger(logger triper.Logger) {
+	c.mu.Lock()
+	c.logger = logger
+	c.mu.Unlock()
+
+	c.bus.SetLogger(logger)
+}
+
+func (c *Client) log() triper.Logger {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logger
+}
+
+// provision creates the stream of bucket if it does not exist, the
+// existing streams are not modified
+func (c *Client) provision(bucket string) error {
+	c.mu.Lock()
+	done := c.provisioned[bucket]
+	c.mu.Unlock()
+
+	if done {
+		return nil
+	}
+
+	// the stream is only created when it does not exist, any other error,
+	// like a timeout, would try to create a stream that may be there
+	name := StreamName(bucket)
+	_, err := c.js.StreamInfo(name)
+	if err != nil && err != nats.ErrStreamNotFound {
+		return err
+	}
+
+	if err == nats.ErrStreamNotFound {
+		_, err = c.js.AddStream(&nats.StreamConfig{
+			Name:       name,
+			Subjects:   []string{bucket + ".>"},
+			Storage:    c.options.Storage,
+			Replicas:   c.options.Replicas,
+			MaxAge:     c.options.MaxAge,
+			Duplicates: c.options.DuplicateWindow,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		c.log().Info("jetstream stream created", "stream", name)
+	}
+
+	c.mu.Lock()
+	c.provisioned[bucket] = true
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Publish a event and wait for the ack of the stream, a duplicated event
+// is not an error
+func (c *Client) Publish(event triper.Event, bucket, subset string) error {
+	if err := c.provision(bucket); err != nil {
+		return err
+	}
+
+	blob, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	subj := bucket + "." + subset
+	ack, err := c.js.Publish(subj, blob, nats.MsgId(event.ID), nats.AckWait(c.options.Timeout))
+	if err != nil {
+		c.log().Error("event not published", "subject", subj, triper.LogEventID, event.ID, triper.LogError, err)
+		return err
+	}
+
+	if ack.Duplicate {
+		c.log().Debug("duplicated event dropped", "subject", subj, triper.LogEventID, event.ID)
+	}
+
+	return nil
+}
+
+// Close the connection
+func (c *Client) Close() error {
+	return c.bus.Close()
+}
+
+// Handler processes an event received by a consumer, the event is
+// delivered again if it returns an error
+type Handler func(event triper.Event) error
+
+// Subscription to a durable consumer
+type Subscription struct {
+	sub *nats.Subscription
+}
+
+// Close stops receiving the events, the consumer is kept in the server so
+// a new subscription with the same name continues where this one stopped
+func (s *Subscription) Close() error {
+	return s.sub.Drain()
+}
+
+// Subscribe to the events of bucket with a durable consumer, subset can
+// use the nats wildcards like `account.*` or `>`. The events are acked
+// after handler returns, maxDeliver limits the attempts of an event, zero
+// means no limit
+func (c *Client) Subscribe(bucket, subset, durable string, reg triper.Register, maxDeliver int, handler Handler) (*Subscription, error) {
+	if err := c.provision(bucket); err != nil {
+		return nil, err
+	}
+
+	opts := []nats.SubOpt{
+		nats.Durable(durable),
+		nats.ManualAck(),
+		nats.AckExplicit(),
+		nats.DeliverAll(),
+		nats.BindStream(StreamName(bucket)),
+	}
+
+	if maxDeliver > 0 {
+		opts = append(opts, nats.MaxDeliver(maxDeliver))
+	}
+
+	sub, err := c.js.Subscribe(bucket+"."+subset, func(msg *nats.Msg) {
+		event, err := triper.UnmarshalEvent(msg.Data, reg)
+		if err != nil {
+			// it can't be decoded again, so it is not delivered again
+			c.log().Error("event not decoded", "subject", msg.Subject, triper.LogError, err)
+			msg.Term()
+			return
+		}
+
+		if err = handler(event); err != nil {
+			c.log().Warn("event not handled", "subject", msg.Subject, triper.LogEventID, event.ID, triper.LogError, err)
+			msg.Nak()
+			return
+		}
+
+		msg.Ack()
+	}, opts...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Subscription{sub}, nil
+}
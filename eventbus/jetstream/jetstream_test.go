@@ -0,0 +1,215 @@
+package jetstream
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+)
+
+type AccountCreated struct {
+	Owner string
+}
+
+func runServer(t *testing.T) (*server.Server, func()) {
+	dir, err := ioutil.TempDir("", "jetstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  dir,
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server not ready")
+	}
+
+	return s, func() {
+		s.Shutdown()
+		os.RemoveAll(dir)
+	}
+}
+
+// streams fails the stream lookups with err and counts the created streams
+type streams struct {
+	nats.JetStreamContext
+	err   error
+	added int
+}
+
+func (s *streams) StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return s.JetStreamContext.StreamInfo(stream, opts...)
+}
+
+func (s *streams) AddStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error) {
+	s.added++
+	return s.JetStreamContext.AddStream(cfg, opts...)
+}
+
+func TestProvision(t *testing.T) {
+	s, stop := runServer(t)
+	defer stop()
+
+	opts := DefaultOptions()
+	opts.Storage = nats.MemoryStorage
+
+	client, err := NewClient(s.ClientURL(), false, opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer client.Close()
+
+	// a failed lookup does not mean the stream is missing
+	js := &streams{JetStreamContext: client.js, err: nats.ErrTimeout}
+	client.js = js
+
+	if err = client.provision("bank"); err != nats.ErrTimeout {
+		t.Error("expected ErrTimeout, got", err)
+	}
+
+	if js.added != 0 {
+		t.Error("expected no stream created, got", js.added)
+	}
+
+	// the stream is created when it does not exist, once
+	js.err = nil
+	for i := 0; i < 2; i++ {
+		if err = client.provision("bank"); err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+	}
+
+	if js.added != 1 {
+		t.Error("expected the stream created once, got", js.added)
+	}
+}
+
+func TestPublishDeduplicates(t *testing.T) {
+	s, stop := runServer(t)
+	defer stop()
+
+	opts := DefaultOptions()
+	opts.Storage = nats.MemoryStorage
+	opts.Buckets = []string{"bank"}
+
+	client, err := NewClient(s.ClientURL(), false, opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer client.Close()
+
+	event := triper.Event{ID: "event-1", AggregateID: "a", Type: "account_created", Data: &AccountCreated{"ana"}}
+	for i := 0; i < 3; i++ {
+		if err = client.Publish(event, "bank", "account"); err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+	}
+
+	event.ID = "event-2"
+	if err = client.Publish(event, "bank", "account"); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	info, err := client.js.StreamInfo("bank")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if info.State.Msgs != 2 {
+		t.Error("expected 2 events in the stream, got", info.State.Msgs)
+	}
+}
+
+func TestSubscribeDurable(t *testing.T) {
+	s, stop := runServer(t)
+	defer stop()
+
+	opts := DefaultOptions()
+	opts.Storage = nats.MemoryStorage
+
+	client, err := NewClient(s.ClientURL(), false, opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer client.Close()
+
+	reg := triper.NewEventRegister()
+	reg.Set(AccountCreated{})
+
+	// the events are stored before the consumer exists
+	for _, id := range []string{"e1", "e2", "e3"} {
+		event := triper.Event{ID: id, AggregateID: "a", Type: "account_created", Data: &AccountCreated{id}}
+		if err = client.Publish(event, "bank", "account"); err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		received []string
+		failed   bool
+		done     = make(chan struct{})
+	)
+
+	sub, err := client.Subscribe("bank", ">", "projector", reg, 0, func(event triper.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// the first attempt of e2 fails, it must be delivered again
+		if event.ID == "e2" && !failed {
+			failed = true
+			return errors.New("boom")
+		}
+
+		if event.Data.(*AccountCreated).Owner != event.ID {
+			t.Error("expected the payload of", event.ID, "got", event.Data)
+		}
+
+		received = append(received, event.ID)
+		if len(received) == 3 {
+			close(done)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer sub.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		mu.Lock()
+		t.Fatal("expected 3 events, got", received)
+	}
+
+	info, err := client.js.ConsumerInfo("bank", "projector")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if info.Config.Durable != "projector" {
+		t.Error("expected a durable consumer, got", info.Config.Durable)
+	}
+}
@@ -0,0 +1,386 @@
+// Package kafka publishes the events to a topic per bucket and subset, the
+// aggregate id is the key of the messages so the events of an aggregate
+// land in the same partition and keep their order
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/eventbus"
+)
+
+// headers sent with every event
+const (
+	HeaderEventType     = "event_type"
+	HeaderAggregateType = "aggregate_type"
+)
+
+// Options to configure the producer and the consumer groups
+type Options struct {
+	// ClientID sent to the brokers
+	ClientID string
+	// Version of the brokers, the consumer groups need 0.10.2 at least
+	Version sarama.KafkaVersion
+	// RequiredAcks before a publish succeeds, sarama.NoResponse is zero so
+	// it is replaced by the default
+	RequiredAcks sarama.RequiredAcks
+	// Timeout waiting for the brokers
+	Timeout time.Duration
+	// Retries of a publish before it fails, zero does not retry
+	Retries int
+	// TLS connects with ssl when it is set
+	TLS *tls.Config
+	// InitialOffset of a new consumer group, sarama.OffsetOldest or
+	// sarama.OffsetNewest
+	InitialOffset int64
+	// RetryWait between the attempts of an event that was not handled
+	RetryWait time.Duration
+	// MaxAttempts of an event before it is skipped, zero means no limit
+	MaxAttempts int
+	// Logger used by the clients
+	Logger triper.Logger
+}
+
+// DefaultOptions waits for all the in sync replicas, and the new consumer
+// groups read the topics from the beginning
+func DefaultOptions() Options {
+	return Options{
+		ClientID:      "triper",
+		Version:       sarama.V2_1_0_0,
+		RequiredAcks:  sarama.WaitForAll,
+		Timeout:       10 * time.Second,
+		Retries:       3,
+		InitialOffset: sarama.OffsetOldest,
+		RetryWait:     time.Second,
+	}
+}
+
+// withDefaults returns the options with the zero values replaced by the
+// ones of DefaultOptions
+func (o Options) withDefaults() Options {
+	defaults := DefaultOptions()
+	if o.ClientID == "" {
+		o.ClientID = defaults.ClientID
+	}
+
+	if o.Version == (sarama.KafkaVersion{}) {
+		o.Version = defaults.Version
+	}
+
+	if o.RequiredAcks == 0 {
+		o.RequiredAcks = defaults.RequiredAcks
+	}
+
+	if o.Timeout <= 0 {
+		o.Timeout = defaults.Timeout
+	}
+
+	if o.InitialOffset == 0 {
+		o.InitialOffset = defaults.InitialOffset
+	}
+
+	if o.RetryWait <= 0 {
+		o.RetryWait = defaults.RetryWait
+	}
+
+	return o
+}
+
+// Config returns the sarama config of options, the zero values are
+// replaced by the ones of DefaultOptions
+func (o Options) Config() *sarama.Config {
+	o = o.withDefaults()
+
+	config := sarama.NewConfig()
+	config.ClientID = o.ClientID
+	config.Version = o.Version
+	config.Net.DialTimeout = o.Timeout
+	config.Net.ReadTimeout = o.Timeout
+	config.Net.WriteTimeout = o.Timeout
+
+	if o.TLS != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = o.TLS
+	}
+
+	config.Producer.RequiredAcks = o.RequiredAcks
+	config.Producer.Timeout = o.Timeout
+	config.Producer.Retry.Max = o.Retries
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Producer.Return.Successes = true
+
+	config.Consumer.Offsets.Initial = o.InitialOffset
+	config.Consumer.Return.Errors = true
+
+	return config
+}
+
+func (o Options) logger() triper.Logger {
+	if o.Logger == nil {
+		return triper.NopLogger{}
+	}
+
+	return o.Logger
+}
+
+// TopicName returns the topic of bucket and subset, the characters not
+// allowed in a topic name are replaced by `_`
+func TopicName(bucket, subset string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == '_', r == '-':
+			return r
+		}
+
+		return '_'
+	}, bucket+"."+subset)
+}
+
+// Client publishes the events and waits for the acks, it can be used by
+// many goroutines
+type Client struct {
+	producer sarama.SyncProducer
+
+	mu     sync.RWMutex
+	logger triper.Logger
+	closed bool
+}
+
+// NewClient connects to the brokers
+func NewClient(brokers []string, options Options) (*Client, error) {
+	producer, err := sarama.NewSyncProducer(brokers, options.Config())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClientWithProducer(producer, options), nil
+}
+
+// NewClientWithProducer publishes with producer, like the one of
+// sarama/mocks
+func NewClientWithProducer(producer sarama.SyncProducer, options Options) *Client {
+	return &Client{
+		producer: producer,
+		logger:   options.logger(),
+	}
+}
+
+// SetLogger used by the client
+func (c *Client) SetLogger(logger triper.Logger) {
+	c.mu.Lock()
+	c.logger = logger
+	c.mu.Unlock()
+}
+
+// Publish a event keyed by its aggregate id
+func (c *Client) Publish(event triper.Event, bucket, subset string) error {
+	blob, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: TopicName(bucket, subset),
+		Key:   sarama.StringEncoder(event.AggregateID),
+		Value: sarama.ByteEncoder(blob),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(HeaderEventType), Value: []byte(event.Type)},
+			{Key: []byte(HeaderAggregateType), Value: []byte(event.AggregateType)},
+		},
+	}
+
+	// the producer panics when it is used after Close
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return eventbus.ErrClosed
+	}
+
+	partition, offset, err := c.producer.SendMessage(msg)
+	if err != nil {
+		c.logger.Error("event not published", "topic", msg.Topic, triper.LogEventID, event.ID, triper.LogError, err)
+		return err
+	}
+
+	c.logger.Debug("event published", "topic", msg.Topic, "partition", partition, "offset", offset, triper.LogEventID, event.ID)
+	return nil
+}
+
+// Close the producer
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	return c.producer.Close()
+}
+
+// Handler processes an event received by a consumer group, the event is
+// retried while it returns an error
+type Handler func(event triper.Event) error
+
+// Subscription of a consumer group
+type Subscription struct {
+	group  sarama.ConsumerGroup
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Close leaves the consumer group, the committed offsets are kept so a new
+// subscription of the group continues where this one stopped
+func (s *Subscription) Close() error {
+	s.cancel()
+	err := s.group.Close()
+	<-s.done
+	return err
+}
+
+// Subscribe joins the consumer group to read topics, the partitions are
+// balanced between the members of the group. The offset of an event is
+// committed after handler returns nil
+func Subscribe(brokers []string, group string, topics []string, reg triper.Register, handler Handler, options Options) (*Subscription, error) {
+	consumerGroup, err := sarama.NewConsumerGroup(brokers, group, options.Config())
+	if err != nil {
+		return nil, err
+	}
+
+	return SubscribeGroup(consumerGroup, topics, reg, handler, options), nil
+}
+
+// SubscribeGroup reads topics with consumerGroup
+func SubscribeGroup(consumerGroup sarama.ConsumerGroup, topics []string, reg triper.Register, handler Handler, options Options) *Subscription {
+	options = options.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Subscription{
+		group:  consumerGroup,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	c := &consumer{
+		reg:     reg,
+		handler: handler,
+		options: options,
+		logger:  options.logger(),
+	}
+
+	go func() {
+		for err := range consumerGroup.Errors() {
+			c.logger.Error("kafka consumer failed", triper.LogError, err)
+		}
+	}()
+
+	go func() {
+		defer close(s.done)
+
+		// Consume returns on every rebalance
+		for ctx.Err() == nil {
+			err := consumerGroup.Consume(ctx, topics, c)
+			if err == sarama.ErrClosedConsumerGroup {
+				return
+			}
+
+			if err != nil {
+				c.logger.Error("kafka consumer group failed", triper.LogError, err)
+				c.wait(ctx)
+			}
+		}
+	}()
+
+	return s
+}
+
+// consumer handles the claims of a consumer group session
+type consumer struct {
+	reg     triper.Register
+	handler Handler
+	options Options
+	logger  triper.Logger
+}
+
+// Setup is called when a session starts
+func (c *consumer) Setup(session sarama.ConsumerGroupSession) error {
+	c.logger.Info("kafka consumer joined", "member", session.MemberID(), "generation", session.GenerationID())
+	return nil
+}
+
+// Cleanup is called when a session ends, the marked offsets are committed
+func (c *consumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	session.Commit()
+	return nil
+}
+
+// ConsumeClaim handles the events of a partition in order
+func (c *consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		if !c.handle(session.Context(), msg) {
+			// the session ended, the event is read again by the next one
+			return nil
+		}
+
+		session.MarkMessage(msg, "")
+	}
+
+	return nil
+}
+
+// handle calls the handler until it succeeds, it returns false when ctx
+// is done before
+func (c *consumer) handle(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	event, err := DecodeMessage(msg, c.reg)
+	if err != nil {
+		// it can't be decoded again, so it is skipped
+		c.logger.Error("event not decoded", "topic", msg.Topic, "offset", msg.Offset, triper.LogError, err)
+		return true
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err = c.handler(event); err == nil {
+			return true
+		}
+
+		if c.options.MaxAttempts > 0 && attempt >= c.options.MaxAttempts {
+			c.logger.Error("event skipped", "topic", msg.Topic, "offset", msg.Offset, triper.LogEventID, event.ID, triper.LogError, err)
+			return true
+		}
+
+		c.logger.Warn("event not handled", "topic", msg.Topic, "offset", msg.Offset, triper.LogEventID, event.ID, triper.LogError, err)
+		if !c.wait(ctx) {
+			return false
+		}
+	}
+}
+
+// wait for RetryWait, it returns false when ctx is done before
+func (c *consumer) wait(ctx context.Context) bool {
+	timer := time.NewTimer(c.options.RetryWait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// DecodeMessage returns the event received from kafka, the metadata like
+// the trace context travels inside the payload
+func DecodeMessage(msg *sarama.ConsumerMessage, reg triper.Register) (triper.Event, error) {
+	return triper.UnmarshalEvent(msg.Value, reg)
+}
@@ -0,0 +1,205 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/eventbus"
+)
+
+type AccountCreated struct {
+	Owner string
+}
+
+func TestTopicName(t *testing.T) {
+	if name := TopicName("bank", "account"); name != "bank.account" {
+		t.Error("expected bank.account, got", name)
+	}
+
+	if name := TopicName("bank/eu", "account created"); name != "bank_eu.account_created" {
+		t.Error("expected bank_eu.account_created, got", name)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "bank.account" {
+			t.Error("expected the topic bank.account, got", msg.Topic)
+		}
+
+		key, _ := msg.Key.Encode()
+		if string(key) != "aggregate-1" {
+			t.Error("expected the aggregate id as key, got", string(key))
+		}
+
+		return nil
+	})
+	producer.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+
+	client := NewClientWithProducer(producer, DefaultOptions())
+	event := triper.Event{ID: "e1", AggregateID: "aggregate-1", Type: "account_created", Data: &AccountCreated{"ana"}}
+
+	if err := client.Publish(event, "bank", "account"); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if err := client.Publish(event, "bank", "account"); err != sarama.ErrNotLeaderForPartition {
+		t.Error("expected the error of the producer, got", err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if err := client.Publish(event, "bank", "account"); err != eventbus.ErrClosed {
+		t.Error("expected ErrClosed, got", err)
+	}
+}
+
+type session struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *session) Context() context.Context { return s.ctx }
+
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type claim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c claim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestConsumeClaim(t *testing.T) {
+	reg := triper.NewEventRegister()
+	reg.Set(AccountCreated{})
+
+	messages := make(chan *sarama.ConsumerMessage, 3)
+	messages <- &sarama.ConsumerMessage{Offset: 0, Value: []byte(`{"id":"e1","type":"account_created","data":{"Owner":"ana"}}`)}
+	messages <- &sarama.ConsumerMessage{Offset: 1, Value: []byte(`not json`)}
+	messages <- &sarama.ConsumerMessage{Offset: 2, Value: []byte(`{"id":"e2","type":"account_created","data":{"Owner":"bob"}}`)}
+	close(messages)
+
+	var (
+		received []string
+		attempts int
+	)
+
+	opts := DefaultOptions()
+	opts.RetryWait = 0
+
+	c := &consumer{
+		reg:     reg,
+		options: opts,
+		logger:  triper.NopLogger{},
+		handler: func(event triper.Event) error {
+			attempts++
+			// the first attempt fails, the event must be retried
+			if attempts == 1 {
+				return errors.New("boom")
+			}
+
+			received = append(received, event.Data.(*AccountCreated).Owner)
+			return nil
+		},
+	}
+
+	s := &session{ctx: context.Background()}
+	if err := c.ConsumeClaim(s, claim{messages: messages}); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if len(received) != 2 || received[0] != "ana" || received[1] != "bob" {
+		t.Error("expected [ana bob], got", received)
+	}
+
+	if len(s.marked) != 3 {
+		t.Error("expected 3 committed offsets, got", s.marked)
+	}
+}
+
+func TestConsumeClaimStops(t *testing.T) {
+	reg := triper.NewEventRegister()
+	reg.Set(AccountCreated{})
+
+	messages := make(chan *sarama.ConsumerMessage, 1)
+	messages <- &sarama.ConsumerMessage{Offset: 7, Value: []byte(`{"id":"e1","type":"account_created","data":{"Owner":"ana"}}`)}
+	close(messages)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &consumer{
+		reg:     reg,
+		options: DefaultOptions(),
+		logger:  triper.NopLogger{},
+		handler: func(event triper.Event) error {
+			cancel()
+			return errors.New("boom")
+		},
+	}
+
+	s := &session{ctx: ctx}
+	if err := c.ConsumeClaim(s, claim{messages: messages}); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	// the offset is not committed, so the next session reads it again
+	if len(s.marked) != 0 {
+		t.Error("expected no committed offsets, got", s.marked)
+	}
+}
+
+// failingGroup fails every Consume like a group without brokers
+type failingGroup struct {
+	mu       sync.Mutex
+	consumes int
+	errors   chan error
+}
+
+func (g *failingGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	g.mu.Lock()
+	g.consumes++
+	g.mu.Unlock()
+	return sarama.ErrOutOfBrokers
+}
+
+func (g *failingGroup) Errors() <-chan error {
+	return g.errors
+}
+
+func (g *failingGroup) Close() error {
+	close(g.errors)
+	return nil
+}
+
+func TestOptionsDefaults(t *testing.T) {
+	config := Options{}.Config()
+	if err := config.Validate(); err != nil {
+		t.Error("expected a valid config, got", err)
+	}
+
+	if config.ClientID != "triper" || config.Version != sarama.V2_1_0_0 || config.Consumer.Offsets.Initial != sarama.OffsetOldest {
+		t.Error("expected the default options, got", config.ClientID, config.Version, config.Consumer.Offsets.Initial)
+	}
+
+	// a zero RetryWait waits the default instead of spinning on Consume
+	group := &failingGroup{errors: make(chan error)}
+	sub := SubscribeGroup(group, []string{"bank.account"}, triper.NewEventRegister(), nil, Options{})
+	time.Sleep(100 * time.Millisecond)
+	sub.Close()
+
+	if group.consumes != 1 {
+		t.Error("expected 1 consume, got", group.consumes)
+	}
+}
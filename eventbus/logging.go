@@ -4,15 +4,21 @@ import (
 	"log"
 
 	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/logger"
 )
 
 // Logger logs messages sent to the event bus.
 type Logger struct {
-	log *log.Logger
+	log triper.Logger
 }
 
 // NewLogger returns new logger struct.
 func NewLogger(l *log.Logger) *Logger {
+	return NewStructuredLogger(logger.Std(l))
+}
+
+// NewStructuredLogger returns a logger struct that uses a triper.Logger.
+func NewStructuredLogger(l triper.Logger) *Logger {
 	return &Logger{
 		log: l,
 	}
@@ -20,6 +26,15 @@ func NewLogger(l *log.Logger) *Logger {
 
 // Publish logs event details out.
 func (l *Logger) Publish(e triper.Event, b, s string) error {
-	log.Printf("bucket: %s subset: %s event: %+v", b, s, e)
+	l.log.Info("event published",
+		"bucket", b,
+		"subset", s,
+		triper.LogEventID, e.ID,
+		triper.LogEventType, e.Type,
+		triper.LogAggregateID, e.AggregateID,
+		triper.LogAggregateType, e.AggregateType,
+		triper.LogCommandID, e.CommandID,
+		"data", e.Data,
+	)
 	return nil
 }
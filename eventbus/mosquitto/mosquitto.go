@@ -1,84 +1,517 @@
 package mosquitto
 
 import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"github.com/mishudark/triper"
-	"log"
-	"os"
+	"github.com/mishudark/triper/eventbus"
 )
 
-var info = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
+// MqttDefaultPort is the default port
+const MqttDefaultPort = 1883
+
+// MqttDefaultHost is the default host
+const MqttDefaultHost = "localhost"
+
+// MqttDefaultMethod is the default method
+const MqttDefaultMethod = "tcp"
+
+// MqttDefaultClientId is the prefix of the default client id
+const MqttDefaultClientId = "cqrs-es"
 
-var fatal = log.New(os.Stderr, "FATAL: ", log.Ldate|log.Ltime|log.Lshortfile)
+// DefaultBufferSize is the max number of events kept during an outage
+const DefaultBufferSize = 10000
 
-// Client nats
+// ErrPublishTimeout is returned when the broker does not acknowledge an
+// event in Options.Timeout
+var ErrPublishTimeout = errors.New("mosquitto: timeout waiting for the broker")
+
+// Route configures how the events are published to a topic
+type Route struct {
+	// QoS is 0 at most once, 1 at least once or 2 exactly once
+	QoS byte
+	// Retained events are sent to the subscribers that arrive later
+	Retained bool
+}
+
+// Will is published by the broker when the client disconnects without
+// calling Close
+type Will struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+}
+
+// Options to configure the connection
+type Options struct {
+	// Route used by the topics without a route in Routes
+	Route Route
+	// Routes by topic filter, like `bank/account` or `bank/#`, the first
+	// filter that matches in lexical order is used
+	Routes map[string]Route
+	// TLS connects with ssl when it is set
+	TLS *tls.Config
+	// Username and Password to authenticate with the broker
+	Username string
+	Password string
+	// Will published when the connection is lost, it can be nil
+	Will *Will
+	// MaxReconnectInterval between the reconnection attempts
+	MaxReconnectInterval time.Duration
+	// Timeout waiting for the broker to acknowledge an event
+	Timeout time.Duration
+	// BufferSize is the max number of events kept while the connection is
+	// lost, they are published in order when it is back. Zero disables the
+	// buffer and the publishes fail during the outage
+	BufferSize int
+	// OnStateChange is called when the connection changes, it can be nil
+	OnStateChange eventbus.StateHandler
+	// Logger used by the client, it can be replaced later with SetLogger
+	Logger triper.Logger
+}
+
+// DefaultOptions keeps DefaultBufferSize events during an outage
+func DefaultOptions() Options {
+	return Options{
+		MaxReconnectInterval: time.Minute,
+		Timeout:              30 * time.Second,
+		BufferSize:           DefaultBufferSize,
+	}
+}
+
+// message waiting for the connection
+type message struct {
+	topic   string
+	payload []byte
+	eventID string
+	route   Route
+}
+
+// Handler processes an event received by a subscription
+type Handler func(event triper.Event) error
+
+// subscription is restored when the connection is back
+type subscription struct {
+	qos      byte
+	callback MQTT.MessageHandler
+}
+
+// Client mqtt, it keeps a connection that is reconnected when it is lost,
+// Publish can be used by many goroutines
 type Client struct {
-	options *MQTT.ClientOptions
 	client  MQTT.Client
-}
+	options Options
 
-//MqttDefaultPort is the default port
-const MqttDefaultPort = 1883
+	filters []string
 
-//MqttDefaultHost is the default host
-const MqttDefaultHost = "localhost"
+	// logMu is not mu, the subscriptions log from the paho goroutine that
+	// must not wait for a flush of the buffer
+	logMu  sync.RWMutex
+	logger triper.Logger
 
-//MqttDefaultMethod is the default method
-const MqttDefaultMethod = "tcp"
+	// flushMu runs a flush of the buffer at a time
+	flushMu sync.Mutex
 
-//MqttDefaultClientId is the default method
-const MqttDefaultClientId = "cqrs-es"
+	mu            sync.Mutex
+	connected     bool
+	flushing      bool
+	reconnect     bool
+	closed        bool
+	pending       []message
+	subscriptions map[string]subscription
+}
 
-//NewClient create a new client with default parameters
-func NewClient() (*Client, error) {
-	return NewClientWithPort(MqttDefaultMethod, MqttDefaultHost, MqttDefaultPort, MqttDefaultClientId)
+// DefaultClientID returns MqttDefaultClientId with a random suffix, like
+// `cqrs-es-3f9a0c12b7e4`, it fits in the 23 characters of MQTT 3.1
+func DefaultClientID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return MqttDefaultClientId + "-" + hex.EncodeToString(b)
 }
 
-// defaultPublishHandler is called when there is a message that is matching no other subscriber
-var defaultPublishHandler MQTT.MessageHandler = func(client MQTT.Client, msg MQTT.Message) {
-	fmt.Printf("TOPIC: %s\n", msg.Topic())
-	fmt.Printf("MSG: %s\n", msg.Payload())
+// NewClient create a new client with default parameters and a unique
+// client id
+func NewClient() (*Client, error) {
+	return NewClientWithPort(MqttDefaultMethod, MqttDefaultHost, MqttDefaultPort, DefaultClientID())
 }
 
-//NewClientWithPort create a new client with options
+// NewClientWithPort create a new client with options, see
+// NewClientWithOptions for the client id
 func NewClientWithPort(method string, host string, port int, clientID string) (*Client, error) {
-	var d Client
+	return NewClientWithOptions(method, host, port, clientID, DefaultOptions())
+}
+
+// newClient returns a client without the mqtt connection
+func newClient(options Options) *Client {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultOptions().Timeout
+	}
 
-	d.options = MQTT.NewClientOptions()
-	brokerURL := fmt.Sprintf("%s://%s:%d", method, host, port)
-	d.options.AddBroker(brokerURL)
-	d.options.SetClientID(clientID)
-	d.options.SetDefaultPublishHandler(defaultPublishHandler)
+	c := &Client{
+		options:       options,
+		logger:        options.Logger,
+		subscriptions: make(map[string]subscription),
+	}
 
-	info.Printf("Created Client for broker %s", brokerURL)
+	if c.logger == nil {
+		c.logger = triper.NopLogger{}
+	}
 
-	return &d, nil
+	for filter := range options.Routes {
+		c.filters = append(c.filters, filter)
+	}
+	sort.Strings(c.filters)
+
+	return c
 }
 
-// Publish a event
-func (c *Client) Publish(event triper.Event, bucket, subset string) error {
+// NewClientWithOptions connects to the broker, the first connection must
+// succeed, after that it is reconnected automatically. A fixed clientID
+// must be unique, the broker disconnects the client that used it before,
+// so every instance of a service needs its own. An empty one is replaced
+// by DefaultClientID
+func NewClientWithOptions(method string, host string, port int, clientID string, options Options) (*Client, error) {
+	c := newClient(options)
 
-	info.Println("Publish Begin")
+	if clientID == "" {
+		clientID = DefaultClientID()
+	}
+
+	if options.TLS != nil && method == MqttDefaultMethod {
+		method = "ssl"
+	}
 
-	c.client = MQTT.NewClient(c.options)
+	opts := MQTT.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("%s://%s:%d", method, host, port))
+	opts.SetClientID(clientID)
+	opts.SetUsername(options.Username)
+	opts.SetPassword(options.Password)
+
+	if options.TLS != nil {
+		opts.SetTLSConfig(options.TLS)
+	}
+
+	if will := options.Will; will != nil {
+		opts.SetBinaryWill(will.Topic, will.Payload, will.QoS, will.Retained)
+	}
+
+	opts.SetDefaultPublishHandler(c.defaultPublishHandler)
+	opts.SetAutoReconnect(true)
+	opts.SetOnConnectHandler(c.onConnect)
+	opts.SetConnectionLostHandler(c.onConnectionLost)
+
+	if options.MaxReconnectInterval > 0 {
+		opts.SetMaxReconnectInterval(options.MaxReconnectInterval)
+	}
+
+	c.client = MQTT.NewClient(opts)
 	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
-		return token.Error()
+		return nil, token.Error()
+	}
+
+	return c, nil
+}
+
+// SetLogger used by the client
+func (c *Client) SetLogger(logger triper.Logger) {
+	c.logMu.Lock()
+	c.logger = logger
+	c.logMu.Unlock()
+}
+
+func (c *Client) log() triper.Logger {
+	c.logMu.RLock()
+	defer c.logMu.RUnlock()
+	return c.logger
+}
+
+func (c *Client) notify(state eventbus.ConnectionState, err error) {
+	if c.options.OnStateChange != nil {
+		c.options.OnStateChange(state, err)
+	}
+}
+
+// defaultPublishHandler is called when there is a message that is matching no other subscriber
+func (c *Client) defaultPublishHandler(client MQTT.Client, msg MQTT.Message) {
+	c.log().Debug("message without subscriber", "topic", msg.Topic(), "payload", string(msg.Payload()))
+}
+
+// onConnect restores the subscriptions and publishes the buffered events
+// before accepting new ones, so the order is kept
+func (c *Client) onConnect(client MQTT.Client) {
+	c.mu.Lock()
+	subscriptions := make(map[string]subscription, len(c.subscriptions))
+	for filter, sub := range c.subscriptions {
+		subscriptions[filter] = sub
+	}
+	c.mu.Unlock()
+
+	for filter, sub := range subscriptions {
+		if token := c.client.Subscribe(filter, sub.qos, sub.callback); token.Wait() && token.Error() != nil {
+			c.log().Error("subscription not restored", "topic", filter, triper.LogError, token.Error())
+		}
+	}
+
+	// a reconnection waits until the flush of the previous one fails
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	c.flushing = true
+
+	for len(c.pending) > 0 && !c.closed {
+		msg := c.pending[0]
+		c.mu.Unlock()
+
+		// the lock is not held while the broker acknowledges the event,
+		// the new events are buffered behind it meanwhile
+		err := c.send(msg)
+
+		c.mu.Lock()
+		if err != nil {
+			// the connection was lost again, the next one continues
+			c.flushing = false
+			c.mu.Unlock()
+
+			c.log().Error("buffered event not published", "topic", msg.topic, triper.LogEventID, msg.eventID, triper.LogError, err)
+			return
+		}
+
+		// Close empties the buffer
+		if len(c.pending) > 0 {
+			c.pending = c.pending[1:]
+		}
+	}
+
+	c.flushing = false
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+
+	state := eventbus.StateConnected
+	if c.reconnect {
+		state = eventbus.StateReconnected
+		c.log().Info("mqtt reconnected")
 	}
 
-	defer c.client.Disconnect(5000)
+	c.connected = true
+	c.reconnect = true
+	c.mu.Unlock()
+
+	c.notify(state, nil)
+}
 
-	msg, err := json.Marshal(event)
+func (c *Client) onConnectionLost(client MQTT.Client, err error) {
+	c.mu.Lock()
+	c.connected = false
+	c.log().Warn("mqtt connection lost", triper.LogError, err)
+	c.mu.Unlock()
+
+	c.notify(eventbus.StateDisconnected, err)
+}
+
+func (c *Client) send(msg message) error {
+	token := c.client.Publish(msg.topic, msg.route.QoS, msg.route.Retained, msg.payload)
+	if !token.WaitTimeout(c.options.Timeout) {
+		return ErrPublishTimeout
+	}
+
+	return token.Error()
+}
+
+// buffer keeps msg until the connection is back, c.mu must be held
+func (c *Client) buffer(msg message) error {
+	if len(c.pending) >= c.options.BufferSize {
+		c.log().Error("event not published", "topic", msg.topic, triper.LogEventID, msg.eventID, triper.LogError, eventbus.ErrBufferFull)
+		return eventbus.ErrBufferFull
+	}
+
+	c.pending = append(c.pending, msg)
+	return nil
+}
+
+// Publish a event, while the connection is lost it is kept in the buffer
+func (c *Client) Publish(event triper.Event, bucket, subset string) error {
+	payload, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
 
-	subj := bucket + "/" + subset
-	token := c.client.Publish(subj, 0, false, msg)
-	token.Wait()
+	msg := message{topic: bucket + "/" + subset, payload: payload, eventID: event.ID}
+	msg.route = c.route(msg.topic)
 
-	info.Println("Publish End")
+	c.mu.Lock()
+	switch {
+	case c.closed:
+		c.mu.Unlock()
+		return eventbus.ErrClosed
+	// paho drops the messages published while it reconnects, and the
+	// buffered events are published first
+	case !c.connected || c.flushing || !c.client.IsConnectionOpen():
+		err = c.buffer(msg)
+		c.mu.Unlock()
+		return err
+	}
+	c.mu.Unlock()
 
-	return token.Error()
+	if err = c.send(msg); err != nil {
+		if err == MQTT.ErrNotConnected {
+			c.mu.Lock()
+			err = c.buffer(msg)
+			c.mu.Unlock()
+			return err
+		}
+
+		c.log().Error("event not published", "topic", msg.topic, triper.LogEventID, event.ID, triper.LogError, err)
+		return err
+	}
+
+	c.log().Debug("event published", "topic", msg.topic, triper.LogEventID, event.ID)
+	return nil
+}
+
+// route returns the route of topic
+func (c *Client) route(topic string) Route {
+	for _, filter := range c.filters {
+		if MatchTopic(filter, topic) {
+			return c.options.Routes[filter]
+		}
+	}
+
+	return c.options.Route
+}
+
+// MatchTopic reports whether topic matches filter, the filter can use the
+// wildcards `+` for a level and `#` for the remaining levels. The prefix
+// `$share/group/` of the shared subscriptions is ignored, and the topics
+// that start with `$`, like `$SYS/load`, only match the filters with `$`
+func MatchTopic(filter, topic string) bool {
+	if strings.HasPrefix(filter, "$share/") {
+		parts := strings.SplitN(filter, "/", 3)
+		if len(parts) < 3 {
+			return false
+		}
+
+		filter = parts[2]
+	}
+
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		return false
+	}
+
+	filters := strings.Split(filter, "/")
+	levels := strings.Split(topic, "/")
+
+	for i, f := range filters {
+		if f == "#" {
+			return true
+		}
+
+		if i >= len(levels) || (f != "+" && f != levels[i]) {
+			return false
+		}
+	}
+
+	return len(filters) == len(levels)
+}
+
+// Subscribe calls handler with the events published to the topics that
+// match filter, the subscription is restored after a reconnection
+func (c *Client) Subscribe(filter string, qos byte, reg triper.Register, handler Handler) error {
+	callback := func(client MQTT.Client, msg MQTT.Message) {
+		event, err := DecodeMessage(msg, reg)
+		if err != nil {
+			c.log().Error("event not decoded", "topic", msg.Topic(), triper.LogError, err)
+			return
+		}
+
+		if err = handler(event); err != nil {
+			c.log().Error("event not handled", "topic", msg.Topic(), triper.LogEventID, event.ID, triper.LogError, err)
+		}
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return eventbus.ErrClosed
+	}
+
+	c.subscriptions[filter] = subscription{qos: qos, callback: callback}
+	c.mu.Unlock()
+
+	// without connection the subscription is made by onConnect
+	token := c.client.Subscribe(filter, qos, callback)
+	if token.Wait() && token.Error() != nil && token.Error() != MQTT.ErrNotConnected {
+		c.mu.Lock()
+		delete(c.subscriptions, filter)
+		c.mu.Unlock()
+		return token.Error()
+	}
+
+	return nil
+}
+
+// SubscribeShared is like Subscribe but the events are load balanced
+// between the clients of group, so every event is handled by one of them
+func (c *Client) SubscribeShared(group, filter string, qos byte, reg triper.Register, handler Handler) error {
+	return c.Subscribe("$share/"+group+"/"+filter, qos, reg, handler)
+}
+
+// Unsubscribe stops the subscription made with filter, use
+// `$share/group/filter` for the shared subscriptions
+func (c *Client) Unsubscribe(filter string) error {
+	c.mu.Lock()
+	delete(c.subscriptions, filter)
+	c.mu.Unlock()
+
+	if token := c.client.Unsubscribe(filter); token.Wait() && token.Error() != nil && token.Error() != MQTT.ErrNotConnected {
+		return token.Error()
+	}
+
+	return nil
+}
+
+// Close disconnects from the broker, it fails if there are buffered events
+// that could not be published
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	pending := len(c.pending)
+	c.pending = nil
+	c.mu.Unlock()
+
+	c.client.Disconnect(250)
+	c.notify(eventbus.StateClosed, nil)
+
+	if pending > 0 {
+		return fmt.Errorf("mosquitto: %d buffered events were not published", pending)
+	}
+
+	return nil
+}
+
+// DecodeMessage returns the event received from mqtt, the metadata like the
+// trace context travels inside the payload
+func DecodeMessage(msg MQTT.Message, reg triper.Register) (triper.Event, error) {
+	return triper.UnmarshalEvent(msg.Payload(), reg)
 }
@@ -0,0 +1,324 @@
+package mosquitto
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/eventbus"
+)
+
+type token struct {
+	err     error
+	timeout bool
+}
+
+func (t token) Wait() bool                     { return true }
+func (t token) WaitTimeout(time.Duration) bool { return !t.timeout }
+func (t token) Error() error                   { return t.err }
+
+// fakeMQTT records the published events instead of sending them to a broker
+type fakeMQTT struct {
+	MQTT.Client
+
+	mu     sync.Mutex
+	open   bool
+	stuck  bool
+	events []string
+
+	// onPublish is called before the event is published
+	onPublish func(id string)
+}
+
+func (f *fakeMQTT) IsConnectionOpen() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open
+}
+
+func (f *fakeMQTT) setOpen(open bool) {
+	f.mu.Lock()
+	f.open = open
+	f.mu.Unlock()
+}
+
+func (f *fakeMQTT) setStuck(stuck bool) {
+	f.mu.Lock()
+	f.stuck = stuck
+	f.mu.Unlock()
+}
+
+func (f *fakeMQTT) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	var event triper.Event
+	json.Unmarshal(payload.([]byte), &event)
+
+	if f.onPublish != nil {
+		f.onPublish(event.ID)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.open {
+		return token{err: MQTT.ErrNotConnected}
+	}
+
+	// the broker never acknowledges the event
+	if f.stuck {
+		return token{timeout: true}
+	}
+
+	f.events = append(f.events, event.ID)
+	return token{}
+}
+
+func (f *fakeMQTT) Disconnect(quiesce uint) {
+	f.setOpen(false)
+}
+
+func (f *fakeMQTT) published() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.events...)
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{"bank/account", "bank/account", true},
+		{"bank/account", "bank/loan", false},
+		{"bank/#", "bank/account", true},
+		{"bank/#", "bank/account/created", true},
+		{"bank/#", "bank", true},
+		{"bank/#", "shop/account", false},
+		{"#", "bank/account", true},
+		{"bank/+", "bank/account", true},
+		{"bank/+", "bank/", true},
+		{"bank/+", "bank/account/created", false},
+		{"bank/+", "bank", false},
+		{"+/account", "shop/account", true},
+		{"+/+", "bank", false},
+		{"bank/account", "bank/account/created", false},
+		{"bank/account/created", "bank/account", false},
+		{"$share/group/bank/#", "bank/account", true},
+		{"$share/group/bank/+", "shop/account", false},
+		{"$share/group", "group", false},
+		{"#", "$SYS/load", false},
+		{"+/load", "$SYS/load", false},
+		{"$SYS/#", "$SYS/load", true},
+	}
+
+	for _, test := range tests {
+		if match := MatchTopic(test.filter, test.topic); match != test.match {
+			t.Errorf("expected %v for %s with %s, got %v", test.match, test.filter, test.topic, match)
+		}
+	}
+}
+
+func TestDefaultClientID(t *testing.T) {
+	a, b := DefaultClientID(), DefaultClientID()
+	if a == b {
+		t.Error("expected unique client ids, got", a)
+	}
+
+	if !strings.HasPrefix(a, MqttDefaultClientId+"-") || len(a) > 23 {
+		t.Error("expected a prefixed id of at most 23 characters, got", a)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	options := DefaultOptions()
+	options.Route = Route{QoS: 0}
+	options.Routes = map[string]Route{
+		"bank/account": {QoS: 2, Retained: true},
+		"bank/#":       {QoS: 1},
+		"+/audit":      {QoS: 0, Retained: true},
+	}
+
+	c := newClient(options)
+
+	tests := []struct {
+		topic string
+		route Route
+	}{
+		// bank/# comes before bank/account in lexical order
+		{"bank/account", Route{QoS: 1}},
+		{"bank/loan", Route{QoS: 1}},
+		{"bank", Route{QoS: 1}},
+		{"shop/audit", Route{QoS: 0, Retained: true}},
+		{"shop/order", Route{QoS: 0}},
+		{"shop/audit/log", Route{QoS: 0}},
+	}
+
+	for _, test := range tests {
+		if route := c.route(test.topic); route != test.route {
+			t.Errorf("expected %+v for %s, got %+v", test.route, test.topic, route)
+		}
+	}
+}
+
+func TestBufferFull(t *testing.T) {
+	options := DefaultOptions()
+	options.BufferSize = 2
+
+	fake := &fakeMQTT{}
+	c := newClient(options)
+	c.client = fake
+
+	for _, id := range []string{"e1", "e2"} {
+		if err := c.Publish(triper.Event{ID: id}, "bank", "account"); err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+	}
+
+	if err := c.Publish(triper.Event{ID: "e3"}, "bank", "account"); err != eventbus.ErrBufferFull {
+		t.Error("expected ErrBufferFull, got", err)
+	}
+
+	if published := fake.published(); len(published) != 0 {
+		t.Error("expected no events without connection, got", published)
+	}
+}
+
+func TestFlushOnConnect(t *testing.T) {
+	var states []eventbus.ConnectionState
+
+	options := DefaultOptions()
+	options.OnStateChange = func(state eventbus.ConnectionState, err error) {
+		states = append(states, state)
+	}
+
+	fake := &fakeMQTT{}
+	c := newClient(options)
+	c.client = fake
+
+	c.Publish(triper.Event{ID: "e1"}, "bank", "account")
+	c.Publish(triper.Event{ID: "e2"}, "bank", "account")
+
+	// the buffered events are sent before the new ones
+	fake.setOpen(true)
+	c.onConnect(fake)
+
+	if err := c.Publish(triper.Event{ID: "e3"}, "bank", "account"); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if published := fake.published(); strings.Join(published, ",") != "e1,e2,e3" {
+		t.Error("expected [e1 e2 e3], got", published)
+	}
+
+	if len(states) != 1 || states[0] != eventbus.StateConnected {
+		t.Error("expected the connected state, got", states)
+	}
+}
+
+func TestFlushWithoutLock(t *testing.T) {
+	fake := &fakeMQTT{}
+	c := newClient(DefaultOptions())
+	c.client = fake
+
+	c.Publish(triper.Event{ID: "e1"}, "bank", "account")
+	c.Publish(triper.Event{ID: "e2"}, "bank", "account")
+
+	// an event published while the buffer is flushed waits behind it, the
+	// lock is not held so it does not block
+	fake.onPublish = func(id string) {
+		if id == "e1" {
+			if err := c.Publish(triper.Event{ID: "e3"}, "bank", "account"); err != nil {
+				t.Error("expected nil, got", err)
+			}
+		}
+	}
+
+	fake.setOpen(true)
+	c.onConnect(fake)
+
+	if published := fake.published(); strings.Join(published, ",") != "e1,e2,e3" {
+		t.Error("expected [e1 e2 e3], got", published)
+	}
+}
+
+func TestFlushTimeout(t *testing.T) {
+	var states []eventbus.ConnectionState
+
+	options := DefaultOptions()
+	options.OnStateChange = func(state eventbus.ConnectionState, err error) {
+		states = append(states, state)
+	}
+
+	fake := &fakeMQTT{}
+	c := newClient(options)
+	c.client = fake
+
+	c.Publish(triper.Event{ID: "e1"}, "bank", "account")
+	c.Publish(triper.Event{ID: "e2"}, "bank", "account")
+
+	// the flush gives up on the first event, the buffer is kept
+	fake.setOpen(true)
+	fake.setStuck(true)
+	c.onConnect(fake)
+
+	if err := c.send(c.pending[0]); err != ErrPublishTimeout {
+		t.Error("expected ErrPublishTimeout, got", err)
+	}
+
+	if len(c.pending) != 2 || len(states) != 0 {
+		t.Error("expected 2 buffered events without connection, got", len(c.pending), states)
+	}
+
+	if err := c.Publish(triper.Event{ID: "e3"}, "bank", "account"); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	// the next connection publishes all of them
+	fake.setStuck(false)
+	c.onConnect(fake)
+
+	if published := fake.published(); strings.Join(published, ",") != "e1,e2,e3" {
+		t.Error("expected [e1 e2 e3], got", published)
+	}
+
+	if len(states) != 1 || states[0] != eventbus.StateConnected {
+		t.Error("expected the connected state, got", states)
+	}
+}
+
+func TestCloseWithPendingEvents(t *testing.T) {
+	var states []eventbus.ConnectionState
+
+	options := DefaultOptions()
+	options.OnStateChange = func(state eventbus.ConnectionState, err error) {
+		states = append(states, state)
+	}
+
+	fake := &fakeMQTT{}
+	c := newClient(options)
+	c.client = fake
+
+	c.Publish(triper.Event{ID: "e1"}, "bank", "account")
+	c.Publish(triper.Event{ID: "e2"}, "bank", "account")
+
+	err := c.Close()
+	if err == nil || !strings.Contains(err.Error(), "2 buffered events") {
+		t.Error("expected the buffered events error, got", err)
+	}
+
+	if err = c.Close(); err != nil {
+		t.Error("expected nil on the second close, got", err)
+	}
+
+	if err = c.Publish(triper.Event{ID: "e3"}, "bank", "account"); err != eventbus.ErrClosed {
+		t.Error("expected ErrClosed, got", err)
+	}
+
+	if len(states) != 1 || states[0] != eventbus.StateClosed {
+		t.Error("expected the closed state, got", states)
+	}
+}
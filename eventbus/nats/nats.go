@@ -3,49 +3,172 @@ package nats
 import (
 	"encoding/json"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/eventbus"
 	nats "github.com/nats-io/nats.go"
 )
 
-// Client nats
+// Options to configure the connection
+type Options struct {
+	// ReconnectWait between the reconnection attempts
+	ReconnectWait time.Duration
+	// MaxReconnects attempts before the connection is closed, a negative
+	// value reconnects forever
+	MaxReconnects int
+	// BufferSize in bytes of the events published while reconnecting, the
+	// publishes fail when it is full
+	BufferSize int
+	// OnStateChange is called when the connection changes, it can be nil
+	OnStateChange eventbus.StateHandler
+	// Logger used by the client, it can be replaced later with SetLogger
+	Logger triper.Logger
+}
+
+// DefaultOptions reconnects forever every 2 seconds
+func DefaultOptions() Options {
+	return Options{
+		ReconnectWait: nats.DefaultReconnectWait,
+		MaxReconnects: -1,
+		BufferSize:    nats.DefaultReconnectBufSize,
+	}
+}
+
+// Client nats, it keeps a connection that is reconnected when it is lost,
+// Publish can be used by many goroutines
 type Client struct {
-	Options nats.Options
+	conn    *nats.Conn
+	onState eventbus.StateHandler
+
+	mu     sync.RWMutex
+	logger triper.Logger
 }
 
 // NewClient returns the basic client to access to nats
 func NewClient(urls string, useTLS bool) (*Client, error) {
-	opts := nats.DefaultOptions
+	return NewClientWithOptions(urls, useTLS, DefaultOptions())
+}
+
+// NewClientWithOptions connects to nats, the first connection must
+// succeed, after that it is reconnected as options says
+func NewClientWithOptions(urls string, useTLS bool, options Options) (*Client, error) {
+	c := &Client{
+		onState: options.OnStateChange,
+		logger:  options.Logger,
+	}
+
+	if c.logger == nil {
+		c.logger = triper.NopLogger{}
+	}
+
+	opts := nats.GetDefaultOptions()
 	opts.Secure = useTLS
 	opts.Servers = strings.Split(urls, ",")
+	opts.ReconnectWait = options.ReconnectWait
+	opts.MaxReconnect = options.MaxReconnects
+	opts.ReconnectBufSize = options.BufferSize
+	opts.DisconnectedErrCB = c.disconnected
+	opts.ReconnectedCB = c.reconnected
+	opts.ClosedCB = c.closed
 
 	for i, s := range opts.Servers {
 		opts.Servers[i] = strings.Trim(s, " ")
 	}
 
-	return &Client{
-		opts,
-	}, nil
+	conn, err := opts.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn = conn
+	c.notify(eventbus.StateConnected, nil)
+	return c, nil
 }
 
-// Publish a event
-func (c *Client) Publish(event triper.Event, bucket, subset string) error {
-	nc, err := c.Options.Connect()
-	if err != nil {
-		return err
+// SetLogger used by the client
+func (c *Client) SetLogger(logger triper.Logger) {
+	c.mu.Lock()
+	c.logger = logger
+	c.mu.Unlock()
+}
+
+func (c *Client) log() triper.Logger {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.logger
+}
+
+func (c *Client) notify(state eventbus.ConnectionState, err error) {
+	if c.onState != nil {
+		c.onState(state, err)
+	}
+}
+
+func (c *Client) disconnected(conn *nats.Conn, err error) {
+	// Close also calls it, the closed callback reports that state
+	if conn.IsClosed() {
+		return
 	}
 
-	defer nc.Close()
+	c.log().Warn("nats disconnected", triper.LogError, err)
+	c.notify(eventbus.StateDisconnected, err)
+}
+
+func (c *Client) reconnected(conn *nats.Conn) {
+	c.log().Info("nats reconnected", "url", conn.ConnectedUrl())
+	c.notify(eventbus.StateReconnected, nil)
+}
+
+func (c *Client) closed(conn *nats.Conn) {
+	c.log().Info("nats connection closed")
+	c.notify(eventbus.StateClosed, conn.LastError())
+}
+
+// Conn returns the connection used by the client, it is shared with the
+// jetstream bus
+func (c *Client) Conn() *nats.Conn {
+	return c.conn
+}
 
+// Publish a event, while reconnecting it is kept in the buffer
+func (c *Client) Publish(event triper.Event, bucket, subset string) error {
 	blob, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
 
 	subj := bucket + "." + subset
-	nc.Publish(subj, blob)
-	nc.Flush()
+	if err = c.conn.Publish(subj, blob); err != nil {
+		switch err {
+		case nats.ErrConnectionClosed:
+			err = eventbus.ErrClosed
+		case nats.ErrReconnectBufExceeded:
+			err = eventbus.ErrBufferFull
+		}
+
+		c.log().Error("event not published", "subject", subj, triper.LogEventID, event.ID, triper.LogError, err)
+		return err
+	}
+
+	return nil
+}
+
+// Close flushes the published events and closes the connection
+func (c *Client) Close() error {
+	if c.conn.IsClosed() {
+		return nil
+	}
 
-	err = nc.LastError()
+	// an error means that the buffered events were not sent
+	err := c.conn.FlushTimeout(5 * time.Second)
+	c.conn.Close()
 	return err
 }
+
+// DecodeMsg returns the event received from nats, the metadata like the
+// trace context travels inside the payload
+func DecodeMsg(msg *nats.Msg, reg triper.Register) (triper.Event, error) {
+	return triper.UnmarshalEvent(msg.Data, reg)
+}
@@ -0,0 +1,207 @@
+package nats
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/eventbus"
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+)
+
+type AccountCreated struct {
+	Owner string
+}
+
+// runServer starts nats-server on port, -1 picks a free one
+func runServer(t *testing.T, port int) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   port,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server not ready")
+	}
+
+	return s
+}
+
+// states records the calls of the state handler
+type states struct {
+	mu     sync.Mutex
+	states []eventbus.ConnectionState
+}
+
+func (s *states) handle(state eventbus.ConnectionState, err error) {
+	s.mu.Lock()
+	s.states = append(s.states, state)
+	s.mu.Unlock()
+}
+
+func (s *states) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, len(s.states))
+	for i, state := range s.states {
+		names[i] = state.String()
+	}
+
+	return strings.Join(names, ",")
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not reached")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func event(id string) triper.Event {
+	return triper.Event{ID: id, AggregateID: "a", Type: "account_created", Data: &AccountCreated{"ana"}}
+}
+
+// subscribe collects the ids of the events of subject, the subscription
+// of the connection of the client is restored before the buffered events
+// are sent
+func subscribe(t *testing.T, conn *nats.Conn, subject string) func() []string {
+	reg := triper.NewEventRegister()
+	reg.Set(&AccountCreated{})
+
+	var (
+		mu  sync.Mutex
+		ids []string
+	)
+
+	_, err := conn.Subscribe(subject, func(msg *nats.Msg) {
+		event, err := DecodeMsg(msg, reg)
+		if err != nil {
+			t.Error("expected nil, got", err)
+			return
+		}
+
+		mu.Lock()
+		ids = append(ids, event.ID)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if err = conn.Flush(); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ids...)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	s := runServer(t, -1)
+	port := s.Addr().(*net.TCPAddr).Port
+
+	handler := &states{}
+	opts := DefaultOptions()
+	opts.ReconnectWait = 20 * time.Millisecond
+	opts.OnStateChange = handler.handle
+
+	client, err := NewClientWithOptions(s.ClientURL(), false, opts)
+	if err != nil {
+		s.Shutdown()
+		t.Fatal("expected nil, got", err)
+	}
+
+	conn := client.Conn()
+	received := subscribe(t, conn, "bank.account")
+
+	if err = client.Publish(event("e1"), "bank", "account"); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	waitFor(t, func() bool { return len(received()) == 1 })
+
+	s.Shutdown()
+	waitFor(t, func() bool { return handler.String() == "connected,disconnected" })
+
+	// the events are buffered while the client reconnects
+	for _, id := range []string{"e2", "e3"} {
+		if err = client.Publish(event(id), "bank", "account"); err != nil {
+			t.Fatal("expected nil while reconnecting, got", err)
+		}
+	}
+
+	s = runServer(t, port)
+	defer s.Shutdown()
+
+	waitFor(t, func() bool { return handler.String() == "connected,disconnected,reconnected" })
+	waitFor(t, func() bool { return len(received()) == 3 })
+
+	if ids := received(); ids[1] != "e2" || ids[2] != "e3" {
+		t.Error("expected the buffered events in order, got", ids)
+	}
+
+	// the connection is kept, it is not replaced by a new one
+	if client.Conn() != conn || conn.Reconnects != 1 {
+		t.Error("expected the same connection reconnected once, got", conn.Reconnects)
+	}
+
+	if err = client.Close(); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	waitFor(t, func() bool { return handler.String() == "connected,disconnected,reconnected,closed" })
+}
+
+func TestPublishErrors(t *testing.T) {
+	s := runServer(t, -1)
+
+	opts := DefaultOptions()
+	opts.ReconnectWait = 20 * time.Millisecond
+	opts.BufferSize = 64
+
+	client, err := NewClientWithOptions(s.ClientURL(), false, opts)
+	if err != nil {
+		s.Shutdown()
+		t.Fatal("expected nil, got", err)
+	}
+
+	s.Shutdown()
+	waitFor(t, client.Conn().IsReconnecting)
+
+	// the first event fills the reconnect buffer
+	if err = client.Publish(event("e1"), "bank", "account"); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if err = client.Publish(event("e2"), "bank", "account"); err != eventbus.ErrBufferFull {
+		t.Error("expected ErrBufferFull, got", err)
+	}
+
+	client.Conn().Close()
+
+	if err = client.Publish(event("e3"), "bank", "account"); err != eventbus.ErrClosed {
+		t.Error("expected ErrClosed, got", err)
+	}
+
+	if err = client.Close(); err != nil {
+		t.Error("expected nil on a closed client, got", err)
+	}
+}
@@ -1,66 +1,552 @@
 package rabbitmq
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/eventbus"
 
 	"github.com/streadway/amqp"
 )
 
-// Client rabbitmq
+// nolint
+var (
+	ErrNack           = errors.New("rabbitmq: event rejected by the broker")
+	ErrReturned       = errors.New("rabbitmq: event returned, no queue is bound to its routing key")
+	ErrConfirmTimeout = errors.New("rabbitmq: timeout waiting for the broker confirmation")
+	ErrNotConnected   = errors.New("rabbitmq: timeout waiting for the connection or a channel")
+)
+
+// The exchange types supported by Options.ExchangeType
+const (
+	ExchangeDirect  = "direct"
+	ExchangeTopic   = "topic"
+	ExchangeFanout  = "fanout"
+	ExchangeHeaders = "headers"
+)
+
+// The headers added to the events, they are used to bind the queues of a
+// headers exchange
+const (
+	HeaderSubset        = "subset"
+	HeaderEventType     = "event_type"
+	HeaderAggregateType = "aggregate_type"
+)
+
+// Options to configure the client
+type Options struct {
+	// ExchangeType of the buckets, ExchangeDirect by default
+	ExchangeType string
+	// Exchanges declared when the client connects, the other buckets are
+	// declared the first time an event is published to them
+	Exchanges []string
+	// PoolSize is the max number of channels, every channel publishes an
+	// event at a time
+	PoolSize int
+	// Mandatory makes Publish fail with ErrReturned when the event can't
+	// be routed to a queue
+	Mandatory bool
+	// Timeout waiting for the connection and the broker confirmation
+	Timeout time.Duration
+	// ReconnectWait between the reconnection attempts
+	ReconnectWait time.Duration
+	// TLS connects with amqps when it is set
+	TLS *tls.Config
+	// OnStateChange is called when the connection changes, it can be nil
+	OnStateChange eventbus.StateHandler
+	// Logger used by the client, it can be replaced later with SetLogger
+	Logger triper.Logger
+}
+
+// DefaultOptions publishes to direct exchanges with 8 channels
+func DefaultOptions() Options {
+	return Options{
+		ExchangeType:  ExchangeDirect,
+		PoolSize:      8,
+		Timeout:       30 * time.Second,
+		ReconnectWait: 2 * time.Second,
+	}
+}
+
+// channel in confirm mode, it belongs to the pool of a connection
+type channel struct {
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	pool     *pool
+}
+
+// pool of the channels of a connection
+type pool struct {
+	conn   *amqp.Connection
+	idle   chan *channel
+	opened int
+}
+
+// Client rabbitmq, it keeps a connection with a pool of channels that is
+// recovered when the connection is lost. Publish returns after the broker
+// confirms the event and it can be used by many goroutines
 type Client struct {
-	conn *amqp.Connection
+	url     string
+	options Options
+
+	mu       sync.Mutex
+	logger   triper.Logger
+	pool     *pool
+	ready    chan struct{}
+	declared map[string]bool
+	closed   bool
+	done     chan struct{}
 }
 
 // NewClient returns a Client to acces to rabbitmq
 func NewClient(username, password, host string, port int) (*Client, error) {
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%d/", username, password, host, port))
-	return &Client{
-		conn: conn,
-	}, err
+	return NewClientWithOptions(username, password, host, port, DefaultOptions())
 }
 
-// Publish a event
-func (c *Client) Publish(event triper.Event, bucket, subset string) error {
-	ch, err := c.conn.Channel()
+// NewClientWithOptions connects to rabbitmq, the first connection must
+// succeed, after that it is recovered when it is lost
+func NewClientWithOptions(username, password, host string, port int, options Options) (*Client, error) {
+	scheme := "amqp"
+	if options.TLS != nil {
+		scheme = "amqps"
+	}
+
+	defaults := DefaultOptions()
+	if options.ExchangeType == "" {
+		options.ExchangeType = defaults.ExchangeType
+	}
+
+	if options.PoolSize <= 0 {
+		options.PoolSize = defaults.PoolSize
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = defaults.Timeout
+	}
+
+	if options.ReconnectWait <= 0 {
+		options.ReconnectWait = defaults.ReconnectWait
+	}
+
+	c := &Client{
+		url:     fmt.Sprintf("%s://%s:%s@%s:%d/", scheme, username, password, host, port),
+		options: options,
+		logger:  options.Logger,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if c.logger == nil {
+		c.logger = triper.NopLogger{}
+	}
+
+	lost, err := c.connect()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer ch.Close()
+	go c.watch(lost)
+
+	c.notify(eventbus.StateConnected, nil)
+	return c, nil
+}
+
+// SetLogger used by the client
+func (c *Client) SetLogger(logger triper.Logger) {
+	c.mu.Lock()
+	c.logger = logger
+	c.mu.Unlock()
+}
+
+func (c *Client) log() triper.Logger {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logger
+}
+
+func (c *Client) notify(state eventbus.ConnectionState, err error) {
+	if c.options.OnStateChange != nil {
+		c.options.OnStateChange(state, err)
+	}
+}
+
+func (c *Client) dial() (*amqp.Connection, error) {
+	if c.options.TLS != nil {
+		return amqp.DialTLS(c.url, c.options.TLS)
+	}
+
+	return amqp.Dial(c.url)
+}
+
+// connect opens the connection and declares the topology, the returned
+// channel is notified when the connection is lost. It is registered before
+// the declarations, so a connection lost while they run is noticed
+func (c *Client) connect() (chan *amqp.Error, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	lost := conn.NotifyClose(make(chan *amqp.Error, 1))
+	p := &pool{conn: conn, idle: make(chan *channel, c.options.PoolSize)}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return nil, eventbus.ErrClosed
+	}
+
+	c.pool = p
+	c.declared = make(map[string]bool)
+	c.mu.Unlock()
+
+	for _, exchange := range c.options.Exchanges {
+		ch, err := c.open(p)
+		if err == nil {
+			err = c.declare(ch, exchange)
+		}
+
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		c.release(ch)
+	}
+
+	c.mu.Lock()
+	close(c.ready)
+	c.mu.Unlock()
+
+	return lost, nil
+}
+
+// watch the connection until Close is called, every connection that is
+// lost is replaced by a new one
+func (c *Client) watch(lost chan *amqp.Error) {
+	for {
+		reason := <-lost
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+
+		// the reason is nil when the connection was closed without an
+		// error from the broker
+		var err error = amqp.ErrClosed
+		if reason != nil {
+			err = reason
+		}
+
+		c.ready = make(chan struct{})
+		c.logger.Warn("rabbitmq connection lost", triper.LogError, err)
+		c.mu.Unlock()
+
+		c.notify(eventbus.StateDisconnected, err)
+
+		if lost = c.reconnect(); lost == nil {
+			return
+		}
+
+		c.log().Info("rabbitmq reconnected")
+		c.notify(eventbus.StateReconnected, nil)
+	}
+}
+
+// reconnect until it succeeds, it returns nil when the client is closed
+func (c *Client) reconnect() chan *amqp.Error {
+	for {
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(c.options.ReconnectWait):
+		}
+
+		lost, err := c.connect()
+		if err == eventbus.ErrClosed {
+			return nil
+		}
+
+		if err != nil {
+			c.log().Warn("rabbitmq reconnection failed", triper.LogError, err)
+			continue
+		}
+
+		return lost
+	}
+}
+
+// errPoolFull is returned by open when the pool has PoolSize channels
+var errPoolFull = errors.New("rabbitmq: channel pool is full")
 
-	err = ch.ExchangeDeclare(
-		bucket,   // name
-		"direct", // type
-		true,     // durable
-		false,    // auto-deleted
-		false,    // internal
-		false,    // no-wait
-		nil,      // arguments
+// open a channel in confirm mode if the pool has room for it
+func (c *Client) open(p *pool) (*channel, error) {
+	c.mu.Lock()
+	if p.opened >= c.options.PoolSize {
+		c.mu.Unlock()
+		return nil, errPoolFull
+	}
+	p.opened++
+	c.mu.Unlock()
+
+	ch, err := p.conn.Channel()
+	if err == nil {
+		if err = ch.Confirm(false); err != nil {
+			ch.Close()
+		}
+	}
+
+	if err != nil {
+		c.mu.Lock()
+		p.opened--
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	return &channel{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 1)),
+		pool:     p,
+	}, nil
+}
+
+// acquire waits for the connection and a channel of its pool, it fails
+// with ErrClosed when the client is closed while waiting
+func (c *Client) acquire(timer <-chan time.Time) (*channel, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, eventbus.ErrClosed
+	}
+	ready := c.ready
+	c.mu.Unlock()
+
+	select {
+	case <-ready:
+	case <-c.done:
+		return nil, eventbus.ErrClosed
+	case <-timer:
+		return nil, ErrNotConnected
+	}
+
+	c.mu.Lock()
+	p := c.pool
+	c.mu.Unlock()
+
+	select {
+	case ch := <-p.idle:
+		return ch, nil
+	default:
+	}
+
+	if ch, err := c.open(p); err != errPoolFull {
+		return ch, err
+	}
+
+	select {
+	case ch := <-p.idle:
+		return ch, nil
+	case <-c.done:
+		return nil, eventbus.ErrClosed
+	case <-timer:
+		return nil, ErrNotConnected
+	}
+}
+
+// release returns a channel to its pool
+func (c *Client) release(ch *channel) {
+	ch.pool.idle <- ch
+}
+
+// discard closes a broken channel, so the pool can open another one
+func (c *Client) discard(ch *channel) {
+	ch.ch.Close()
+
+	c.mu.Lock()
+	ch.pool.opened--
+	c.mu.Unlock()
+}
+
+// declare the exchange once per connection
+func (c *Client) declare(ch *channel, exchange string) error {
+	c.mu.Lock()
+	declared := c.declared[exchange] && c.pool == ch.pool
+	c.mu.Unlock()
+
+	if declared {
+		return nil
+	}
+
+	err := ch.ch.ExchangeDeclare(
+		exchange,               // name
+		c.options.ExchangeType, // type
+		true,                   // durable
+		false,                  // auto-deleted
+		false,                  // internal
+		false,                  // no-wait
+		nil,                    // arguments
 	)
 
 	if err != nil {
 		return err
 	}
 
+	c.mu.Lock()
+	if c.pool == ch.pool {
+		c.declared[exchange] = true
+	}
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Publish a event and wait for the broker confirmation
+func (c *Client) Publish(event triper.Event, bucket, subset string) error {
 	body, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
 
-	err = ch.Publish(
-		bucket, // exchange
-		subset, // routing key
-		false,  // mandatory
-		false,  // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         body,
-		},
-	)
+	// metadata is also sent as headers, so the trace context can be read
+	// without decoding the body
+	headers := amqp.Table{}
+	for key, value := range event.Metadata {
+		headers[key] = value
+	}
+
+	if c.options.ExchangeType == ExchangeHeaders {
+		headers[HeaderSubset] = subset
+		headers[HeaderEventType] = event.Type
+		headers[HeaderAggregateType] = event.AggregateType
+	}
+
+	timer := time.NewTimer(c.options.Timeout)
+	defer timer.Stop()
+
+	ch, err := c.acquire(timer.C)
+	if err != nil {
+		c.log().Error("event not published", "exchange", bucket, triper.LogEventID, event.ID, triper.LogError, err)
+		return err
+	}
+
+	if err = c.publish(ch, timer.C, bucket, subset, amqp.Publishing{
+		Headers:      headers,
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		MessageId:    event.ID,
+		Body:         body,
+	}); err != nil {
+		c.log().Error("event not published", "exchange", bucket, "routing_key", subset, triper.LogEventID, event.ID, triper.LogError, err)
+		return err
+	}
+
+	return nil
+}
+
+// publish on ch, the channel is released or discarded
+func (c *Client) publish(ch *channel, timer <-chan time.Time, exchange, key string, msg amqp.Publishing) error {
+	if err := c.declare(ch, exchange); err != nil {
+		// a failed declaration closes the channel
+		c.discard(ch)
+		return err
+	}
+
+	if err := ch.ch.Publish(exchange, key, c.options.Mandatory, false, msg); err != nil {
+		c.discard(ch)
+		return err
+	}
+
+	var returned bool
+	for {
+		select {
+		case <-ch.returns:
+			// the broker sends the return before the confirmation
+			returned = true
+		case confirm, ok := <-ch.confirms:
+			if !ok {
+				c.discard(ch)
+				return amqp.ErrClosed
+			}
+
+			select {
+			case <-ch.returns:
+				returned = true
+			default:
+			}
+
+			c.release(ch)
+
+			switch {
+			case !confirm.Ack:
+				return ErrNack
+			case returned:
+				return ErrReturned
+			default:
+				return nil
+			}
+		case <-timer:
+			// a late confirmation would be read by the next publish
+			c.discard(ch)
+			return ErrConfirmTimeout
+		}
+	}
+}
+
+// Close the connection, the events being published fail
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	close(c.done)
+	conn := c.pool.conn
+	c.mu.Unlock()
+
+	err := conn.Close()
+	c.notify(eventbus.StateClosed, nil)
+
+	if err == amqp.ErrClosed {
+		return nil
+	}
 
 	return err
 }
+
+// DecodeDelivery returns the event received from rabbitmq, the string
+// headers are added to the event metadata except the ones used by the
+// headers exchange
+func DecodeDelivery(delivery amqp.Delivery, reg triper.Register) (triper.Event, error) {
+	event, err := triper.UnmarshalEvent(delivery.Body, reg)
+	if err != nil {
+		return event, err
+	}
+
+	for key, value := range delivery.Headers {
+		switch key {
+		case HeaderSubset, HeaderEventType, HeaderAggregateType:
+			continue
+		}
+
+		if s, ok := value.(string); ok {
+			if event.Metadata == nil {
+				event.Metadata = make(map[string]string)
+			}
+			event.Metadata[key] = s
+		}
+	}
+
+	return event, nil
+}
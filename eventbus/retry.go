@@ -0,0 +1,149 @@
+package eventbus
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// closer is implemented by the buses that keep a connection
+type closer interface {
+	Close() error
+}
+
+// closeBus closes bus if it can be closed
+func closeBus(bus triper.EventBus) error {
+	if c, ok := bus.(closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// forwardLogger sets the logger of bus if it is loggable, the decorators
+// hide the loggable buses from config
+func forwardLogger(bus triper.EventBus, logger triper.Logger) {
+	if loggable, ok := bus.(triper.Loggable); ok {
+		loggable.SetLogger(logger)
+	}
+}
+
+// RetryOptions configures the attempts of a Retry
+type RetryOptions struct {
+	// MaxAttempts of a publish, the first one included
+	MaxAttempts int
+	// InitialBackoff after the first failure, it is doubled after every
+	// failure until MaxBackoff. The waits have a random jitter of half the
+	// backoff so the publishers don't retry at the same time
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retryable decides if a failure is retried, by default all errors but
+	// ErrClosed and ErrCircuitOpen
+	Retryable func(err error) bool
+}
+
+// DefaultRetryOptions tries 5 times during about 1.5 seconds
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+	}
+}
+
+func retryable(err error) bool {
+	return err != ErrClosed && err != ErrCircuitOpen
+}
+
+// Retry publishes the events again when the bus fails
+type Retry struct {
+	bus     triper.EventBus
+	options RetryOptions
+	sleep   func(time.Duration)
+
+	mu     sync.RWMutex
+	logger triper.Logger
+}
+
+// NewRetry wraps bus
+func NewRetry(bus triper.EventBus, options RetryOptions) *Retry {
+	defaults := DefaultRetryOptions()
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaults.MaxAttempts
+	}
+
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = defaults.InitialBackoff
+	}
+
+	if options.MaxBackoff < options.InitialBackoff {
+		options.MaxBackoff = options.InitialBackoff
+	}
+
+	if options.Retryable == nil {
+		options.Retryable = retryable
+	}
+
+	return &Retry{
+		bus:     bus,
+		options: options,
+		sleep:   time.Sleep,
+		logger:  triper.NopLogger{},
+	}
+}
+
+// SetLogger used by the retries and the wrapped bus
+func (r *Retry) SetLogger(logger triper.Logger) {
+	r.mu.Lock()
+	r.logger = logger
+	r.mu.Unlock()
+
+	forwardLogger(r.bus, logger)
+}
+
+func (r *Retry) log() triper.Logger {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.logger
+}
+
+// backoff returns the wait after attempts failures with its jitter
+func (r *Retry) backoff(attempts int) time.Duration {
+	wait := r.options.InitialBackoff
+	for i := 1; i < attempts && wait < r.options.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > r.options.MaxBackoff {
+		wait = r.options.MaxBackoff
+	}
+
+	half := int64(wait / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Publish the event, the last error is returned when every attempt fails
+func (r *Retry) Publish(event triper.Event, bucket, subset string) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		if err = r.bus.Publish(event, bucket, subset); err == nil {
+			return nil
+		}
+
+		if attempt >= r.options.MaxAttempts || !r.options.Retryable(err) {
+			return err
+		}
+
+		wait := r.backoff(attempt)
+		r.log().Warn("publish retried", "attempt", attempt, "wait", wait, triper.LogEventID, event.ID, triper.LogError, err)
+		r.sleep(wait)
+	}
+}
+
+// Close the wrapped bus
+func (r *Retry) Close() error {
+	return closeBus(r.bus)
+}
@@ -0,0 +1,75 @@
+package eventbus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// flakyStub fails the first failures publishes
+type flakyStub struct {
+	failures int
+	calls    int
+	err      error
+}
+
+func (s *flakyStub) Publish(event triper.Event, bucket, subset string) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	bus := &flakyStub{failures: 2, err: errors.New("blip")}
+
+	var waits []time.Duration
+	sut := NewRetry(bus, RetryOptions{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+	sut.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	if err := sut.Publish(triper.Event{}, "banks", "accounts"); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if bus.calls != 3 || len(waits) != 2 {
+		t.Fatal("expected 3 attempts and 2 waits, got", bus.calls, waits)
+	}
+
+	// the jitter keeps the wait between half and the whole backoff
+	if waits[0] < 50*time.Millisecond || waits[0] > 100*time.Millisecond {
+		t.Error("expected the first wait between 50ms and 100ms, got", waits[0])
+	}
+
+	if waits[1] < 100*time.Millisecond || waits[1] > 200*time.Millisecond {
+		t.Error("expected the second wait between 100ms and 200ms, got", waits[1])
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	blip := errors.New("blip")
+	bus := &flakyStub{failures: 10, err: blip}
+
+	sut := NewRetry(bus, RetryOptions{MaxAttempts: 3})
+	sut.sleep = func(time.Duration) {}
+
+	if err := sut.Publish(triper.Event{}, "banks", "accounts"); err != blip {
+		t.Error("expected the last error, got", err)
+	}
+
+	if bus.calls != 3 {
+		t.Error("expected 3 attempts, got", bus.calls)
+	}
+
+	// the errors that are not retryable return at once
+	bus = &flakyStub{failures: 10, err: ErrCircuitOpen}
+	sut = NewRetry(bus, RetryOptions{MaxAttempts: 3})
+	sut.sleep = func(time.Duration) {}
+
+	if err := sut.Publish(triper.Event{}, "banks", "accounts"); err != ErrCircuitOpen || bus.calls != 1 {
+		t.Error("expected ErrCircuitOpen after 1 attempt, got", err, bus.calls)
+	}
+}
@@ -0,0 +1,292 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// SpillOptions configures where a Spill keeps the events
+type SpillOptions struct {
+	// Dir of the spilled events, a file per event
+	Dir string
+	// ReplayInterval between the attempts to publish the spilled events
+	ReplayInterval time.Duration
+	// MaxEvents in the directory, ErrBufferFull is returned when it is
+	// full. Zero means no limit
+	MaxEvents int
+}
+
+// DefaultSpillOptions replays the events of dir every 5 seconds
+func DefaultSpillOptions(dir string) SpillOptions {
+	return SpillOptions{
+		Dir:            dir,
+		ReplayInterval: 5 * time.Second,
+	}
+}
+
+// spilledEvent is the file of a spilled event
+type spilledEvent struct {
+	Bucket string          `json:"bucket"`
+	Subset string          `json:"subset"`
+	Event  json.RawMessage `json:"event"`
+}
+
+// Spill writes the events to disk when the bus fails, they are published
+// in order once it recovers. Publish only fails when the event can't be
+// written. The data of the replayed events is a json.RawMessage, so the
+// buses encode the same json
+type Spill struct {
+	bus     triper.EventBus
+	options SpillOptions
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	logger  triper.Logger
+	pending []string
+	seq     uint64
+	closed  bool
+}
+
+// NewSpill wraps bus, the events left in dir by a previous process are
+// replayed first
+func NewSpill(bus triper.EventBus, options SpillOptions) (*Spill, error) {
+	if options.ReplayInterval <= 0 {
+		options.ReplayInterval = DefaultSpillOptions(options.Dir).ReplayInterval
+	}
+
+	if err := os.MkdirAll(options.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(options.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spill{
+		bus:     bus,
+		options: options,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		logger:  triper.NopLogger{},
+	}
+
+	// the names are the zero padded sequence, so they are sorted
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.pending = append(s.pending, name)
+		if seq >= s.seq {
+			s.seq = seq + 1
+		}
+	}
+	sort.Strings(s.pending)
+
+	go s.run()
+	return s, nil
+}
+
+// SetLogger used by the spill and the wrapped bus
+func (s *Spill) SetLogger(logger triper.Logger) {
+	s.mu.Lock()
+	s.logger = logger
+	s.mu.Unlock()
+
+	forwardLogger(s.bus, logger)
+}
+
+func (s *Spill) log() triper.Logger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logger
+}
+
+// Pending returns the number of spilled events
+func (s *Spill) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// Publish the event, it is spilled when the bus fails or there are events
+// waiting before it
+func (s *Spill) Publish(event triper.Event, bucket, subset string) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+
+	if len(s.pending) > 0 {
+		err := s.spill(event, bucket, subset)
+		s.mu.Unlock()
+		return err
+	}
+	s.mu.Unlock()
+
+	err := s.bus.Publish(event, bucket, subset)
+	if err == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logger.Warn("event spilled", triper.LogEventID, event.ID, triper.LogError, err)
+	return s.spill(event, bucket, subset)
+}
+
+// spill writes the event to a new file, s.mu must be held
+func (s *Spill) spill(event triper.Event, bucket, subset string) error {
+	if s.options.MaxEvents > 0 && len(s.pending) >= s.options.MaxEvents {
+		s.logger.Error("event not spilled", triper.LogEventID, event.ID, triper.LogError, ErrBufferFull)
+		return ErrBufferFull
+	}
+
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	blob, err := json.Marshal(spilledEvent{Bucket: bucket, Subset: subset, Event: raw})
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d.json", s.seq)
+	path := filepath.Join(s.options.Dir, name)
+
+	// a crash does not leave half an event
+	if err = ioutil.WriteFile(path+".tmp", blob, 0600); err != nil {
+		return err
+	}
+
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	s.seq++
+	s.pending = append(s.pending, name)
+	return nil
+}
+
+func (s *Spill) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.options.ReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		s.replay()
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replay publishes the spilled events in order until the bus fails
+func (s *Spill) replay() {
+	for {
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.mu.Unlock()
+			return
+		}
+
+		name := s.pending[0]
+		s.mu.Unlock()
+
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		path := filepath.Join(s.options.Dir, name)
+		event, bucket, subset, err := readSpilled(path)
+		if err != nil {
+			// it can't be read again, so it is dropped
+			s.log().Error("spilled event dropped", "file", name, triper.LogError, err)
+		} else if err = s.bus.Publish(event, bucket, subset); err != nil {
+			s.log().Warn("spilled events not replayed", triper.LogEventID, event.ID, triper.LogError, err)
+			return
+		}
+
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.log().Error("spilled event not removed", "file", name, triper.LogError, err)
+		}
+
+		s.mu.Lock()
+		s.pending = s.pending[1:]
+		s.mu.Unlock()
+	}
+}
+
+func readSpilled(path string) (triper.Event, string, string, error) {
+	var (
+		spilled spilledEvent
+		event   triper.Event
+		data    struct {
+			Data json.RawMessage `json:"data"`
+		}
+	)
+
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return event, "", "", err
+	}
+
+	if err = json.Unmarshal(blob, &spilled); err != nil {
+		return event, "", "", err
+	}
+
+	if err = json.Unmarshal(spilled.Event, &event); err != nil {
+		return event, "", "", err
+	}
+
+	if err = json.Unmarshal(spilled.Event, &data); err != nil {
+		return event, "", "", err
+	}
+
+	event.Data = data.Data
+	return event, spilled.Bucket, spilled.Subset, nil
+}
+
+// Close stops the replay and closes the wrapped bus, the spilled events
+// are replayed by the next Spill of the directory
+func (s *Spill) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+	return closeBus(s.bus)
+}
@@ -0,0 +1,142 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// switchStub fails while down is set
+type switchStub struct {
+	mu     sync.Mutex
+	down   bool
+	events []string
+}
+
+func (s *switchStub) Publish(event triper.Event, bucket, subset string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.down {
+		return errors.New("broker down")
+	}
+
+	s.events = append(s.events, event.ID)
+	return nil
+}
+
+func (s *switchStub) set(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+func (s *switchStub) published() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+func TestSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bus := &switchStub{}
+	opts := DefaultSpillOptions(dir)
+	opts.ReplayInterval = time.Hour
+
+	sut, err := NewSpill(bus, opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	sut.Publish(triper.Event{ID: "e1"}, "banks", "accounts")
+
+	bus.set(true)
+	for _, id := range []string{"e2", "e3"} {
+		if err = sut.Publish(triper.Event{ID: id, Data: map[string]string{"owner": id}}, "banks", "accounts"); err != nil {
+			t.Fatal("expected the event to be spilled, got", err)
+		}
+	}
+
+	// the bus recovered, but e4 must wait for the spilled events
+	bus.set(false)
+	sut.Publish(triper.Event{ID: "e4"}, "banks", "accounts")
+
+	if sut.Pending() != 3 {
+		t.Error("expected 3 spilled events, got", sut.Pending())
+	}
+	sut.Close()
+
+	// a new spill of the directory replays them
+	opts.ReplayInterval = 10 * time.Millisecond
+	sut, err = NewSpill(bus, opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer sut.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sut.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	published := bus.published()
+	expected := []string{"e1", "e2", "e3", "e4"}
+	if len(published) != len(expected) {
+		t.Fatal("expected", expected, "got", published)
+	}
+
+	for i := range expected {
+		if published[i] != expected[i] {
+			t.Error("expected", expected, "got", published)
+			break
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Error("expected an empty directory, got", len(files), "files")
+	}
+}
+
+func TestSpillData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bus := &switchStub{down: true}
+	sut, err := NewSpill(bus, DefaultSpillOptions(dir))
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer sut.Close()
+
+	sut.Publish(triper.Event{ID: "e1", Data: map[string]string{"owner": "ana"}}, "banks", "accounts")
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatal("expected 1 file, got", len(files))
+	}
+
+	event, bucket, subset, err := readSpilled(dir + "/" + files[0].Name())
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	data, _ := json.Marshal(event.Data)
+	if event.ID != "e1" || bucket != "banks" || subset != "accounts" || string(data) != `{"owner":"ana"}` {
+		t.Error("expected the spilled event, got", event, bucket, subset, string(data))
+	}
+}
@@ -0,0 +1,39 @@
+package eventbus
+
+import "errors"
+
+// nolint
+var (
+	ErrClosed     = errors.New("event bus is closed")
+	ErrBufferFull = errors.New("event bus buffer is full")
+)
+
+// ConnectionState of the connection to a broker
+type ConnectionState int
+
+// The states reported to a StateHandler
+const (
+	StateConnected ConnectionState = iota
+	StateDisconnected
+	StateReconnected
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnected:
+		return "reconnected"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// StateHandler is called when the connection to a broker changes, err is
+// the cause of a disconnection
+type StateHandler func(state ConnectionState, err error)
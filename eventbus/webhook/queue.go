@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery of an event to an endpoint
+type Delivery struct {
+	ID        string          `json:"id"`
+	EventID   string          `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Endpoint  string          `json:"endpoint"`
+	Body      json.RawMessage `json:"body"`
+	Attempts  int             `json:"attempts"`
+	Created   time.Time       `json:"created"`
+	// Next is the time of the next attempt
+	Next time.Time `json:"next"`
+}
+
+// Queue keeps the deliveries until they are sent, it is used by a single
+// client
+type Queue interface {
+	// Put adds the delivery or replaces the one with the same id
+	Put(delivery Delivery) error
+	// Remove the delivery with id
+	Remove(id string) error
+	// List the deliveries in the order they were created, it is called once
+	// when the client starts
+	List() ([]Delivery, error)
+}
+
+func sortDeliveries(deliveries []Delivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].Created.Equal(deliveries[j].Created) {
+			return deliveries[i].ID < deliveries[j].ID
+		}
+
+		return deliveries[i].Created.Before(deliveries[j].Created)
+	})
+}
+
+// MemoryQueue loses the deliveries when the process ends
+type MemoryQueue struct {
+	mu         sync.Mutex
+	deliveries map[string]Delivery
+}
+
+// NewMemoryQueue returns an empty queue
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{deliveries: make(map[string]Delivery)}
+}
+
+// Put adds the delivery
+func (q *MemoryQueue) Put(delivery Delivery) error {
+	q.mu.Lock()
+	q.deliveries[delivery.ID] = delivery
+	q.mu.Unlock()
+	return nil
+}
+
+// Remove the delivery with id
+func (q *MemoryQueue) Remove(id string) error {
+	q.mu.Lock()
+	delete(q.deliveries, id)
+	q.mu.Unlock()
+	return nil
+}
+
+// List the deliveries
+func (q *MemoryQueue) List() ([]Delivery, error) {
+	q.mu.Lock()
+	deliveries := make([]Delivery, 0, len(q.deliveries))
+	for _, delivery := range q.deliveries {
+		deliveries = append(deliveries, delivery)
+	}
+	q.mu.Unlock()
+
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
+
+// FileQueue keeps a file per delivery in a directory, so the deliveries
+// are sent after a restart
+type FileQueue struct {
+	dir string
+}
+
+// NewFileQueue creates dir if it does not exist
+func NewFileQueue(dir string) (*FileQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileQueue{dir: dir}, nil
+}
+
+func (q *FileQueue) path(id string) string {
+	sum := sha1.Sum([]byte(id))
+	return filepath.Join(q.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Put writes the delivery in a temporary file that is renamed, so a crash
+// does not leave half a delivery
+func (q *FileQueue) Put(delivery Delivery) error {
+	blob, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	path := q.path(delivery.ID)
+	if err = ioutil.WriteFile(path+".tmp", blob, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// Remove the file of the delivery
+func (q *FileQueue) Remove(id string) error {
+	err := os.Remove(q.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// List reads the deliveries of the directory
+func (q *FileQueue) List() ([]Delivery, error) {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]Delivery, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		blob, err := ioutil.ReadFile(filepath.Join(q.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var delivery Delivery
+		if err = json.Unmarshal(blob, &delivery); err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
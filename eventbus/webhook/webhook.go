@@ -0,0 +1,517 @@
+// Package webhook posts the events as json to http endpoints. The
+// deliveries are kept in a queue and retried with exponential backoff, the
+// requests are signed with a hmac of the endpoint secret
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/eventbus"
+)
+
+// nolint
+var (
+	ErrInvalidEndpoint    = errors.New("webhook endpoint without url")
+	ErrDuplicatedEndpoint = errors.New("webhook endpoint name is duplicated")
+)
+
+// headers sent with every delivery
+const (
+	HeaderSignature = "X-Triper-Signature"
+	HeaderTimestamp = "X-Triper-Timestamp"
+	HeaderEventID   = "X-Triper-Event-Id"
+	HeaderEventType = "X-Triper-Event-Type"
+)
+
+// Endpoint receives the events of a bucket and subset
+type Endpoint struct {
+	// Name of the endpoint in the queue and the stats, URL by default
+	Name string
+	URL  string
+	// Secret used to sign the deliveries, they are not signed when it is
+	// empty
+	Secret string
+	// Bucket and Subset of the events, empty matches any
+	Bucket string
+	Subset string
+	// Headers added to the requests, like an authorization token
+	Headers map[string]string
+}
+
+func (e Endpoint) matches(bucket, subset string) bool {
+	return (e.Bucket == "" || e.Bucket == bucket) && (e.Subset == "" || e.Subset == subset)
+}
+
+// EndpointStats of the deliveries to an endpoint
+type EndpointStats struct {
+	Delivered int
+	// Failed attempts, a delivery can fail many times
+	Failed int
+	// Dropped deliveries after MaxAttempts
+	Dropped             int
+	ConsecutiveFailures int
+	LastError           string
+	LastFailure         time.Time
+}
+
+// Options to configure the deliveries
+type Options struct {
+	Endpoints []Endpoint
+	// Queue of the deliveries, use a FileQueue to keep them after a restart
+	Queue Queue
+	// Timeout of a request
+	Timeout time.Duration
+	// MaxAttempts of a delivery before it is dropped, zero means no limit
+	MaxAttempts int
+	// InitialBackoff after the first failure, it is doubled after every
+	// failure until MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// HTTPClient used to send the requests, its timeout is replaced by
+	// Timeout
+	HTTPClient *http.Client
+	// Logger used by the client
+	Logger triper.Logger
+}
+
+// DefaultOptions keeps the deliveries in memory and tries them 10 times
+// during about 17 minutes
+func DefaultOptions() Options {
+	return Options{
+		Timeout:        10 * time.Second,
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+	}
+}
+
+// Sign returns the signature of body sent at timestamp, it is the hex
+// hmac sha256 of `timestamp.body`
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify is used by the receivers to check the signature of a delivery
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// worker sends the deliveries of an endpoint in order, a slow endpoint
+// does not delay the others
+type worker struct {
+	endpoint Endpoint
+	wake     chan struct{}
+
+	mu      sync.Mutex
+	pending []Delivery
+}
+
+func (w *worker) push(delivery Delivery) {
+	w.mu.Lock()
+	w.pending = append(w.pending, delivery)
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *worker) head() (Delivery, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) == 0 {
+		return Delivery{}, false
+	}
+
+	return w.pending[0], true
+}
+
+// retry replaces the first delivery after a failed attempt
+func (w *worker) retry(delivery Delivery) {
+	w.mu.Lock()
+	w.pending[0] = delivery
+	w.mu.Unlock()
+}
+
+func (w *worker) pop() {
+	w.mu.Lock()
+	w.pending[0] = Delivery{}
+	w.pending = w.pending[1:]
+	w.mu.Unlock()
+}
+
+// Client delivers the events in the background, Publish returns once the
+// deliveries are in the queue
+type Client struct {
+	options Options
+	workers map[string]*worker
+	names   []string
+	http    *http.Client
+
+	logMu  sync.RWMutex
+	logger triper.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	stats  map[string]*EndpointStats
+	last   time.Time
+	closed bool
+}
+
+// NewClient starts a worker per endpoint, the deliveries left in the queue
+// are sent first
+func NewClient(options Options) (*Client, error) {
+	defaults := DefaultOptions()
+	if options.Timeout <= 0 {
+		options.Timeout = defaults.Timeout
+	}
+
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = defaults.InitialBackoff
+	}
+
+	if options.MaxBackoff < options.InitialBackoff {
+		options.MaxBackoff = options.InitialBackoff
+	}
+
+	if options.Queue == nil {
+		options.Queue = NewMemoryQueue()
+	}
+
+	httpClient := http.Client{}
+	if options.HTTPClient != nil {
+		httpClient = *options.HTTPClient
+	}
+	httpClient.Timeout = options.Timeout
+
+	c := &Client{
+		options: options,
+		workers: make(map[string]*worker),
+		http:    &httpClient,
+		logger:  options.Logger,
+		stats:   make(map[string]*EndpointStats),
+	}
+
+	if c.logger == nil {
+		c.logger = triper.NopLogger{}
+	}
+
+	for _, endpoint := range options.Endpoints {
+		if endpoint.URL == "" {
+			return nil, ErrInvalidEndpoint
+		}
+
+		if endpoint.Name == "" {
+			endpoint.Name = endpoint.URL
+		}
+
+		if _, ok := c.workers[endpoint.Name]; ok {
+			return nil, ErrDuplicatedEndpoint
+		}
+
+		c.workers[endpoint.Name] = &worker{endpoint: endpoint, wake: make(chan struct{}, 1)}
+		c.names = append(c.names, endpoint.Name)
+		c.stats[endpoint.Name] = &EndpointStats{}
+	}
+
+	// the queue is read once, then the workers keep their deliveries
+	deliveries, err := options.Queue.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, delivery := range deliveries {
+		w, ok := c.workers[delivery.Endpoint]
+		if !ok {
+			c.logger.Warn("delivery to an unknown endpoint dropped", "endpoint", delivery.Endpoint, triper.LogEventID, delivery.EventID)
+			c.remove(delivery)
+			continue
+		}
+
+		w.pending = append(w.pending, delivery)
+	}
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	for _, name := range c.names {
+		c.wg.Add(1)
+		go c.work(c.workers[name])
+	}
+
+	return c, nil
+}
+
+// SetLogger used by the client
+func (c *Client) SetLogger(logger triper.Logger) {
+	c.logMu.Lock()
+	c.logger = logger
+	c.logMu.Unlock()
+}
+
+func (c *Client) log() triper.Logger {
+	c.logMu.RLock()
+	defer c.logMu.RUnlock()
+	return c.logger
+}
+
+// Publish queues a delivery of event to every endpoint of bucket and
+// subset
+func (c *Client) Publish(event triper.Event, bucket, subset string) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return eventbus.ErrClosed
+	}
+
+	// the deliveries are sent in the order they were created
+	now := time.Now()
+	if !now.After(c.last) {
+		now = c.last.Add(time.Nanosecond)
+	}
+	c.last = now
+
+	// every delivery is queued before the workers get them, so a failure
+	// does not leave some of them sent and the publish can be retried
+	var deliveries []Delivery
+	for _, name := range c.names {
+		if !c.workers[name].endpoint.matches(bucket, subset) {
+			continue
+		}
+
+		delivery := Delivery{
+			ID:        event.ID + "@" + name,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Endpoint:  name,
+			Body:      body,
+			Created:   now,
+			Next:      now,
+		}
+
+		if err = c.options.Queue.Put(delivery); err != nil {
+			c.log().Error("delivery not queued", "endpoint", name, triper.LogEventID, event.ID, triper.LogError, err)
+
+			for _, queued := range deliveries {
+				c.remove(queued)
+			}
+
+			return err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	for _, delivery := range deliveries {
+		c.workers[delivery.Endpoint].push(delivery)
+	}
+
+	return nil
+}
+
+// Stats returns the stats of every endpoint by name
+func (c *Client) Stats() map[string]EndpointStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]EndpointStats, len(c.stats))
+	for name, s := range c.stats {
+		stats[name] = *s
+	}
+
+	return stats
+}
+
+// Close stops the deliveries, the ones left in the queue are sent by the
+// next client that uses it
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	c.mu.Unlock()
+
+	c.cancel()
+	c.wg.Wait()
+	return nil
+}
+
+// work sends the deliveries of w, a delivery waits for the previous ones
+// of its endpoint
+func (c *Client) work(w *worker) {
+	defer c.wg.Done()
+
+	for c.ctx.Err() == nil {
+		delivery, ok := w.head()
+		if ok && !delivery.Next.After(time.Now()) {
+			c.deliver(w, delivery)
+			continue
+		}
+
+		// without deliveries the worker waits for Publish
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if ok {
+			timer = time.NewTimer(time.Until(delivery.Next))
+			timeout = timer.C
+		}
+
+		select {
+		case <-c.ctx.Done():
+		case <-w.wake:
+		case <-timeout:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// deliver sends the first delivery of w, it is retried later with a
+// backoff when it fails
+func (c *Client) deliver(w *worker, delivery Delivery) {
+	endpoint := w.endpoint
+
+	err := c.send(endpoint, delivery)
+	if err == nil {
+		c.remove(delivery)
+		w.pop()
+		c.success(endpoint.Name)
+		return
+	}
+
+	if c.ctx.Err() != nil {
+		return
+	}
+
+	delivery.Attempts++
+	c.failure(endpoint.Name, err)
+
+	if c.options.MaxAttempts > 0 && delivery.Attempts >= c.options.MaxAttempts {
+		c.log().Error("delivery dropped", "endpoint", endpoint.Name, "attempts", delivery.Attempts, triper.LogEventID, delivery.EventID, triper.LogError, err)
+		c.remove(delivery)
+		w.pop()
+		c.drop(endpoint.Name)
+		return
+	}
+
+	c.log().Warn("delivery failed", "endpoint", endpoint.Name, "attempts", delivery.Attempts, triper.LogEventID, delivery.EventID, triper.LogError, err)
+
+	delivery.Next = time.Now().Add(c.backoff(delivery.Attempts))
+	if err = c.options.Queue.Put(delivery); err != nil {
+		c.log().Error("delivery not queued", "endpoint", endpoint.Name, triper.LogEventID, delivery.EventID, triper.LogError, err)
+	}
+
+	w.retry(delivery)
+}
+
+// backoff returns the wait after attempts failures
+func (c *Client) backoff(attempts int) time.Duration {
+	wait := c.options.InitialBackoff
+	for i := 1; i < attempts && wait < c.options.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > c.options.MaxBackoff {
+		wait = c.options.MaxBackoff
+	}
+
+	return wait
+}
+
+func (c *Client) send(endpoint Endpoint, delivery Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(c.ctx)
+	for key, value := range endpoint.Headers {
+		req.Header.Set(key, value)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderEventType, delivery.EventType)
+
+	if endpoint.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, delivery.Body))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// the body is read so the connection is reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s responded %s", endpoint.Name, resp.Status)
+	}
+
+	return nil
+}
+
+func (c *Client) remove(delivery Delivery) {
+	if err := c.options.Queue.Remove(delivery.ID); err != nil {
+		c.log().Error("delivery not removed from the queue", "endpoint", delivery.Endpoint, triper.LogEventID, delivery.EventID, triper.LogError, err)
+	}
+}
+
+func (c *Client) success(name string) {
+	c.mu.Lock()
+	c.stats[name].Delivered++
+	c.stats[name].ConsecutiveFailures = 0
+	c.mu.Unlock()
+}
+
+func (c *Client) failure(name string, err error) {
+	c.mu.Lock()
+	s := c.stats[name]
+	s.Failed++
+	s.ConsecutiveFailures++
+	s.LastError = err.Error()
+	s.LastFailure = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *Client) drop(name string) {
+	c.mu.Lock()
+	c.stats[name].Dropped++
+	c.mu.Unlock()
+}
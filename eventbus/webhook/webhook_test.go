@@ -0,0 +1,347 @@
+package webhook
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+type AccountCreated struct {
+	Owner string
+}
+
+// receiver records the deliveries, it fails the first failures requests
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	events   []string
+	invalid  int
+	secret   string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.secret != "" && !Verify(r.secret, req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderSignature), body) {
+		r.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	r.events = append(r.events, req.Header.Get(HeaderEventID))
+}
+
+func (r *receiver) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not reached")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func event(id string) triper.Event {
+	return triper.Event{ID: id, AggregateID: "a", Type: "account_created", Data: &AccountCreated{"ana"}}
+}
+
+func TestPublishSigned(t *testing.T) {
+	bank := &receiver{secret: "s3cr3t"}
+	bankServer := httptest.NewServer(bank)
+	defer bankServer.Close()
+
+	other := &receiver{}
+	otherServer := httptest.NewServer(other)
+	defer otherServer.Close()
+
+	opts := DefaultOptions()
+	opts.Endpoints = []Endpoint{
+		{Name: "bank", URL: bankServer.URL, Secret: "s3cr3t", Bucket: "bank"},
+		{Name: "other", URL: otherServer.URL, Bucket: "shop"},
+	}
+
+	client, err := NewClient(opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer client.Close()
+
+	for _, id := range []string{"e1", "e2", "e3"} {
+		if err = client.Publish(event(id), "bank", "account"); err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+	}
+
+	waitFor(t, func() bool { return client.Stats()["bank"].Delivered == 3 })
+
+	if received := bank.received(); received[0] != "e1" || received[1] != "e2" || received[2] != "e3" {
+		t.Error("expected the events in order, got", received)
+	}
+
+	bank.mu.Lock()
+	if bank.invalid != 0 {
+		t.Error("expected valid signatures, got", bank.invalid, "invalid")
+	}
+	bank.mu.Unlock()
+
+	if received := other.received(); len(received) != 0 {
+		t.Error("expected no events in the other endpoint, got", received)
+	}
+
+	if stats := client.Stats()["bank"]; stats.Delivered != 3 {
+		t.Error("expected 3 delivered events, got", stats.Delivered)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	bank := &receiver{failures: 2}
+	server := httptest.NewServer(bank)
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.InitialBackoff = 10 * time.Millisecond
+	opts.Endpoints = []Endpoint{{Name: "bank", URL: server.URL}}
+
+	client, err := NewClient(opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer client.Close()
+
+	client.Publish(event("e1"), "bank", "account")
+	client.Publish(event("e2"), "bank", "account")
+
+	waitFor(t, func() bool { return client.Stats()["bank"].Delivered == 2 })
+
+	// e2 waits for e1 even though its first attempt would succeed
+	if received := bank.received(); received[0] != "e1" || received[1] != "e2" {
+		t.Error("expected the events in order, got", received)
+	}
+
+	stats := client.Stats()["bank"]
+	if stats.Failed != 2 || stats.Delivered != 2 || stats.ConsecutiveFailures != 0 {
+		t.Errorf("expected 2 failures and 2 deliveries, got %+v", stats)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	bank := &receiver{failures: 10}
+	server := httptest.NewServer(bank)
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.InitialBackoff = time.Millisecond
+	opts.MaxAttempts = 2
+	opts.Endpoints = []Endpoint{{Name: "bank", URL: server.URL}}
+
+	client, err := NewClient(opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer client.Close()
+
+	client.Publish(event("e1"), "bank", "account")
+	waitFor(t, func() bool { return client.Stats()["bank"].Dropped == 1 })
+
+	if stats := client.Stats()["bank"]; stats.Failed != 2 || stats.LastError == "" {
+		t.Errorf("expected 2 failures with the last error, got %+v", stats)
+	}
+}
+
+func TestFileQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	down := &receiver{failures: 1000}
+	server := httptest.NewServer(down)
+
+	queue, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	opts := DefaultOptions()
+	opts.Queue = queue
+	opts.MaxAttempts = 0
+	opts.Endpoints = []Endpoint{{Name: "bank", URL: server.URL}}
+
+	client, err := NewClient(opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	client.Publish(event("e1"), "bank", "account")
+	client.Publish(event("e2"), "bank", "account")
+	waitFor(t, func() bool { return client.Stats()["bank"].Failed > 0 })
+	client.Close()
+	server.Close()
+
+	deliveries, err := queue.List()
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if len(deliveries) != 2 || deliveries[0].EventID != "e1" || deliveries[0].Attempts != 1 {
+		t.Fatal("expected the 2 deliveries in the queue, got", deliveries)
+	}
+
+	// a new client sends the deliveries left by the previous one
+	up := &receiver{}
+	server = httptest.NewServer(up)
+	defer server.Close()
+
+	opts.Endpoints = []Endpoint{{Name: "bank", URL: server.URL}}
+	opts.InitialBackoff = 10 * time.Millisecond
+
+	queue, _ = NewFileQueue(dir)
+	opts.Queue = queue
+	client, err = NewClient(opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer client.Close()
+
+	// the deliveries are removed after the endpoint receives them
+	waitFor(t, func() bool {
+		deliveries, _ = queue.List()
+		return len(deliveries) == 0
+	})
+
+	if received := up.received(); len(received) != 2 || received[0] != "e1" {
+		t.Error("expected [e1 e2], got", received)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Endpoints = []Endpoint{{Name: "bank"}}
+
+	if _, err := NewClient(opts); err != ErrInvalidEndpoint {
+		t.Error("expected ErrInvalidEndpoint, got", err)
+	}
+
+	opts.Endpoints = []Endpoint{{URL: "http://a"}, {URL: "http://a"}}
+	if _, err := NewClient(opts); err != ErrDuplicatedEndpoint {
+		t.Error("expected ErrDuplicatedEndpoint, got", err)
+	}
+}
+
+func TestSlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slowServer.Close()
+	defer close(release)
+
+	fast := &receiver{}
+	fastServer := httptest.NewServer(fast)
+	defer fastServer.Close()
+
+	opts := DefaultOptions()
+	opts.Endpoints = []Endpoint{
+		{Name: "slow", URL: slowServer.URL},
+		{Name: "fast", URL: fastServer.URL},
+	}
+
+	client, err := NewClient(opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer client.Close()
+
+	client.Publish(event("e1"), "bank", "account")
+	client.Publish(event("e2"), "bank", "account")
+
+	// the fast endpoint does not wait for the request of the slow one
+	waitFor(t, func() bool { return len(fast.received()) == 2 })
+
+	if stats := client.Stats()["slow"]; stats.Delivered != 0 {
+		t.Error("expected the slow endpoint to be waiting, got", stats.Delivered)
+	}
+}
+
+// failingQueue fails the first put of the deliveries of endpoint
+type failingQueue struct {
+	*MemoryQueue
+	endpoint string
+	failed   bool
+}
+
+func (q *failingQueue) Put(delivery Delivery) error {
+	if delivery.Endpoint == q.endpoint && !q.failed {
+		q.failed = true
+		return errors.New("disk full")
+	}
+
+	return q.MemoryQueue.Put(delivery)
+}
+
+func TestPublishQueueFailure(t *testing.T) {
+	first, second := &receiver{}, &receiver{}
+	firstServer, secondServer := httptest.NewServer(first), httptest.NewServer(second)
+	defer firstServer.Close()
+	defer secondServer.Close()
+
+	queue := &failingQueue{MemoryQueue: NewMemoryQueue(), endpoint: "second"}
+
+	opts := DefaultOptions()
+	opts.Queue = queue
+	opts.Endpoints = []Endpoint{
+		{Name: "first", URL: firstServer.URL},
+		{Name: "second", URL: secondServer.URL},
+	}
+
+	client, err := NewClient(opts)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer client.Close()
+
+	// nothing is sent or kept when a delivery can't be queued
+	if err = client.Publish(event("e1"), "bank", "account"); err == nil {
+		t.Fatal("expected the queue error")
+	}
+
+	if deliveries, _ := queue.List(); len(deliveries) != 0 {
+		t.Error("expected an empty queue, got", deliveries)
+	}
+
+	// so the retry sends the event once to every endpoint
+	if err = client.Publish(event("e1"), "bank", "account"); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	waitFor(t, func() bool { return len(first.received()) == 1 && len(second.received()) == 1 })
+	time.Sleep(20 * time.Millisecond)
+
+	if len(first.received()) != 1 || len(second.received()) != 1 {
+		t.Error("expected e1 once in every endpoint, got", first.received(), second.received())
+	}
+}
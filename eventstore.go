@@ -1,8 +1,47 @@
 package triper
 
+import "encoding/json"
+
 // EventStore saves the events from an aggregate
 type EventStore interface {
 	Save(events []Event, version int) error
 	SafeSave(events []Event, version int) error
 	Load(aggregateID string) ([]Event, error)
 }
+
+// Stream describes the events stored for an aggregate
+type Stream struct {
+	TenantID      string
+	AggregateID   string
+	AggregateType string
+	Version       int
+}
+
+// Key identifies the stream in the whole store, see StreamKey
+func (s Stream) Key() string {
+	return StreamKey(s.TenantID, s.AggregateID)
+}
+
+// EventStoreInspector is implemented by the event stores that can list
+// their streams, it is used by the tools that work with a whole store
+type EventStoreInspector interface {
+	EventStore
+	Streams() ([]Stream, error)
+}
+
+// RawEventRegister returns a json.RawMessage for every event type, it is
+// used to load events without knowing their types
+type RawEventRegister struct{}
+
+// Set does nothing, every type is accepted
+func (RawEventRegister) Set(source interface{}) {}
+
+// Get returns a *json.RawMessage
+func (RawEventRegister) Get(name string) (interface{}, error) {
+	return &json.RawMessage{}, nil
+}
+
+// Count returns 0, there are not registered types
+func (RawEventRegister) Count() int {
+	return 0
+}
@@ -3,7 +3,9 @@ package badger
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v2"
@@ -12,20 +14,29 @@ import (
 
 // AggregateDB defines version and id of an aggregate
 type AggregateDB struct {
-	ID      string
-	Version int
+	ID       string
+	TenantID string
+	Type     string
+	Version  int
 }
 
+// EncodingJSON is used for the events data, the events stored before it
+// existed are gob encoded
+const EncodingJSON = "json"
+
 // EventDB defines the structure of the events to be stored
 type EventDB struct {
 	ID            string
+	TenantID      string
 	Type          string
 	AggregateID   string
 	AggregateType string
 	CommandID     string
 	RawData       []byte
+	Encoding      string
 	Timestamp     time.Time
 	Version       int
+	Metadata      map[string]string
 }
 
 // Client for access to badger
@@ -34,7 +45,22 @@ type Client struct {
 	reg     triper.Register
 }
 
-var _ triper.EventStore = (*Client)(nil)
+var (
+	_ triper.EventStoreInspector = (*Client)(nil)
+	_ triper.TenantEventStore    = (*Client)(nil)
+)
+
+// aggregateKey returns the key of an aggregate, the key of its events
+// follows the format aggregateKey.eventID. The aggregates of a tenant are
+// prefixed with \x00tenantID\x00, so they never share keys with the
+// default tenant
+func aggregateKey(tenantID, aggregateID string) string {
+	if tenantID == "" {
+		return aggregateID
+	}
+
+	return "\x00" + tenantID + "\x00" + aggregateID
+}
 
 // NewClient generates a new client for access to badger using badgerhold
 func NewClient(dbDir string, reg triper.Register) (*Client, error) {
@@ -65,23 +91,37 @@ func (c *Client) save(events []triper.Event, version int, safe bool) error {
 	}
 
 	aggregateID := events[0].AggregateID
+	tenantID := events[0].TenantID
+	if err := triper.ValidateTenantID(tenantID); err != nil {
+		return err
+	}
 
 	txn := c.session.NewTransaction(true)
 	defer txn.Discard()
 
-	for _, event := range events {
-		raw, err := encode(event.Data)
+	for i, event := range events {
+		raw, err := json.Marshal(event.Data)
 		if err != nil {
 			return err
 		}
 
+		// events produced by an aggregate already have a version
+		if event.Version == 0 {
+			event.Version = version + i + 1
+		}
+
 		item := EventDB{
 			ID:            event.ID,
+			TenantID:      tenantID,
 			Type:          event.Type,
 			AggregateID:   event.AggregateID,
 			AggregateType: event.AggregateType,
 			CommandID:     event.CommandID,
 			RawData:       raw,
+			Encoding:      EncodingJSON,
+			Timestamp:     time.Now(),
+			Version:       event.Version,
+			Metadata:      event.Metadata,
 		}
 
 		blob, err := encode(item)
@@ -89,9 +129,8 @@ func (c *Client) save(events []triper.Event, version int, safe bool) error {
 			return err
 		}
 
-		// the id contains the aggregateID as prefix
-		// aggregateID.eventID
-		id := fmt.Sprintf("%s.%s", aggregateID, event.ID)
+		// the id contains the aggregate key as prefix
+		id := fmt.Sprintf("%s.%s", aggregateKey(tenantID, aggregateID), event.ID)
 		err = txn.Set([]byte(id), blob)
 		if err != nil {
 			return err
@@ -100,8 +139,10 @@ func (c *Client) save(events []triper.Event, version int, safe bool) error {
 
 	// Now that events are saved, aggregate version needs to be updated
 	aggregate := AggregateDB{
-		ID:      events[0].AggregateID,
-		Version: version + len(events),
+		ID:       aggregateID,
+		TenantID: tenantID,
+		Type:     events[0].AggregateType,
+		Version:  version + len(events),
 	}
 
 	aggregateBlob, err := encode(aggregate)
@@ -109,41 +150,52 @@ func (c *Client) save(events []triper.Event, version int, safe bool) error {
 		return err
 	}
 
-	item, err := txn.Get([]byte(aggregate.ID))
+	if !safe {
+		if err = checkVersion(txn, tenantID, aggregateID, version); err != nil {
+			return err
+		}
+	}
+
+	if err = txn.Set([]byte(aggregateKey(tenantID, aggregateID)), aggregateBlob); err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// checkVersion ensures that the stored version of the aggregate is the expected one
+func checkVersion(txn *badger.Txn, tenantID, aggregateID string, version int) error {
+	item, err := txn.Get([]byte(aggregateKey(tenantID, aggregateID)))
 	if version == 0 {
 		switch err {
 		case nil:
-			return fmt.Errorf("badger: %s, aggregate already exists", aggregate.ID)
+			return fmt.Errorf("badger: %s, aggregate already exists", aggregateID)
 		case badger.ErrKeyNotFound:
-			err = txn.Set([]byte(aggregate.ID), aggregateBlob)
+			return nil
 		default: // another error differente from key not found is not desirable
 			return err
 		}
-	} else {
-		var blob []byte
-		_, err = item.ValueCopy(blob)
-		if err != nil {
-			return err
-		}
-
-		var payload AggregateDB
-		err = decode(blob, &payload)
-		if err != nil {
-			return err
-		}
-
-		if payload.Version != version {
-			return fmt.Errorf("badger: %s, aggregate version missmatch, wanted: %d, got: %d", aggregate.ID, version, payload.Version)
-		}
+	}
 
-		err = txn.Set([]byte(aggregate.ID), aggregateBlob)
+	if err != nil {
+		return err
 	}
 
+	blob, err := item.ValueCopy(nil)
 	if err != nil {
 		return err
 	}
 
-	return txn.Commit()
+	var payload AggregateDB
+	if err = decode(blob, &payload); err != nil {
+		return err
+	}
+
+	if payload.Version != version {
+		return fmt.Errorf("badger: %s, aggregate version missmatch, wanted: %d, got: %d", aggregateID, version, payload.Version)
+	}
+
+	return nil
 }
 
 // SafeSave store the events without check the current version
@@ -156,19 +208,24 @@ func (c *Client) Save(events []triper.Event, version int) error {
 	return c.save(events, version, false)
 }
 
-// Load the stored events for an AggregateID
+// Load the stored events for an AggregateID of the default tenant
 func (c *Client) Load(aggregateID string) ([]triper.Event, error) {
+	return c.LoadTenant("", aggregateID)
+}
+
+// LoadTenant loads the stored events for an AggregateID of a tenant
+func (c *Client) LoadTenant(tenantID, aggregateID string) ([]triper.Event, error) {
 	var (
 		events   []triper.Event
 		eventsDB []EventDB
 	)
 
-	c.session.View(func(txn *badger.Txn) error {
+	err := c.session.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
 
-		// prexi has the format aggregateID.
-		prefix := []byte(aggregateID + ".")
+		// prefix has the format aggregateKey.
+		prefix := []byte(aggregateKey(tenantID, aggregateID) + ".")
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
 			err := item.Value(func(v []byte) error {
@@ -191,6 +248,15 @@ func (c *Client) Load(aggregateID string) ([]triper.Event, error) {
 		return nil
 	})
 
+	if err != nil {
+		return nil, err
+	}
+
+	// the keys are sorted by event id, the version gives the real order
+	sort.SliceStable(eventsDB, func(i, j int) bool {
+		return eventsDB[i].Version < eventsDB[j].Version
+	})
+
 	events = make([]triper.Event, len(eventsDB))
 
 	for i, dbEvent := range eventsDB {
@@ -199,24 +265,75 @@ func (c *Client) Load(aggregateID string) ([]triper.Event, error) {
 			return events, err
 		}
 
-		if err = decode(dbEvent.RawData, dataType); err != nil {
+		if dbEvent.Encoding == EncodingJSON {
+			err = json.Unmarshal(dbEvent.RawData, dataType)
+		} else {
+			err = decode(dbEvent.RawData, dataType)
+		}
+
+		if err != nil {
 			return events, err
 		}
 
 		// Translate dbEvent to triper.Event
 		events[i] = triper.Event{
+			ID:            dbEvent.ID,
+			TenantID:      dbEvent.TenantID,
 			AggregateID:   aggregateID,
 			AggregateType: dbEvent.AggregateType,
 			CommandID:     dbEvent.CommandID,
 			Version:       dbEvent.Version,
 			Type:          dbEvent.Type,
 			Data:          dataType,
+			Metadata:      dbEvent.Metadata,
 		}
 	}
 
 	return events, nil
 }
 
+// Streams returns the stored aggregates with their version, the keys
+// without a dot belong to the aggregates
+func (c *Client) Streams() ([]triper.Stream, error) {
+	var streams []triper.Stream
+
+	err := c.session.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if bytes.IndexByte(item.Key(), '.') >= 0 {
+				continue
+			}
+
+			err := item.Value(func(v []byte) error {
+				var aggregate AggregateDB
+				if err := decode(v, &aggregate); err != nil {
+					return err
+				}
+
+				streams = append(streams, triper.Stream{
+					TenantID:      aggregate.TenantID,
+					AggregateID:   aggregate.ID,
+					AggregateType: aggregate.Type,
+					Version:       aggregate.Version,
+				})
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return streams, err
+}
+
 func encode(value interface{}) ([]byte, error) {
 	var buff bytes.Buffer
 	en := gob.NewEncoder(&buff)
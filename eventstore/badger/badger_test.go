@@ -87,3 +87,97 @@ func TestClientLoad(t *testing.T) {
 		t.Errorf("[events] expected: 2, got: %d", length)
 	}
 }
+
+func TestClientSaveVersion(t *testing.T) {
+	aggregateID := triper.GenerateUUID()
+	event := triper.Event{
+		AggregateID:   aggregateID,
+		AggregateType: "order",
+		Type:          "test_event",
+		Data:          TestEvent{Name: "muñeca"},
+	}
+
+	event.ID = triper.GenerateUUID()
+	if err := cli.Save([]triper.Event{event}, 0); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	event.ID = triper.GenerateUUID()
+	if err := cli.Save([]triper.Event{event}, 0); err == nil {
+		t.Error("expected error saving an existing aggregate, got nil")
+	}
+
+	if err := cli.Save([]triper.Event{event}, 1); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	events, err := cli.Load(aggregateID)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	for i, e := range events {
+		if e.Version != i+1 || e.ID == "" {
+			t.Errorf("expected version: %d with id, got: %d %q", i+1, e.Version, e.ID)
+		}
+	}
+
+	streams, err := cli.Streams()
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	for _, stream := range streams {
+		if stream.AggregateID == aggregateID && (stream.Version != 2 || stream.AggregateType != "order") {
+			t.Errorf("unexpected stream: %+v", stream)
+		}
+	}
+}
+
+func TestClientTenants(t *testing.T) {
+	aggregateID := triper.GenerateUUID()
+	for _, tenant := range []string{"", "acme", "globex"} {
+		event := triper.Event{
+			ID:            triper.GenerateUUID(),
+			TenantID:      tenant,
+			AggregateID:   aggregateID,
+			AggregateType: "order",
+			Type:          "test_event",
+			Data:          TestEvent{Name: tenant},
+		}
+
+		if err := cli.Save([]triper.Event{event}, 0); err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+	}
+
+	events, err := cli.LoadTenant("acme", aggregateID)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if len(events) != 1 || events[0].TenantID != "acme" || events[0].Data.(*TestEvent).Name != "acme" {
+		t.Errorf("expected the event of acme, got: %+v", events)
+	}
+
+	events, err = cli.Load(aggregateID)
+	if err != nil || len(events) != 1 || events[0].TenantID != "" {
+		t.Errorf("expected the event of the default tenant, got: %+v %v", events, err)
+	}
+
+	streams, err := cli.Streams()
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	tenants := make(map[string]bool)
+	for _, stream := range streams {
+		if stream.AggregateID == aggregateID {
+			tenants[stream.TenantID] = true
+		}
+	}
+
+	if len(tenants) != 3 {
+		t.Error("expected 3 streams, got", tenants)
+	}
+}
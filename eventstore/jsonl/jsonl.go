@@ -0,0 +1,231 @@
+// Package jsonl exports and imports the events of a store as JSON Lines,
+// one event per line grouped by stream. It is used for backups and to copy
+// the events between stores, like badger to postgresql
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/mishudark/triper"
+)
+
+// DefaultBatchSize is the max quantity of events saved at once by Import
+const DefaultBatchSize = 500
+
+// Options used to export and import the events
+type Options struct {
+	// AggregateTypes to be copied, empty means all of them
+	AggregateTypes []string
+	// From is the key of the first stream to be exported, the streams are
+	// exported sorted by triper.StreamKey so an interrupted export can
+	// continue from the value returned by Resume
+	From string
+	// BatchSize is the max quantity of events saved at once by Import
+	BatchSize int
+}
+
+func (o Options) accept(aggregateType string) bool {
+	if len(o.AggregateTypes) == 0 {
+		return true
+	}
+
+	for _, typ := range o.AggregateTypes {
+		if typ == aggregateType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Result counts the streams and events copied
+type Result struct {
+	Streams int
+	Events  int
+	// Skipped events, they were already in the store
+	Skipped int
+}
+
+// Export writes the events of every stream in store to w
+func Export(w io.Writer, store triper.EventStoreInspector, opts Options) (Result, error) {
+	var result Result
+
+	streams, err := store.Streams()
+	if err != nil {
+		return result, err
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Key() < streams[j].Key()
+	})
+
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+
+	for _, stream := range streams {
+		if stream.Key() < opts.From {
+			continue
+		}
+
+		// old streams don't know their type, the events are checked below
+		if stream.AggregateType != "" && !opts.accept(stream.AggregateType) {
+			continue
+		}
+
+		events, err := triper.LoadTenant(store, stream.TenantID, stream.AggregateID)
+		if err != nil {
+			return result, err
+		}
+
+		if len(events) == 0 || !opts.accept(events[0].AggregateType) {
+			continue
+		}
+
+		for _, event := range events {
+			if err = encoder.Encode(event); err != nil {
+				return result, err
+			}
+		}
+
+		result.Streams++
+		result.Events += len(events)
+	}
+
+	return result, buf.Flush()
+}
+
+// Resume prepares a file written by an interrupted Export, the last stream
+// could be incomplete so it is removed from the file. The returned stream
+// key must be used as Options.From, it is empty if the file has no events
+func Resume(f *os.File) (from string, err error) {
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	var offset, start int64
+	reader := bufio.NewReader(f)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a line without end was not written completely
+			break
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		event, err := triper.UnmarshalEvent(line, nil)
+		if err != nil {
+			break
+		}
+
+		if key := triper.StreamKey(event.TenantID, event.AggregateID); key != from {
+			from = key
+			start = offset
+		}
+
+		offset += int64(len(line))
+	}
+
+	if err = f.Truncate(start); err != nil {
+		return "", err
+	}
+
+	if _, err = f.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return from, nil
+}
+
+// Import saves the events read from r in store. The ids, versions, command
+// ids and metadata are kept. The events already present in store are
+// skipped, so an interrupted import can be run again with the same file.
+// The events keep their tenant, store must support tenants to import them
+func Import(r io.Reader, store triper.EventStore, opts Options) (Result, error) {
+	var (
+		result  Result
+		current string
+		version int
+		batch   []triper.Event
+	)
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := store.Save(batch, version); err != nil {
+			return err
+		}
+
+		version += len(batch)
+		result.Events += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		event, err := triper.UnmarshalEvent(line, nil)
+		if err != nil {
+			return result, err
+		}
+
+		if !opts.accept(event.AggregateType) {
+			continue
+		}
+
+		if key := triper.StreamKey(event.TenantID, event.AggregateID); key != current {
+			if err = flush(); err != nil {
+				return result, err
+			}
+
+			// the version in store tells which events were imported before
+			stored, err := triper.LoadTenant(store, event.TenantID, event.AggregateID)
+			if err != nil {
+				return result, err
+			}
+
+			current = key
+			version = len(stored)
+			result.Streams++
+		}
+
+		if event.Version != 0 && event.Version <= version {
+			result.Skipped++
+			continue
+		}
+
+		batch = append(batch, event)
+		if len(batch) >= opts.BatchSize {
+			if err = flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	return result, flush()
+}
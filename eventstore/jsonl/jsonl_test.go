@@ -0,0 +1,151 @@
+package jsonl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mishudark/triper"
+)
+
+type memStore struct {
+	events map[string][]triper.Event
+}
+
+func newMemStore() *memStore {
+	return &memStore{events: make(map[string][]triper.Event)}
+}
+
+func (m *memStore) Save(events []triper.Event, version int) error {
+	id := events[0].AggregateID
+	if len(m.events[id]) != version {
+		return fmt.Errorf("version missmatch, wanted: %d, got: %d", version, len(m.events[id]))
+	}
+
+	m.events[id] = append(m.events[id], events...)
+	return nil
+}
+
+func (m *memStore) SafeSave(events []triper.Event, version int) error {
+	return m.Save(events, version)
+}
+
+func (m *memStore) Load(aggregateID string) ([]triper.Event, error) {
+	return m.events[aggregateID], nil
+}
+
+func (m *memStore) Streams() ([]triper.Stream, error) {
+	var streams []triper.Stream
+	for id, events := range m.events {
+		streams = append(streams, triper.Stream{
+			AggregateID:   id,
+			AggregateType: events[0].AggregateType,
+			Version:       len(events),
+		})
+	}
+
+	return streams, nil
+}
+
+func source() *memStore {
+	store := newMemStore()
+	for _, id := range []string{"a", "b", "c"} {
+		typ := "account"
+		if id == "b" {
+			typ = "order"
+		}
+
+		for version := 1; version <= 3; version++ {
+			store.events[id] = append(store.events[id], triper.Event{
+				ID:            fmt.Sprintf("%s-%d", id, version),
+				AggregateID:   id,
+				AggregateType: typ,
+				CommandID:     "command-" + id,
+				Version:       version,
+				Type:          "something_happened",
+				Data:          json.RawMessage(`{"n":1}`),
+				Metadata:      map[string]string{"traceparent": id},
+			})
+		}
+	}
+
+	return store
+}
+
+func TestExportImport(t *testing.T) {
+	var buf bytes.Buffer
+
+	result, err := Export(&buf, source(), Options{AggregateTypes: []string{"account"}})
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if result.Streams != 2 || result.Events != 6 {
+		t.Error("expected 2 streams and 6 events, got", result)
+	}
+
+	target := newMemStore()
+	blob := buf.Bytes()
+
+	// the first stream was imported before the interruption
+	if _, err = Import(bytes.NewReader(blob[:bytes.IndexByte(blob, '\n')+1]), target, Options{}); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	result, err = Import(bytes.NewReader(blob), target, Options{BatchSize: 2})
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if result.Skipped != 1 || result.Events != 5 {
+		t.Error("expected 1 skipped and 5 events, got", result)
+	}
+
+	if _, ok := target.events["b"]; ok {
+		t.Error("expected the order aggregate to be filtered")
+	}
+
+	event := target.events["c"][2]
+	if event.ID != "c-3" || event.Version != 3 || event.CommandID != "command-c" || event.Metadata["traceparent"] != "c" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
+
+func TestResume(t *testing.T) {
+	f, err := ioutil.TempFile("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if _, err = Export(&buf, source(), Options{}); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	// the export is interrupted in the middle of the second stream
+	blob := buf.Bytes()
+	f.Write(blob[:len(blob)/2])
+
+	from, err := Resume(f)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if from != "b" {
+		t.Error("expected b, got", from)
+	}
+
+	if _, err = Export(f, source(), Options{From: from}); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	content, _ := ioutil.ReadFile(f.Name())
+	if !bytes.Equal(content, blob) {
+		t.Errorf("expected:\n%s\ngot:\n%s", blob, content)
+	}
+}
@@ -2,60 +2,113 @@ package postgresql
 
 import (
 	"database/sql"
-	"database/sql/driver"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/mishudark/triper"
 )
 
-// AggregateDB defines version and id of an aggregate
-type AggregateDB struct {
-	ID      string
-	Version int
-}
-
-// EventDB defines the structure of the events to be stored
-type EventDB struct {
-	ID            string
-	Type          string
-	AggregateID   string
-	AggregateType string
-	CommandID     string
-	RawData       driver.Value
-	Timestamp     time.Time
-	Version       int
-}
-
-// Client for access to badger
+// Schema creates the tables used by the event store, and migrates the
+// tables created without tenants
+const Schema = `
+CREATE TABLE IF NOT EXISTS aggregates (
+	tenant_id TEXT NOT NULL DEFAULT '',
+	id        TEXT NOT NULL,
+	type      TEXT NOT NULL,
+	version   INTEGER NOT NULL,
+	PRIMARY KEY (tenant_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	seq            BIGSERIAL,
+	id             TEXT PRIMARY KEY,
+	tenant_id      TEXT NOT NULL DEFAULT '',
+	aggregate_id   TEXT NOT NULL,
+	aggregate_type TEXT NOT NULL,
+	command_id     TEXT NOT NULL,
+	version        INTEGER NOT NULL,
+	type           TEXT NOT NULL,
+	data           JSONB,
+	metadata       JSONB,
+	timestamp      TIMESTAMPTZ NOT NULL,
+	UNIQUE (tenant_id, aggregate_id, version)
+);
+
+-- the tables created before the tenants have the keys without tenant_id
+ALTER TABLE aggregates ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+
+DO $$
+BEGIN
+	IF NOT EXISTS (
+		SELECT 1 FROM pg_constraint c
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+		WHERE c.conrelid = 'aggregates'::regclass AND c.contype = 'p' AND a.attname = 'tenant_id'
+	) THEN
+		ALTER TABLE aggregates DROP CONSTRAINT IF EXISTS aggregates_pkey;
+		ALTER TABLE aggregates ADD PRIMARY KEY (tenant_id, id);
+	END IF;
+
+	IF NOT EXISTS (
+		SELECT 1 FROM pg_constraint c
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+		WHERE c.conrelid = 'events'::regclass AND c.contype = 'u' AND a.attname = 'tenant_id'
+	) THEN
+		ALTER TABLE events DROP CONSTRAINT IF EXISTS events_aggregate_id_version_key;
+		ALTER TABLE events ADD UNIQUE (tenant_id, aggregate_id, version);
+	END IF;
+END
+$$;
+`
+
+// Client for access to postgresql
 type Client struct {
 	connector *sql.DB
-	reg     triper.Register
+	reg       triper.Register
+	logger    triper.Logger
 }
 
-var _ triper.EventStore = (*Client)(nil)
-
-
+var (
+	_ triper.EventStoreInspector = (*Client)(nil)
+	_ triper.TenantEventStore    = (*Client)(nil)
+)
 
-// NewClient generates a new client for access to badger using badgerhold
+// NewClient generates a new client for access to postgresql, the tables are
+// created if they don't exist
 func NewClient(psqlInfo string, reg triper.Register) (*Client, error) {
-
 	connector, err := sql.Open("postgres", psqlInfo)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	cli := &Client{
 		connector: connector,
-		reg:     reg,
+		reg:       reg,
+		logger:    triper.NopLogger{},
+	}
+
+	if err = cli.Migrate(); err != nil {
+		connector.Close()
+		return nil, err
 	}
+
 	return cli, nil
 }
 
+// Migrate creates the tables used by the event store, or adds the tenant
+// to the existing ones
+func (c *Client) Migrate() error {
+	_, err := c.connector.Exec(Schema)
+	return err
+}
+
+// SetLogger used by the client
+func (c *Client) SetLogger(logger triper.Logger) {
+	c.logger = logger
+}
+
 // Close db connection
 func (c *Client) Close() error {
 	return c.connector.Close()
@@ -66,84 +119,71 @@ func (c *Client) save(events []triper.Event, version int, safe bool) error {
 		return nil
 	}
 
-	err := c.connector.Ping()
+	aggregateID := events[0].AggregateID
+	tenantID := events[0].TenantID
+
+	tx, err := c.connector.Begin()
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer tx.Rollback()
 
-	aggregateID := events[0].AggregateID
+	// the row is locked until the end of the transaction
+	var current int
+	err = tx.QueryRow("SELECT version FROM aggregates WHERE tenant_id = $1 AND id = $2 FOR UPDATE", tenantID, aggregateID).Scan(&current)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
 
-	for _, event := range events {
-		raw, err := encode(event.Data)
-		if err != nil {
-			return err
+	if !safe {
+		if version == 0 && exists {
+			return fmt.Errorf("postgresql: %s, aggregate already exists", aggregateID)
 		}
 
-		item := EventDB{
-			ID:            event.ID,
-			Type:          event.Type,
-			AggregateID:   event.AggregateID,
-			AggregateType: event.AggregateType,
-			CommandID:     event.CommandID,
-			RawData:       raw,
+		if version != 0 && current != version {
+			return fmt.Errorf("postgresql: %s, aggregate version missmatch, wanted: %d, got: %d", aggregateID, version, current)
 		}
+	}
 
-		/*blob, err := encode(item)
+	for i, event := range events {
+		data, err := json.Marshal(event.Data)
 		if err != nil {
 			return err
 		}
-		*/
 
-
-		// the id contains the aggregateID as prefix
-		// aggregateID.eventID
-		//id := fmt.Sprintf("%s.%s", aggregateID, event.ID)
-		_, err = c.connector.Exec("INSERT INTO items (attrs) VALUES($1)", item)
+		metadata, err := json.Marshal(event.Metadata)
 		if err != nil {
-			log.Fatal(err)
 			return err
 		}
-	}
 
-	// Now that events are saved, aggregate version needs to be updated
-	aggregate := AggregateDB{
-		ID:      aggregateID,
-		Version: version + len(events),
-	}
-
-	aggregateBlob, err := encode(aggregate)
-	if err != nil {
-		return err
-	}
-
-	_, err = c.connector.Exec("SELECT * FROM events WHERE _id = $1", aggregateID)
-	if version == 0 {
-		if err == nil {
-			return fmt.Errorf("postgresql: %s, aggregate already exists", aggregateID)
-		} else{
-			return err
+		// events produced by an aggregate already have a version
+		if event.Version == 0 {
+			event.Version = version + i + 1
 		}
 
-	} else {
-		var blob []byte
-		var payload AggregateDB
-		err = decode(blob, &payload)
+		_, err = tx.Exec(`INSERT INTO events
+			(id, tenant_id, aggregate_id, aggregate_type, command_id, version, type, data, metadata, timestamp)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			event.ID, tenantID, event.AggregateID, event.AggregateType, event.CommandID,
+			event.Version, event.Type, data, metadata, time.Now())
+
 		if err != nil {
+			c.logger.Error("event not saved", triper.LogEventID, event.ID, triper.LogAggregateID, aggregateID, triper.LogError, err)
 			return err
 		}
-
-		if payload.Version != version {
-			return fmt.Errorf("badger: %s, aggregate version missmatch, wanted: %d, got: %d", aggregate.ID, version, payload.Version)
-		}
-
-		_, err = c.connector.Exec("INSERT INTO items (attrs) VALUES($1)", aggregateBlob)
 	}
 
+	// Now that events are saved, aggregate version needs to be updated
+	_, err = tx.Exec(`INSERT INTO aggregates (tenant_id, id, type, version) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, id) DO UPDATE SET version = EXCLUDED.version`,
+		tenantID, aggregateID, events[0].AggregateType, version+len(events))
+
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 // SafeSave store the events without check the current version
@@ -156,74 +196,74 @@ func (c *Client) Save(events []triper.Event, version int) error {
 	return c.save(events, version, false)
 }
 
-// Load the stored events for an AggregateID
+// Load the stored events for an AggregateID of the default tenant
 func (c *Client) Load(aggregateID string) ([]triper.Event, error) {
-	var (
-		events   []triper.Event
-		eventsDB []EventDB
-	)
+	return c.LoadTenant("", aggregateID)
+}
 
-	aggregate, err := c.connector.Exec("SELECT * FROM events WHERE _id = $1", aggregateID)
+// LoadTenant loads the stored events for an AggregateID of a tenant
+func (c *Client) LoadTenant(tenantID, aggregateID string) ([]triper.Event, error) {
+	rows, err := c.connector.Query(`SELECT id, aggregate_type, command_id, version, type, data, metadata
+		FROM events WHERE tenant_id = $1 AND aggregate_id = $2 ORDER BY version`, tenantID, aggregateID)
 
-	if err != nil {
-		return events, err
-	}
-	var event EventDB
-	err = decode(aggregate, &event)
 	if err != nil {
 		return nil, err
 	}
-	eventsDB = append(eventsDB, event)
-	events = make([]triper.Event, len(eventsDB))
+	defer rows.Close()
 
-	for i, dbEvent := range eventsDB {
-		dataType, err := c.reg.Get(dbEvent.Type)
+	var events []triper.Event
+	for rows.Next() {
+		var (
+			event          triper.Event
+			data, metadata []byte
+		)
+
+		err = rows.Scan(&event.ID, &event.AggregateType, &event.CommandID, &event.Version, &event.Type, &data, &metadata)
 		if err != nil {
 			return events, err
 		}
 
-		if err = decode(dbEvent.RawData, dataType); err != nil {
+		dataType, err := c.reg.Get(event.Type)
+		if err != nil {
 			return events, err
 		}
 
-		// Translate dbEvent to triper.Event
-		events[i] = triper.Event{
-			AggregateID:   aggregateID,
-			AggregateType: dbEvent.AggregateType,
-			CommandID:     dbEvent.CommandID,
-			Version:       dbEvent.Version,
-			Type:          dbEvent.Type,
-			Data:          dataType,
+		if err = json.Unmarshal(data, dataType); err != nil {
+			return events, err
 		}
-	}
-
-	return events, nil
-}
 
-func encode(value interface{}) (driver.Value, error) {
-	// Marshal event data if there is any.
-	if value != nil {
-		rawData, err := json.Marshal(value)
-		if err != nil {
-			return nil, err
+		if len(metadata) > 0 {
+			if err = json.Unmarshal(metadata, &event.Metadata); err != nil {
+				return events, err
+			}
 		}
-		return rawData, nil
 
+		event.TenantID = tenantID
+		event.AggregateID = aggregateID
+		event.Data = dataType
+		events = append(events, event)
 	}
 
-	return nil, errors.New("null value found")
+	return events, rows.Err()
 }
 
-func decode(rawData driver.Value, value interface{}) error {
-	if rawData != nil {
-		b, ok := value.([]byte)
-		if !ok {
-			return errors.New("type assertion to []byte failed")
-		}
+// Streams returns the stored aggregates with their version
+func (c *Client) Streams() ([]triper.Stream, error) {
+	rows, err := c.connector.Query("SELECT tenant_id, id, type, version FROM aggregates ORDER BY tenant_id, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		return json.Unmarshal(b, &rawData)
+	var streams []triper.Stream
+	for rows.Next() {
+		var stream triper.Stream
+		if err = rows.Scan(&stream.TenantID, &stream.AggregateID, &stream.AggregateType, &stream.Version); err != nil {
+			return streams, err
+		}
 
+		streams = append(streams, stream)
 	}
 
-	return errors.New("null value found")
+	return streams, rows.Err()
 }
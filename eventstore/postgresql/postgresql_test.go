@@ -0,0 +1,219 @@
+package postgresql
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// the tests need a database, like postgres://postgres@localhost/triper?sslmode=disable
+const urlEnv = "POSTGRES_URL"
+
+type AccountCreated struct {
+	Owner string
+}
+
+// testSchema creates an empty schema and returns the connection string
+// that uses it, the schema is dropped by the returned func
+func testSchema(t *testing.T) (string, func()) {
+	url := os.Getenv(urlEnv)
+	if url == "" {
+		t.Skip(urlEnv + " is not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	schema := fmt.Sprintf("triper_test_%d", time.Now().UnixNano())
+	if _, err = db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatal("expected nil, got", err)
+	}
+
+	drop := func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	}
+
+	// lib/pq sends the unknown parameters as run-time parameters
+	switch {
+	case !strings.Contains(url, "://"):
+		url += " search_path=" + schema
+	case strings.Contains(url, "?"):
+		url += "&search_path=" + schema
+	default:
+		url += "?search_path=" + schema
+	}
+
+	return url, drop
+}
+
+func newTestClient(t *testing.T, url string) *Client {
+	reg := triper.NewEventRegister()
+	reg.Set(&AccountCreated{})
+
+	client, err := NewClient(url, reg)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	return client
+}
+
+func event(tenantID, aggregateID string, version int) triper.Event {
+	return triper.Event{
+		ID:            triper.GenerateUUID(),
+		TenantID:      tenantID,
+		AggregateID:   aggregateID,
+		AggregateType: "Account",
+		Version:       version,
+		Type:          "account_created",
+		Data:          &AccountCreated{Owner: tenantID},
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	url, drop := testSchema(t)
+	defer drop()
+
+	client := newTestClient(t, url)
+	defer client.Close()
+
+	// the same aggregate id is a different stream in every tenant
+	for _, tenantID := range []string{"", "acme"} {
+		if err := client.Save([]triper.Event{event(tenantID, "a1", 1)}, 0); err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+	}
+
+	if err := client.Save([]triper.Event{event("acme", "a1", 2)}, 1); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	events, err := client.LoadTenant("acme", "a1")
+	if err != nil || len(events) != 2 {
+		t.Fatal("expected 2 events of acme, got", len(events), err)
+	}
+
+	if owner := events[0].Data.(*AccountCreated).Owner; owner != "acme" || events[0].TenantID != "acme" {
+		t.Error("expected the events of acme, got", owner, events[0].TenantID)
+	}
+
+	if events, err = client.Load("a1"); err != nil || len(events) != 1 || events[0].TenantID != "" {
+		t.Error("expected 1 event of the default tenant, got", len(events), err)
+	}
+
+	streams, err := client.Streams()
+	if err != nil || len(streams) != 2 {
+		t.Fatal("expected 2 streams, got", streams, err)
+	}
+
+	if streams[0].TenantID != "" || streams[0].Version != 1 || streams[1].TenantID != "acme" || streams[1].Version != 2 {
+		t.Error("expected the versions of every tenant, got", streams)
+	}
+}
+
+func TestVersionConflict(t *testing.T) {
+	url, drop := testSchema(t)
+	defer drop()
+
+	client := newTestClient(t, url)
+	defer client.Close()
+
+	if err := client.Save([]triper.Event{event("acme", "a1", 1)}, 0); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if err := client.Save([]triper.Event{event("acme", "a1", 1)}, 0); err == nil {
+		t.Error("expected an error creating the aggregate twice")
+	}
+
+	if err := client.Save([]triper.Event{event("acme", "a1", 3)}, 2); err == nil {
+		t.Error("expected a version missmatch")
+	}
+
+	// SafeSave skips the check, the unique version still rejects the event
+	if err := client.SafeSave([]triper.Event{event("acme", "a1", 1)}, 0); err == nil {
+		t.Error("expected a duplicated version error")
+	}
+
+	if events, err := client.LoadTenant("acme", "a1"); err != nil || len(events) != 1 {
+		t.Error("expected only the first event, got", len(events), err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	url, drop := testSchema(t)
+	defer drop()
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	defer db.Close()
+
+	// the tables as they were created before the tenants
+	_, err = db.Exec(`
+		CREATE TABLE aggregates (
+			id      TEXT PRIMARY KEY,
+			type    TEXT NOT NULL,
+			version INTEGER NOT NULL
+		);
+
+		CREATE TABLE events (
+			seq            BIGSERIAL,
+			id             TEXT PRIMARY KEY,
+			aggregate_id   TEXT NOT NULL,
+			aggregate_type TEXT NOT NULL,
+			command_id     TEXT NOT NULL,
+			version        INTEGER NOT NULL,
+			type           TEXT NOT NULL,
+			data           JSONB,
+			metadata       JSONB,
+			timestamp      TIMESTAMPTZ NOT NULL,
+			UNIQUE (aggregate_id, version)
+		);
+
+		INSERT INTO aggregates (id, type, version) VALUES ('a1', 'Account', 1);
+		INSERT INTO events (id, aggregate_id, aggregate_type, command_id, version, type, data, timestamp)
+			VALUES ('e1', 'a1', 'Account', 'c1', 1, 'account_created', '{"Owner":"ana"}', now());
+	`)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	client := newTestClient(t, url)
+	defer client.Close()
+
+	// the migration is idempotent
+	if err = client.Migrate(); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	// the existing events belong to the default tenant
+	events, err := client.Load("a1")
+	if err != nil || len(events) != 1 || events[0].ID != "e1" {
+		t.Fatal("expected the existing event, got", events, err)
+	}
+
+	if err = client.Save([]triper.Event{event("", "a1", 2)}, 1); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	// another tenant can have the same aggregate id and versions
+	if err = client.Save([]triper.Event{event("acme", "a1", 1)}, 0); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	streams, err := client.Streams()
+	if err != nil || len(streams) != 2 || streams[0].Version != 2 || streams[1].Version != 1 {
+		t.Error("expected the streams of both tenants, got", streams, err)
+	}
+}
@@ -3,19 +3,20 @@ package main
 import (
 	"flag"
 	"os"
-	"time"
 
-	"github.com/golang/glog"
 	"github.com/mishudark/triper"
 	"github.com/mishudark/triper/examples/bank"
+	"github.com/mishudark/triper/logger"
 )
 
 func main() {
 	flag.Parse()
 
-	commandBus, err := getConfig()
+	log := logger.New(os.Stderr, logger.LevelInfo)
+
+	commandBus, err := getConfig(log)
 	if err != nil {
-		glog.Infoln(err)
+		log.Error("config failed", triper.LogError, err)
 		os.Exit(1)
 	}
 
@@ -31,13 +32,14 @@ func main() {
 				Owner: "mishudark",
 			}
 
+			account.AggregateID = uuid
 			account.Type = "create_account"
 
 			commandBus.HandleCommand(&account)
-			glog.Infof("account %s - account created", uuid)
+			log.Info("account created", triper.LogAggregateID, uuid)
 
-			//2) Perform a deposit
-			time.Sleep(time.Millisecond * 100)
+			//2) Perform a deposit, the bus keeps the commands of an
+			// aggregate in order so there is no need to wait
 			deposit := bank.PerformDeposit{
 				Amount: 300,
 			}
@@ -46,10 +48,9 @@ func main() {
 			deposit.Version = 1
 
 			commandBus.HandleCommand(&deposit)
-			glog.Infof("account %s - deposit performed", uuid)
+			log.Info("deposit performed", triper.LogAggregateID, uuid)
 
 			//3) Perform a withdrawl
-			time.Sleep(time.Millisecond * 100)
 			withdrawl := bank.PerformWithdrawal{
 				Amount: 249,
 			}
@@ -58,7 +59,7 @@ func main() {
 			withdrawl.Version = 2
 
 			commandBus.HandleCommand(&withdrawl)
-			glog.Infof("account %s - withdrawl performed", uuid)
+			log.Info("withdrawl performed", triper.LogAggregateID, uuid)
 		}()
 	}
 	<-end
@@ -1,6 +1,10 @@
 package triper
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
 
 // FailureType defines the alert(error) type while a command is being processed
 type FailureType string
@@ -14,6 +18,9 @@ const (
 	FailureSavingOnStorage   FailureType = "saving_on_storage"
 	FailurePublishingEvents  FailureType = "publishing_events"
 	FailureVersionMissmatch  FailureType = "version_missmatch"
+	FailureQueueFull         FailureType = "queue_full"
+	FailureInvalidCommand    FailureType = "invalid_command"
+	FailureForbidden         FailureType = "forbidden"
 )
 
 // Failure is an error while the command is being processed
@@ -44,6 +51,68 @@ func NewFailure(err error, typ FailureType, command Command) error {
 	}
 }
 
+// failureJSON is the json representation of a Failure, the error is stored as string
+type failureJSON struct {
+	CommandID      string      `json:"command_id"`
+	CommandType    string      `json:"command_type"`
+	CommandVersion int         `json:"command_version"`
+	AggregateID    string      `json:"aggregate_id"`
+	AggregateType  string      `json:"aggregate_type"`
+	Type           FailureType `json:"type"`
+	Err            string      `json:"error"`
+	// Fields is present when the error is a ValidationError
+	Fields ValidationError `json:"fields,omitempty"`
+}
+
+// MarshalJSON encodes the failure with the error message
+func (f Failure) MarshalJSON() ([]byte, error) {
+	var msg string
+	if f.Err != nil {
+		msg = f.Err.Error()
+	}
+
+	var fields ValidationError
+	errors.As(f.Err, &fields)
+
+	return json.Marshal(failureJSON{
+		CommandID:      f.CommandID,
+		CommandType:    f.CommandType,
+		CommandVersion: f.CommandVersion,
+		AggregateID:    f.AggregateID,
+		AggregateType:  f.AggregateType,
+		Type:           f.Type,
+		Err:            msg,
+		Fields:         fields,
+	})
+}
+
+// UnmarshalJSON decodes a failure, the error keeps only its message or
+// the fields of a ValidationError
+func (f *Failure) UnmarshalJSON(data []byte) error {
+	var raw failureJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*f = Failure{
+		CommandID:      raw.CommandID,
+		CommandType:    raw.CommandType,
+		CommandVersion: raw.CommandVersion,
+		AggregateID:    raw.AggregateID,
+		AggregateType:  raw.AggregateType,
+		Type:           raw.Type,
+	}
+
+	switch {
+	case len(raw.Fields) > 0:
+		f.Err = raw.Fields
+	case raw.Err != "":
+		f.Err = errors.New(raw.Err)
+	}
+
+	return nil
+}
+
 func (f Failure) Error() string {
 	return fmt.Sprintf("[%s]: command-id=%s command-version=%d aggregate-id=%s error=%s",
 		f.Type,
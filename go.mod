@@ -3,12 +3,12 @@ module github.com/valerybriz/triper
 go 1.13
 
 require (
+	github.com/Shopify/sarama v1.30.0
 	github.com/dgraph-io/badger/v2 v2.0.1
 	github.com/eclipse/paho.mqtt.golang v1.2.0
-	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
-	github.com/lib/pq v1.3.0 // indirect
-	github.com/nats-io/nats-server/v2 v2.1.4 // indirect
-	github.com/nats-io/nats.go v1.9.1
+	github.com/lib/pq v1.3.0
+	github.com/nats-io/nats-server/v2 v2.2.0
+	github.com/nats-io/nats.go v1.12.3
 	github.com/oklog/ulid v1.3.1
 	github.com/streadway/amqp v0.0.0-20200108173154-1c71cc93ed71
 )
@@ -0,0 +1,218 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/tracing"
+)
+
+// Prefix of the path used to send commands, the command type follows it
+const Prefix = "/commands/"
+
+// DefaultMaxBodySize is the max size in bytes of a command body
+const DefaultMaxBodySize = 1 << 20
+
+// TenantAttribute is the attribute of the principal with its tenant, it is
+// used by PrincipalTenant
+const TenantAttribute = "tenant_id"
+
+// PrincipalTenant returns the tenant in the attributes of the principal,
+// the default tenant when there is no principal
+func PrincipalTenant(r *http.Request, principal *triper.Principal) (string, error) {
+	if principal == nil {
+		return "", nil
+	}
+
+	return principal.Attributes[TenantAttribute], nil
+}
+
+// FailureStatus maps a FailureType to an http status code, the failures
+// not found here use http.StatusInternalServerError
+var FailureStatus = map[triper.FailureType]int{
+	triper.FailureLoadingEvents:     http.StatusInternalServerError,
+	triper.FailureReplayingEvents:   http.StatusInternalServerError,
+	triper.FailureProcessingCommand: http.StatusUnprocessableEntity,
+	triper.FailureInvalidID:         http.StatusNotFound,
+	triper.FailureSavingOnStorage:   http.StatusInternalServerError,
+	triper.FailurePublishingEvents:  http.StatusBadGateway,
+	triper.FailureVersionMissmatch:  http.StatusConflict,
+	triper.FailureQueueFull:         http.StatusServiceUnavailable,
+	triper.FailureInvalidCommand:    http.StatusBadRequest,
+	triper.FailureForbidden:         http.StatusForbidden,
+}
+
+// StatusCode returns the http status code for a failure type
+func StatusCode(typ triper.FailureType) int {
+	if status, ok := FailureStatus[typ]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Response is sent back to the client
+type Response struct {
+	ID      string          `json:"id,omitempty"`
+	Failure *triper.Failure `json:"failure,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Gateway decodes commands sent with `POST /commands/{type}` and dispatch
+// them through a command bus. A synchronous bus, that implements
+// triper.CommandDispatcher, reports the failure of the command
+type Gateway struct {
+	bus         triper.CommandBus
+	reg         triper.Register
+	logger      triper.Logger
+	MaxBodySize int64
+	// Authenticate returns who sends the request, it is set in the commands
+	// that implement triper.CommandPrincipal. The request is rejected with
+	// 401 if it returns an error
+	Authenticate func(r *http.Request) (*triper.Principal, error)
+	// ResolveTenant returns the tenant of the request, it is set in the
+	// commands that implement triper.CommandTenant. The body can't choose
+	// the tenant. The request is rejected with 403 if it returns an error,
+	// PrincipalTenant by default
+	ResolveTenant func(r *http.Request, principal *triper.Principal) (string, error)
+}
+
+var _ http.Handler = (*Gateway)(nil)
+
+// NewGateway returns a gateway, reg must contain the command types
+// registered with the name returned by triper.GetTypeName, like a
+// triper.CommandTypeRegister populated with config.CommandTypes
+func NewGateway(bus triper.CommandBus, reg triper.Register) *Gateway {
+	return &Gateway{
+		bus:           bus,
+		reg:           reg,
+		logger:        triper.NopLogger{},
+		MaxBodySize:   DefaultMaxBodySize,
+		ResolveTenant: PrincipalTenant,
+	}
+}
+
+// SetLogger used by the gateway
+func (g *Gateway) SetLogger(logger triper.Logger) {
+	g.logger = logger
+}
+
+// ServeHTTP decodes the command and dispatch it
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, Prefix) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("path must start with %s", Prefix))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	var principal *triper.Principal
+	if g.Authenticate != nil {
+		var err error
+		if principal, err = g.Authenticate(r); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+
+	tenantID := ""
+	if g.ResolveTenant != nil {
+		var err error
+		if tenantID, err = g.ResolveTenant(r, principal); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+
+		if err = triper.ValidateTenantID(tenantID); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	commandType := strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+	value, err := g.reg.Get(commandType)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	command, ok := value.(triper.Command)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not a command", commandType))
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, g.MaxBodySize)
+	if err = json.NewDecoder(body).Decode(command); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the principal and the tenant are set after decoding, the payload
+	// can't change them
+	if c, ok := command.(triper.CommandPrincipal); ok {
+		c.SetPrincipal(principal)
+	}
+
+	if c, ok := command.(triper.CommandTenant); ok {
+		c.SetTenantID(tenantID)
+	}
+
+	// the fields are reported before the command reaches the bus
+	if err = triper.ValidateCommand(command); err != nil {
+		failure := triper.NewFailure(err, triper.FailureInvalidCommand, command).(triper.Failure)
+		writeJSON(w, StatusCode(failure.Type), Response{Failure: &failure})
+		return
+	}
+
+	// continue the trace started by the client
+	if parent := tracing.FromHTTP(r.Header); parent.IsValid() {
+		tracing.InjectCommand(command, parent)
+	}
+
+	g.dispatch(w, command)
+}
+
+func (g *Gateway) dispatch(w http.ResponseWriter, command triper.Command) {
+	dispatcher, ok := g.bus.(triper.CommandDispatcher)
+	if !ok {
+		// the command is handled later, there is nothing to report but the id
+		id := g.bus.HandleCommand(command)
+		if id == "" {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("command rejected"))
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, Response{ID: id})
+		return
+	}
+
+	id, err := dispatcher.Dispatch(command)
+	switch e := err.(type) {
+	case nil:
+		writeJSON(w, http.StatusOK, Response{ID: id})
+	case triper.Failure:
+		writeJSON(w, StatusCode(e.Type), Response{ID: id, Failure: &e})
+	default:
+		fields := append(triper.CommandFields(command), triper.ErrorFields(err)...)
+		g.logger.Error("command not dispatched", fields...)
+		writeJSON(w, http.StatusInternalServerError, Response{ID: id, Error: err.Error()})
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Response{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
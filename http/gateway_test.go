@@ -0,0 +1,230 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/commandbus/direct"
+)
+
+type PerformWithdrawal struct {
+	triper.BaseCommand
+	Amount int `validate:"max=1000"`
+}
+
+type handlerStub struct {
+	handled []triper.Command
+}
+
+func (h *handlerStub) Handle(command triper.Command) error {
+	h.handled = append(h.handled, command)
+
+	if command.(*PerformWithdrawal).Amount > 100 {
+		return triper.NewFailure(errors.New("balance out"), triper.FailureProcessingCommand, command)
+	}
+
+	return nil
+}
+
+type asyncStub struct{}
+
+func (a asyncStub) HandleCommand(command triper.Command) string {
+	command.GenerateUUID()
+	return command.GetID()
+}
+
+func newServer(bus triper.CommandBus) *httptest.Server {
+	reg := triper.NewCommandTypeRegister()
+	reg.Set(&PerformWithdrawal{})
+
+	return httptest.NewServer(NewGateway(bus, reg))
+}
+
+func post(t *testing.T, url, body string) (int, Response) {
+	res, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var response Response
+	if err = json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, response
+}
+
+func TestGatewaySyncBus(t *testing.T) {
+	handler := &handlerStub{}
+	register := triper.NewCommandRegister()
+	register.Add(&PerformWithdrawal{}, handler)
+
+	server := newServer(direct.NewBus(register))
+	defer server.Close()
+
+	status, response := post(t, server.URL+"/commands/perform_withdrawal", `{"AggregateID": "account-1", "Version": 2, "Amount": 50}`)
+	if status != http.StatusOK || response.ID == "" {
+		t.Errorf("expected 200 with id, got: %d %+v", status, response)
+	}
+
+	command := handler.handled[0].(*PerformWithdrawal)
+	if command.AggregateID != "account-1" || command.Version != 2 || command.Amount != 50 {
+		t.Errorf("unexpected command: %+v", command)
+	}
+
+	status, response = post(t, server.URL+"/commands/perform_withdrawal", `{"Amount": 500}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Error("expected 422, got", status)
+	}
+
+	if response.Failure == nil || response.Failure.Type != triper.FailureProcessingCommand || response.Failure.Err.Error() != "balance out" {
+		t.Errorf("expected processing failure, got: %+v", response.Failure)
+	}
+}
+
+func TestGatewayAsyncBus(t *testing.T) {
+	server := newServer(asyncStub{})
+	defer server.Close()
+
+	status, response := post(t, server.URL+"/commands/perform_withdrawal", `{"Amount": 500}`)
+	if status != http.StatusAccepted || response.ID == "" {
+		t.Errorf("expected 202 with id, got: %d %+v", status, response)
+	}
+
+	status, _ = post(t, server.URL+"/commands/close_account", `{}`)
+	if status != http.StatusNotFound {
+		t.Error("expected 404, got", status)
+	}
+
+	status, _ = post(t, server.URL+"/commands/perform_withdrawal", `{"Amount": "a lot"}`)
+	if status != http.StatusBadRequest {
+		t.Error("expected 400, got", status)
+	}
+
+	status, response = post(t, server.URL+"/commands/perform_withdrawal", `{"Amount": 5000}`)
+	if status != http.StatusBadRequest {
+		t.Error("expected 400, got", status)
+	}
+
+	if response.Failure == nil || response.Failure.Type != triper.FailureInvalidCommand {
+		t.Fatalf("expected invalid command failure, got: %+v", response.Failure)
+	}
+
+	fields, ok := response.Failure.Err.(triper.ValidationError)
+	if !ok || len(fields) != 1 || fields[0].Field != "Amount" || fields[0].Rule != "max=1000" {
+		t.Errorf("expected Amount field error, got: %#v", response.Failure.Err)
+	}
+}
+
+func TestGatewayAuthenticate(t *testing.T) {
+	handler := &handlerStub{}
+	register := triper.NewCommandRegister()
+	register.Add(&PerformWithdrawal{}, handler)
+
+	reg := triper.NewCommandTypeRegister()
+	reg.Set(&PerformWithdrawal{})
+
+	gateway := NewGateway(direct.NewBus(register), reg)
+	gateway.Authenticate = func(r *http.Request) (*triper.Principal, error) {
+		if r.Header.Get("Authorization") != "Bearer ana" {
+			return nil, errors.New("invalid token")
+		}
+
+		return &triper.Principal{ID: "ana"}, nil
+	}
+
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	status, _ := post(t, server.URL+"/commands/perform_withdrawal", `{"Amount": 50}`)
+	if status != http.StatusUnauthorized {
+		t.Error("expected 401, got", status)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/commands/perform_withdrawal", strings.NewReader(`{"Amount": 50, "Principal": {"id": "bob"}}`))
+	req.Header.Set("Authorization", "Bearer ana")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("expected 200, got", res.StatusCode)
+	}
+
+	if principal := triper.PrincipalOf(handler.handled[0]); principal == nil || principal.ID != "ana" {
+		t.Errorf("expected principal ana, got: %+v", principal)
+	}
+}
+
+func TestGatewayTenant(t *testing.T) {
+	handler := &handlerStub{}
+	register := triper.NewCommandRegister()
+	register.Add(&PerformWithdrawal{}, handler)
+
+	reg := triper.NewCommandTypeRegister()
+	reg.Set(&PerformWithdrawal{})
+
+	gateway := NewGateway(direct.NewBus(register), reg)
+	gateway.Authenticate = func(r *http.Request) (*triper.Principal, error) {
+		tenant := r.Header.Get("X-Tenant")
+		return &triper.Principal{ID: "ana", Attributes: map[string]string{TenantAttribute: tenant}}, nil
+	}
+
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	send := func(tenant, body string) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/commands/perform_withdrawal", strings.NewReader(body))
+		req.Header.Set("X-Tenant", tenant)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	// the body can't choose the tenant
+	if status := send("acme", `{"Amount": 50, "TenantID": "globex"}`); status != http.StatusOK {
+		t.Fatal("expected 200, got", status)
+	}
+
+	if tenant := triper.TenantOf(handler.handled[0]); tenant != "acme" {
+		t.Error("expected the tenant of the principal, got", tenant)
+	}
+
+	if status := send("", `{"Amount": 50, "TenantID": "globex"}`); status != http.StatusOK {
+		t.Fatal("expected 200, got", status)
+	}
+
+	if tenant := triper.TenantOf(handler.handled[1]); tenant != "" {
+		t.Error("expected the default tenant, got", tenant)
+	}
+
+	if status := send("acme/globex", `{"Amount": 50}`); status != http.StatusBadRequest {
+		t.Error("expected 400 for an invalid tenant, got", status)
+	}
+
+	gateway.ResolveTenant = func(r *http.Request, principal *triper.Principal) (string, error) {
+		return "", errors.New("unknown tenant")
+	}
+
+	if status := send("acme", `{"Amount": 50}`); status != http.StatusForbidden {
+		t.Error("expected 403, got", status)
+	}
+
+	if len(handler.handled) != 2 {
+		t.Error("expected the rejected requests not to be handled, got", len(handler.handled))
+	}
+}
@@ -0,0 +1,81 @@
+package triper
+
+// Logger defines a structured logger, keysAndValues are alternated keys
+// and values, like: "command_id", id, "aggregate_id", aggregateID
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+	// With returns a logger that always adds keysAndValues
+	With(keysAndValues ...interface{}) Logger
+}
+
+// Loggable is implemented by the components that accept a logger
+type Loggable interface {
+	SetLogger(logger Logger)
+}
+
+// Keys used in the structured logs
+const (
+	LogTenantID      = "tenant_id"
+	LogCommandID     = "command_id"
+	LogCommandType   = "command_type"
+	LogAggregateID   = "aggregate_id"
+	LogAggregateType = "aggregate_type"
+	LogFailureType   = "failure_type"
+	LogEventID       = "event_id"
+	LogEventType     = "event_type"
+	LogError         = "error"
+)
+
+// NopLogger discards all the logs
+type NopLogger struct{}
+
+// Debug does nothing
+func (NopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+
+// Info does nothing
+func (NopLogger) Info(msg string, keysAndValues ...interface{}) {}
+
+// Warn does nothing
+func (NopLogger) Warn(msg string, keysAndValues ...interface{}) {}
+
+// Error does nothing
+func (NopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+// With returns the same logger
+func (n NopLogger) With(keysAndValues ...interface{}) Logger {
+	return n
+}
+
+// CommandFields returns the key/values that identify a command
+func CommandFields(command Command) []interface{} {
+	_, name := GetTypeName(command)
+
+	fields := []interface{}{
+		LogCommandID, command.GetID(),
+		LogCommandType, name,
+		LogAggregateID, command.GetAggregateID(),
+		LogAggregateType, command.GetAggregateType(),
+	}
+
+	if tenant := TenantOf(command); tenant != "" {
+		fields = append(fields, LogTenantID, tenant)
+	}
+
+	return fields
+}
+
+// ErrorFields returns the key/values that describe an error, a Failure
+// adds its type
+func ErrorFields(err error) []interface{} {
+	if failure, ok := err.(Failure); ok {
+		return []interface{}{
+			LogFailureType, string(failure.Type),
+			LogError, failure.Err,
+		}
+	}
+
+	return []interface{}{LogError, err}
+}
@@ -0,0 +1,154 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mishudark/triper"
+)
+
+// SlogBackend is satisfied by *slog.Logger and the loggers that follow its style
+type SlogBackend interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Slog adapts a *slog.Logger to triper.Logger
+func Slog(backend SlogBackend) triper.Logger {
+	return &slogLogger{backend: backend}
+}
+
+type slogLogger struct {
+	backend SlogBackend
+	fields  []interface{}
+}
+
+func (s *slogLogger) args(keysAndValues []interface{}) []interface{} {
+	if len(s.fields) == 0 {
+		return keysAndValues
+	}
+
+	return append(append([]interface{}(nil), s.fields...), keysAndValues...)
+}
+
+func (s *slogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	s.backend.Debug(msg, s.args(keysAndValues)...)
+}
+
+func (s *slogLogger) Info(msg string, keysAndValues ...interface{}) {
+	s.backend.Info(msg, s.args(keysAndValues)...)
+}
+
+func (s *slogLogger) Warn(msg string, keysAndValues ...interface{}) {
+	s.backend.Warn(msg, s.args(keysAndValues)...)
+}
+
+func (s *slogLogger) Error(msg string, keysAndValues ...interface{}) {
+	s.backend.Error(msg, s.args(keysAndValues)...)
+}
+
+func (s *slogLogger) With(keysAndValues ...interface{}) triper.Logger {
+	return &slogLogger{
+		backend: s.backend,
+		fields:  s.args(keysAndValues),
+	}
+}
+
+// ZapBackend is satisfied by *zap.SugaredLogger and the loggers that follow its style
+type ZapBackend interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// Zap adapts a *zap.SugaredLogger to triper.Logger
+func Zap(backend ZapBackend) triper.Logger {
+	return &zapLogger{backend: backend}
+}
+
+type zapLogger struct {
+	backend ZapBackend
+	fields  []interface{}
+}
+
+func (z *zapLogger) args(keysAndValues []interface{}) []interface{} {
+	if len(z.fields) == 0 {
+		return keysAndValues
+	}
+
+	return append(append([]interface{}(nil), z.fields...), keysAndValues...)
+}
+
+func (z *zapLogger) Debug(msg string, keysAndValues ...interface{}) {
+	z.backend.Debugw(msg, z.args(keysAndValues)...)
+}
+
+func (z *zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	z.backend.Infow(msg, z.args(keysAndValues)...)
+}
+
+func (z *zapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	z.backend.Warnw(msg, z.args(keysAndValues)...)
+}
+
+func (z *zapLogger) Error(msg string, keysAndValues ...interface{}) {
+	z.backend.Errorw(msg, z.args(keysAndValues)...)
+}
+
+func (z *zapLogger) With(keysAndValues ...interface{}) triper.Logger {
+	return &zapLogger{
+		backend: z.backend,
+		fields:  z.args(keysAndValues),
+	}
+}
+
+// Std adapts a *log.Logger from the standard library, the level and the
+// key/values are written as part of the message
+func Std(backend *log.Logger) triper.Logger {
+	return &stdLogger{backend: backend}
+}
+
+type stdLogger struct {
+	backend *log.Logger
+	fields  []interface{}
+}
+
+func (s *stdLogger) log(level Level, msg string, keysAndValues []interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", strings.ToUpper(level.String()), msg)
+
+	for _, fields := range [][]interface{}{s.fields, keysAndValues} {
+		for i := 0; i+1 < len(fields); i += 2 {
+			fmt.Fprintf(&b, " %v=%v", fields[i], fields[i+1])
+		}
+	}
+
+	s.backend.Println(b.String())
+}
+
+func (s *stdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	s.log(LevelDebug, msg, keysAndValues)
+}
+
+func (s *stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	s.log(LevelInfo, msg, keysAndValues)
+}
+
+func (s *stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	s.log(LevelWarn, msg, keysAndValues)
+}
+
+func (s *stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	s.log(LevelError, msg, keysAndValues)
+}
+
+func (s *stdLogger) With(keysAndValues ...interface{}) triper.Logger {
+	return &stdLogger{
+		backend: s.backend,
+		fields:  append(append([]interface{}(nil), s.fields...), keysAndValues...),
+	}
+}
@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// Level of the logs
+type Level int
+
+// nolint
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// Text writes the logs as key=value pairs, one line per entry
+type Text struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	fields []interface{}
+}
+
+var _ triper.Logger = (*Text)(nil)
+
+// New returns a logger that writes the entries with level or higher to out
+func New(out io.Writer, level Level) *Text {
+	return &Text{
+		mu:    &sync.Mutex{},
+		out:   out,
+		level: level,
+	}
+}
+
+// Debug logs a message with debug level
+func (t *Text) Debug(msg string, keysAndValues ...interface{}) {
+	t.log(LevelDebug, msg, keysAndValues)
+}
+
+// Info logs a message with info level
+func (t *Text) Info(msg string, keysAndValues ...interface{}) {
+	t.log(LevelInfo, msg, keysAndValues)
+}
+
+// Warn logs a message with warn level
+func (t *Text) Warn(msg string, keysAndValues ...interface{}) {
+	t.log(LevelWarn, msg, keysAndValues)
+}
+
+// Error logs a message with error level
+func (t *Text) Error(msg string, keysAndValues ...interface{}) {
+	t.log(LevelError, msg, keysAndValues)
+}
+
+// With returns a logger that always adds keysAndValues
+func (t *Text) With(keysAndValues ...interface{}) triper.Logger {
+	fields := make([]interface{}, 0, len(t.fields)+len(keysAndValues))
+	fields = append(fields, t.fields...)
+	fields = append(fields, keysAndValues...)
+
+	return &Text{
+		mu:     t.mu,
+		out:    t.out,
+		level:  t.level,
+		fields: fields,
+	}
+}
+
+func (t *Text) log(level Level, msg string, keysAndValues []interface{}) {
+	if level < t.level {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("time=")
+	buf.WriteString(time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(" level=")
+	buf.WriteString(level.String())
+	buf.WriteString(" msg=")
+	buf.WriteString(quote(msg))
+
+	writeFields(&buf, t.fields)
+	writeFields(&buf, keysAndValues)
+	buf.WriteByte('\n')
+
+	t.mu.Lock()
+	t.out.Write(buf.Bytes())
+	t.mu.Unlock()
+}
+
+func writeFields(buf *bytes.Buffer, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+
+		var value interface{} = "MISSING"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+
+		buf.WriteByte(' ')
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(quote(fmt.Sprint(value)))
+	}
+}
+
+// quote the value only if it contains spaces, quotes or equal signs
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\n\t") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
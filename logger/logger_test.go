@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mishudark/triper"
+)
+
+type CloseAccount struct {
+	triper.BaseCommand
+}
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(&buf, LevelInfo)
+
+	log.Debug("hidden")
+	if buf.Len() != 0 {
+		t.Error("expected debug to be discarded, got", buf.String())
+	}
+
+	command := &CloseAccount{}
+	command.ID = "01"
+	command.AggregateID = "account-1"
+	err := triper.NewFailure(errors.New("balance out"), triper.FailureProcessingCommand, command)
+
+	fields := append(triper.CommandFields(command), triper.ErrorFields(err)...)
+	log.With("service", "bank").Error("command failed", fields...)
+
+	line := buf.String()
+	expected := []string{
+		"level=error",
+		`msg="command failed"`,
+		"service=bank",
+		"command_id=01",
+		"command_type=close_account",
+		"aggregate_id=account-1",
+		"failure_type=processing_command",
+		`error="balance out"`,
+	}
+
+	for _, field := range expected {
+		if !strings.Contains(line, field) {
+			t.Errorf("expected %s in %s", field, line)
+		}
+	}
+}
+
+type zapStub struct {
+	lines []string
+}
+
+func (z *zapStub) Debugw(msg string, keysAndValues ...interface{}) {}
+
+func (z *zapStub) Infow(msg string, keysAndValues ...interface{}) {
+	z.lines = append(z.lines, fmt.Sprint(append([]interface{}{msg}, keysAndValues...)...))
+}
+
+func (z *zapStub) Warnw(msg string, keysAndValues ...interface{}) {}
+
+func (z *zapStub) Errorw(msg string, keysAndValues ...interface{}) {}
+
+func TestZapAdapter(t *testing.T) {
+	stub := &zapStub{}
+	log := Zap(stub).With("a", 1)
+	log.Info("hello", "b", 2)
+
+	if len(stub.lines) != 1 || stub.lines[0] != fmt.Sprint("hello", "a", 1, "b", 2) {
+		t.Error("expected fields to be passed to the backend, got", stub.lines)
+	}
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Handler returns an http.Handler that serves the metrics in the
+// Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Export(w)
+	})
+}
+
+// Export writes the metrics in the Prometheus text format
+func (r *Registry) Export(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+
+	for _, f := range r.sorted() {
+		fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+
+		for _, s := range f.snapshot() {
+			if f.kind == kindCounter {
+				fmt.Fprintf(buf, "%s%s %s\n", f.name, formatLabels(f.labels, s.labels, ""), formatFloat(s.value))
+				continue
+			}
+
+			for i, bound := range f.buckets {
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labels, formatFloat(bound)), s.counts[i])
+			}
+
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labels, "+Inf"), s.count)
+			fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labels, ""), formatFloat(s.value))
+			fmt.Fprintf(buf, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labels, ""), s.count)
+		}
+	}
+
+	return buf.Flush()
+}
+
+// Expvar returns the metrics as an expvar.Var
+func (r *Registry) Expvar() expvar.Var {
+	return expvar.Func(func() interface{} {
+		values := make(map[string]interface{})
+
+		for _, f := range r.sorted() {
+			var all []map[string]interface{}
+
+			for _, s := range f.snapshot() {
+				labels := make(map[string]string, len(f.labels))
+				for i, name := range f.labels {
+					labels[name] = s.labels[i]
+				}
+
+				entry := map[string]interface{}{
+					"labels": labels,
+				}
+
+				if f.kind == kindCounter {
+					entry["value"] = s.value
+				} else {
+					buckets := make(map[string]uint64, len(f.buckets))
+					for i, bound := range f.buckets {
+						buckets[formatFloat(bound)] = s.counts[i]
+					}
+
+					entry["sum"] = s.value
+					entry["count"] = s.count
+					entry["buckets"] = buckets
+				}
+
+				all = append(all, entry)
+			}
+
+			values[f.name] = all
+		}
+
+		return values
+	})
+}
+
+// PublishExpvar publishes the metrics in expvar under name, like
+// expvar.Publish it panics if the name is already in use
+func (r *Registry) PublishExpvar(name string) {
+	expvar.Publish(name, r.Expvar())
+}
+
+func formatLabels(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i])))
+	}
+
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
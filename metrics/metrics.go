@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// Metrics wraps the triper components to record their activity
+type Metrics struct {
+	registry *Registry
+
+	commands        *Counter
+	rejected        *Counter
+	handlerDuration *Histogram
+	failures        *Counter
+	storeDuration   *Histogram
+	storeErrors     *Counter
+	publishDuration *Histogram
+	publishErrors   *Counter
+}
+
+// New creates the metrics in registry, if it is nil a new one is used
+func New(registry *Registry) *Metrics {
+	if registry == nil {
+		registry = NewRegistry()
+	}
+
+	return &Metrics{
+		registry: registry,
+		commands: registry.Counter("triper_commands_total",
+			"Commands received by the command bus.", "command_type", "aggregate_type"),
+		rejected: registry.Counter("triper_commands_rejected_total",
+			"Commands rejected by the command bus.", "command_type", "aggregate_type"),
+		handlerDuration: registry.Histogram("triper_command_handler_duration_seconds",
+			"Time spent handling a command.", "command_type", "aggregate_type"),
+		failures: registry.Counter("triper_command_failures_total",
+			"Commands that produced a failure.", "command_type", "aggregate_type", "failure_type"),
+		storeDuration: registry.Histogram("triper_eventstore_duration_seconds",
+			"Time spent by the event store.", "operation", "aggregate_type"),
+		storeErrors: registry.Counter("triper_eventstore_errors_total",
+			"Errors returned by the event store.", "operation", "aggregate_type"),
+		publishDuration: registry.Histogram("triper_eventbus_publish_duration_seconds",
+			"Time spent publishing an event.", "bus", "aggregate_type", "event_type"),
+		publishErrors: registry.Counter("triper_eventbus_publish_errors_total",
+			"Errors publishing an event.", "bus", "aggregate_type", "event_type"),
+	}
+}
+
+// Registry returns the registry used to store the metrics
+func (m *Metrics) Registry() *Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return m.registry.Handler()
+}
+
+// commandType returns the registered name of the command
+func commandType(command triper.Command) string {
+	_, name := triper.GetTypeName(command)
+	return name
+}
+
+// CommandBus wraps a command bus to count the commands it receives, the
+// wrapper implements triper.CommandDispatcher only if bus does it
+func (m *Metrics) CommandBus(bus triper.CommandBus) triper.CommandBus {
+	if _, ok := bus.(triper.CommandDispatcher); ok {
+		return &commandDispatcher{commandBus{bus, m}}
+	}
+
+	return &commandBus{bus, m}
+}
+
+type commandBus struct {
+	next    triper.CommandBus
+	metrics *Metrics
+}
+
+type commandDispatcher struct {
+	commandBus
+}
+
+func (b *commandBus) HandleCommand(command triper.Command) string {
+	id := b.next.HandleCommand(command)
+	b.count(command, id)
+	return id
+}
+
+func (b *commandDispatcher) Dispatch(command triper.Command) (id string, err error) {
+	id, err = b.next.(triper.CommandDispatcher).Dispatch(command)
+	b.count(command, id)
+	return id, err
+}
+
+func (b *commandBus) count(command triper.Command, id string) {
+	name := commandType(command)
+	b.metrics.commands.Inc(name, command.GetAggregateType())
+
+	// async bus returns an empty id when the command is rejected
+	if id == "" {
+		b.metrics.rejected.Inc(name, command.GetAggregateType())
+	}
+}
+
+// CommandHandler wraps a command handler to record its latency and failures
+func (m *Metrics) CommandHandler(handler triper.CommandHandler) triper.CommandHandler {
+	return &commandHandler{handler, m}
+}
+
+// HandlerConstructor wraps a command handler constructor like
+// basic.NewCommandHandler, so it can be used with config.WireCommands
+func (m *Metrics) HandlerConstructor(
+	next func(*triper.Repository, triper.AggregateHandler, string, string) triper.CommandHandler,
+) func(*triper.Repository, triper.AggregateHandler, string, string) triper.CommandHandler {
+	return func(repository *triper.Repository, aggregate triper.AggregateHandler, bucket, subset string) triper.CommandHandler {
+		return m.CommandHandler(next(repository, aggregate, bucket, subset))
+	}
+}
+
+type commandHandler struct {
+	next    triper.CommandHandler
+	metrics *Metrics
+}
+
+func (h *commandHandler) Handle(command triper.Command) error {
+	start := time.Now()
+	err := h.next.Handle(command)
+
+	name := commandType(command)
+	h.metrics.handlerDuration.Observe(time.Since(start).Seconds(), name, command.GetAggregateType())
+
+	if err != nil {
+		failureType := "unknown"
+		if failure, ok := err.(triper.Failure); ok {
+			failureType = string(failure.Type)
+		}
+
+		h.metrics.failures.Inc(name, command.GetAggregateType(), failureType)
+	}
+
+	return err
+}
+
+// EventStore wraps an event store to record its latency and errors, the
+// wrapper implements triper.TenantEventStore only if store does it
+func (m *Metrics) EventStore(store triper.EventStore) triper.EventStore {
+	if _, ok := store.(triper.TenantEventStore); ok {
+		return &tenantEventStore{eventStore{store, m}}
+	}
+
+	return &eventStore{store, m}
+}
+
+type eventStore struct {
+	next    triper.EventStore
+	metrics *Metrics
+}
+
+type tenantEventStore struct {
+	eventStore
+}
+
+func (s *eventStore) observe(operation, aggregateType string, start time.Time, err error) {
+	s.metrics.storeDuration.Observe(time.Since(start).Seconds(), operation, aggregateType)
+	if err != nil {
+		s.metrics.storeErrors.Inc(operation, aggregateType)
+	}
+}
+
+func (s *eventStore) Save(events []triper.Event, version int) error {
+	start := time.Now()
+	err := s.next.Save(events, version)
+
+	s.observe("save", aggregateType(events), start, err)
+	return err
+}
+
+func (s *eventStore) SafeSave(events []triper.Event, version int) error {
+	start := time.Now()
+	err := s.next.SafeSave(events, version)
+
+	s.observe("safe_save", aggregateType(events), start, err)
+	return err
+}
+
+func (s *eventStore) Load(aggregateID string) ([]triper.Event, error) {
+	start := time.Now()
+	events, err := s.next.Load(aggregateID)
+
+	s.observe("load", aggregateType(events), start, err)
+	return events, err
+}
+
+func (s *tenantEventStore) LoadTenant(tenantID, aggregateID string) ([]triper.Event, error) {
+	start := time.Now()
+	events, err := s.next.(triper.TenantEventStore).LoadTenant(tenantID, aggregateID)
+
+	s.observe("load", aggregateType(events), start, err)
+	return events, err
+}
+
+// aggregateType returns the type of the aggregate of the events, only the
+// first one is checked because all the events belong to the same aggregate
+func aggregateType(events []triper.Event) string {
+	if len(events) == 0 {
+		return ""
+	}
+
+	return events[0].AggregateType
+}
+
+// EventBus wraps an event bus to record its latency and errors, name is
+// used to tell apart the buses
+func (m *Metrics) EventBus(bus triper.EventBus, name string) triper.EventBus {
+	return &eventBus{bus, name, m}
+}
+
+type eventBus struct {
+	next    triper.EventBus
+	name    string
+	metrics *Metrics
+}
+
+func (b *eventBus) Publish(event triper.Event, bucket, subset string) error {
+	start := time.Now()
+	err := b.next.Publish(event, bucket, subset)
+
+	b.metrics.publishDuration.Observe(time.Since(start).Seconds(), b.name, event.AggregateType, event.Type)
+	if err != nil {
+		b.metrics.publishErrors.Inc(b.name, event.AggregateType, event.Type)
+	}
+
+	return err
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mishudark/triper"
+)
+
+type PerformDeposit struct {
+	triper.BaseCommand
+	Amount int
+}
+
+type handlerStub struct {
+	err error
+}
+
+func (h *handlerStub) Handle(command triper.Command) error {
+	return h.err
+}
+
+type busStub struct {
+	err error
+}
+
+func (b *busStub) Publish(event triper.Event, bucket, subset string) error {
+	return b.err
+}
+
+func TestCommandHandlerFailures(t *testing.T) {
+	m := New(nil)
+	command := &PerformDeposit{}
+	command.AggregateType = "account"
+
+	handler := m.CommandHandler(&handlerStub{})
+	handler.Handle(command)
+
+	handler = m.CommandHandler(&handlerStub{
+		err: triper.NewFailure(errors.New("boom"), triper.FailureSavingOnStorage, command),
+	})
+	handler.Handle(command)
+
+	if count := m.handlerDuration.Count("perform_deposit", "account"); count != 2 {
+		t.Error("expected 2 samples, got", count)
+	}
+
+	if value := m.failures.Value("perform_deposit", "account", string(triper.FailureSavingOnStorage)); value != 1 {
+		t.Error("expected 1 failure, got", value)
+	}
+}
+
+func TestExport(t *testing.T) {
+	m := New(nil)
+	bus := m.EventBus(&busStub{err: errors.New("down")}, "nats")
+	bus.Publish(triper.Event{AggregateType: "account", Type: "deposit_performed"}, "bank", "account")
+
+	var buf bytes.Buffer
+	if err := m.Registry().Export(&buf); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	out := buf.String()
+	expected := []string{
+		"# TYPE triper_eventbus_publish_errors_total counter",
+		`triper_eventbus_publish_errors_total{bus="nats",aggregate_type="account",event_type="deposit_performed"} 1`,
+		"# TYPE triper_eventbus_publish_duration_seconds histogram",
+		`triper_eventbus_publish_duration_seconds_bucket{bus="nats",aggregate_type="account",event_type="deposit_performed",le="+Inf"} 1`,
+		`triper_eventbus_publish_duration_seconds_count{bus="nats",aggregate_type="account",event_type="deposit_performed"} 1`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected line %q in:\n%s", line, out)
+		}
+	}
+
+	if vars := m.Registry().Expvar().String(); !strings.Contains(vars, `"triper_eventbus_publish_errors_total"`) {
+		t.Error("expected the errors counter in expvar, got", vars)
+	}
+}
+
+func TestReadDoesNotCreate(t *testing.T) {
+	r := NewRegistry()
+	counter := r.Counter("triper_test_total", "test", "type")
+	histogram := r.Histogram("triper_test_seconds", "test", "type")
+
+	if value := counter.Value("missing"); value != 0 {
+		t.Error("expected 0, got", value)
+	}
+
+	if count := histogram.Count("missing"); count != 0 {
+		t.Error("expected 0, got", count)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Export(&buf); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if out := buf.String(); strings.Contains(out, "missing") {
+		t.Error("expected no series after the reads, got", out)
+	}
+}
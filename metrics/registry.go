@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds in seconds used by the latency histograms
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindHistogram kind = "histogram"
+)
+
+// Registry stores the metrics and exports them
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// family is a metric with all the series produced by its label values
+type family struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series holds the values for a given combination of label values
+type series struct {
+	labels []string
+	value  float64
+	// histograms only
+	counts []uint64
+	count  uint64
+}
+
+// Counter is a metric that only goes up
+type Counter struct {
+	family *family
+}
+
+// Histogram samples observations and counts them in buckets
+type Histogram struct {
+	family *family
+}
+
+// Counter returns the counter with name, it is created the first time
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.family(name, help, kindCounter, labels, nil)}
+}
+
+// Histogram returns the histogram with name, it is created the first time
+// using DefaultBuckets
+func (r *Registry) Histogram(name, help string, labels ...string) *Histogram {
+	return &Histogram{r.family(name, help, kindHistogram, labels, DefaultBuckets)}
+}
+
+func (r *Registry) family(name, help string, k kind, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != k || len(f.labels) != len(labels) {
+			panic(fmt.Sprintf("metrics: %s already registered with a different type or labels", name))
+		}
+		return f
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	r.families[name] = f
+	return f
+}
+
+// sorted returns the families ordered by name
+func (r *Registry) sorted() []*family {
+	r.mu.RLock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	return families
+}
+
+// lookup returns the series for the label values without creating it,
+// the caller must hold the lock
+func (f *family) lookup(values []string) (*series, bool) {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got: %d", f.name, len(f.labels), len(values)))
+	}
+
+	s, ok := f.series[strings.Join(values, "\xff")]
+	return s, ok
+}
+
+// get returns the series for the label values, it is created the first
+// time, the caller must hold the lock
+func (f *family) get(values []string) *series {
+	s, ok := f.lookup(values)
+	if !ok {
+		s = &series{
+			labels: append([]string(nil), values...),
+		}
+
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+
+		f.series[strings.Join(values, "\xff")] = s
+	}
+
+	return s
+}
+
+// snapshot returns a copy of the series ordered by label values
+func (f *family) snapshot() []series {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	all := make([]series, 0, len(f.series))
+	for _, s := range f.series {
+		copied := *s
+		copied.counts = append([]uint64(nil), s.counts...)
+		all = append(all, copied)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labels, "\xff") < strings.Join(all[j].labels, "\xff")
+	})
+
+	return all
+}
+
+// Inc increments the counter for the label values by 1
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add v to the counter for the label values
+func (c *Counter) Add(v float64, values ...string) {
+	c.family.mu.Lock()
+	c.family.get(values).value += v
+	c.family.mu.Unlock()
+}
+
+// Value returns the current value for the label values, reading a series
+// does not create it
+func (c *Counter) Value(values ...string) float64 {
+	c.family.mu.Lock()
+	defer c.family.mu.Unlock()
+
+	if s, ok := c.family.lookup(values); ok {
+		return s.value
+	}
+
+	return 0
+}
+
+// Observe adds a sample to the histogram for the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.family.mu.Lock()
+	defer h.family.mu.Unlock()
+
+	s := h.family.get(values)
+	s.value += v
+	s.count++
+
+	for i, bound := range h.family.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+}
+
+// Count returns the number of samples for the label values, reading a
+// series does not create it
+func (h *Histogram) Count(values ...string) uint64 {
+	h.family.mu.Lock()
+	defer h.family.mu.Unlock()
+
+	if s, ok := h.family.lookup(values); ok {
+		return s.count
+	}
+
+	return 0
+}
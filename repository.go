@@ -5,6 +5,9 @@ package triper
 type Repository struct {
 	eventStore EventStore
 	eventBus   EventBus
+	logger     Logger
+	authorizer *Authorizer
+	router     EventRouter
 }
 
 // NewRepository creates a repository wieh a eventstore and eventbus access
@@ -12,12 +15,46 @@ func NewRepository(store EventStore, bus EventBus) *Repository {
 	return &Repository{
 		eventStore: store,
 		eventBus:   bus,
+		logger:     NopLogger{},
+		router:     DefaultRouter,
 	}
 }
 
-// Load restore the last state of an aggregate
+// SetLogger used by the repository and the command handlers
+func (r *Repository) SetLogger(logger Logger) {
+	r.logger = logger
+}
+
+// Logger returns the logger shared with the command handlers
+func (r *Repository) Logger() Logger {
+	return r.logger
+}
+
+// SetAuthorizer used by the command handlers to authorize the commands
+func (r *Repository) SetAuthorizer(authorizer *Authorizer) {
+	r.authorizer = authorizer
+}
+
+// Authorizer returns the authorizer shared with the command handlers, it
+// is nil if the commands are not authorized
+func (r *Repository) Authorizer() *Authorizer {
+	return r.authorizer
+}
+
+// SetRouter replaces DefaultRouter to publish the events
+func (r *Repository) SetRouter(router EventRouter) {
+	r.router = router
+}
+
+// Load restore the last state of an aggregate of the default tenant
 func (r *Repository) Load(aggregate AggregateHandler, ID string) error {
-	events, err := r.eventStore.Load(ID)
+	return r.LoadTenant(aggregate, "", ID)
+}
+
+// LoadTenant restore the last state of an aggregate of a tenant, the
+// events of other tenants are never applied
+func (r *Repository) LoadTenant(aggregate AggregateHandler, tenantID, ID string) error {
+	events, err := LoadTenant(r.eventStore, tenantID, ID)
 
 	if err != nil {
 		return err
@@ -34,12 +71,16 @@ func (r *Repository) Save(aggregate AggregateHandler, version int) error {
 	return r.eventStore.Save(aggregate.Uncommited(), version)
 }
 
-// PublishEvents to an eventBus
+// PublishEvents to an eventBus, the router derives the destination of
+// every event from bucket and subset
 func (r *Repository) PublishEvents(aggregate AggregateHandler, bucket, subset string) error {
-	var err error
-
 	for _, event := range aggregate.Uncommited() {
-		if err = r.eventBus.Publish(event, bucket, subset); err != nil {
+		b, s, err := r.router.Route(event, bucket, subset)
+		if err != nil {
+			return err
+		}
+
+		if err = r.eventBus.Publish(event, b, s); err != nil {
 			return err
 		}
 	}
@@ -47,15 +88,24 @@ func (r *Repository) PublishEvents(aggregate AggregateHandler, bucket, subset st
 	return nil
 }
 
-// PublishError to an eventBus
+// PublishError to an eventBus, bucket and subset are the ones of the
+// aggregate, DefaultRouter publishes the failures to FailureSubset
 func (r *Repository) PublishError(err error, command Command, bucket, subset string) error {
 	event := Event{
 		ID:            GenerateUUID(),
+		TenantID:      TenantOf(command),
 		AggregateID:   command.GetAggregateID(),
 		AggregateType: command.GetAggregateType(),
 		CommandID:     command.GetID(),
 		Version:       command.GetVersion(),
-		Type:          "failure",
+		Type:          FailureEventType,
+	}
+
+	if c, ok := command.(CommandMetadata); ok && len(c.GetMetadata()) > 0 {
+		event.Metadata = make(map[string]string, len(c.GetMetadata()))
+		for key, value := range c.GetMetadata() {
+			event.Metadata[key] = value
+		}
 	}
 
 	if failure, ok := err.(Failure); ok {
@@ -68,6 +118,11 @@ func (r *Repository) PublishError(err error, command Command, bucket, subset str
 		}
 	}
 
+	bucket, subset, routeErr := r.router.Route(event, bucket, subset)
+	if routeErr != nil {
+		return routeErr
+	}
+
 	return r.eventBus.Publish(event, bucket, subset)
 }
 
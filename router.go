@@ -0,0 +1,322 @@
+package triper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FailureEventType is the type of the events published by PublishError
+const FailureEventType = "failure"
+
+// FailureSubset is where the failures are published by default
+const FailureSubset = "errors"
+
+// nolint
+var (
+	ErrInvalidTemplate   = errors.New("invalid route template")
+	ErrInvalidRouteValue = errors.New("invalid value in route")
+)
+
+// routeSeparators split the levels of the nats subjects and the mqtt
+// topics, or are wildcards in them
+const routeSeparators = "./*>+# \t\r\n"
+
+// routeValue checks that a value of the event does not add levels or
+// wildcards to a destination
+func routeValue(name, value string) error {
+	if strings.ContainsAny(value, routeSeparators) {
+		return fmt.Errorf("%w: {%s} is %q", ErrInvalidRouteValue, name, value)
+	}
+
+	return nil
+}
+
+// EventRouter returns the bucket and subset used to publish an event,
+// bucket and subset are the ones wired to the aggregate
+type EventRouter interface {
+	Route(event Event, bucket, subset string) (string, string, error)
+}
+
+// EventRouterFunc adapts a function to EventRouter
+type EventRouterFunc func(event Event, bucket, subset string) (string, string, error)
+
+// Route calls f
+func (f EventRouterFunc) Route(event Event, bucket, subset string) (string, string, error) {
+	return f(event, bucket, subset)
+}
+
+// Route the event with the tenant route, the tenant must be valid
+func (f TenantRoute) Route(event Event, bucket, subset string) (string, string, error) {
+	if err := routeValue("tenant_id", event.TenantID); err != nil {
+		return "", "", err
+	}
+
+	b, s := f(event.TenantID, bucket, subset)
+	return b, s, nil
+}
+
+// DefaultRouter keeps the wired bucket and subset, the failures go to
+// FailureSubset and the tenant prefixes the subset like TenantSubset
+var DefaultRouter EventRouter = EventRouterFunc(func(event Event, bucket, subset string) (string, string, error) {
+	if event.Type == FailureEventType {
+		subset = FailureSubset
+	}
+
+	return TenantRoute(TenantSubset).Route(event, bucket, subset)
+})
+
+// Template builds a destination from the fields of an event, the
+// placeholders are:
+//
+//	{bucket} {subset}    wired to the aggregate
+//	{aggregate_type} {aggregate_id} {event_type} {tenant_id}
+//	{metadata.KEY}       value of KEY in the event metadata
+//
+// The event type is the snake case name given by GetTypeName, the empty
+// segments between dots are removed, so `{tenant_id}.{event_type}` is
+// `deposit_performed` for the default tenant. The values of the event
+// can't contain separators or wildcards like `.`, `/`, `*`, `>`, `+` or
+// `#`, they would move the event to another destination
+type Template struct {
+	source string
+	parts  []templatePart
+}
+
+// templatePart is a literal text or a placeholder
+type templatePart struct {
+	text        string
+	placeholder bool
+}
+
+// ParseTemplate checks the placeholders of source
+func ParseTemplate(source string) (Template, error) {
+	t := Template{source: source}
+
+	for rest := source; rest != ""; {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			start = len(rest)
+		}
+
+		if text := rest[:start]; text != "" {
+			if strings.ContainsRune(text, '}') {
+				return t, fmt.Errorf("%w: %q, unexpected '}'", ErrInvalidTemplate, source)
+			}
+
+			t.parts = append(t.parts, templatePart{text: text})
+		}
+
+		if start == len(rest) {
+			break
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return t, fmt.Errorf("%w: %q, missing '}'", ErrInvalidTemplate, source)
+		}
+
+		name := rest[start+1 : start+end]
+		if !validPlaceholder(name) {
+			return t, fmt.Errorf("%w: %q, unknown placeholder {%s}", ErrInvalidTemplate, source, name)
+		}
+
+		t.parts = append(t.parts, templatePart{text: name, placeholder: true})
+		rest = rest[start+end+1:]
+	}
+
+	return t, nil
+}
+
+func validPlaceholder(name string) bool {
+	switch name {
+	case "bucket", "subset", "aggregate_type", "aggregate_id", "event_type", "tenant_id":
+		return true
+	}
+
+	return strings.HasPrefix(name, "metadata.") && len(name) > len("metadata.")
+}
+
+// String returns the source of the template
+func (t Template) String() string {
+	return t.source
+}
+
+// Execute the template with the fields of event, ErrInvalidRouteValue is
+// returned when a value of the event contains a separator or a wildcard.
+// bucket and subset are wired by the code, so they are not checked
+func (t Template) Execute(event Event, bucket, subset string) (string, error) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if !part.placeholder {
+			b.WriteString(part.text)
+			continue
+		}
+
+		var value string
+		switch part.text {
+		case "bucket":
+			b.WriteString(bucket)
+			continue
+		case "subset":
+			b.WriteString(subset)
+			continue
+		case "aggregate_type":
+			value = event.AggregateType
+		case "aggregate_id":
+			value = event.AggregateID
+		case "event_type":
+			value = event.Type
+		case "tenant_id":
+			value = event.TenantID
+		default:
+			value = event.Metadata[strings.TrimPrefix(part.text, "metadata.")]
+		}
+
+		if err := routeValue(part.text, value); err != nil {
+			return "", err
+		}
+
+		b.WriteString(value)
+	}
+
+	segments := strings.Split(b.String(), ".")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if segment != "" {
+			kept = append(kept, segment)
+		}
+	}
+
+	return strings.Join(kept, "."), nil
+}
+
+// RouteRule sends the events that match EventType and AggregateType to
+// Bucket and Subset, an empty field matches any type or keeps the default
+// template
+type RouteRule struct {
+	// EventType is the name given by GetTypeName, like `account_closed`
+	EventType     string
+	AggregateType string
+	Bucket        string
+	Subset        string
+}
+
+// routeRule is a parsed RouteRule
+type routeRule struct {
+	eventType     string
+	aggregateType string
+	bucket        *Template
+	subset        *Template
+}
+
+func (r routeRule) match(event Event) bool {
+	return (r.eventType == "" || r.eventType == event.Type) &&
+		(r.aggregateType == "" || r.aggregateType == event.AggregateType)
+}
+
+// Router derives the destination of the events from templates, the first
+// rule that matches the event is used, otherwise the default templates:
+//
+//	router := triper.MustRouter("{bucket}", "{aggregate_type}.{event_type}",
+//		triper.RouteRule{EventType: "account_closed", Bucket: "audit"},
+//	)
+//
+// The failures are published to FailureSubset unless a rule matches
+// FailureEventType. The tenant is applied at the end with Tenant
+type Router struct {
+	bucket Template
+	subset Template
+	rules  []routeRule
+	// Tenant routes the tenant of the event, TenantSubset by default, it
+	// can be nil when the templates use {tenant_id}
+	Tenant TenantRoute
+}
+
+// NewRouter parses the default templates and the rules
+func NewRouter(bucket, subset string, rules ...RouteRule) (*Router, error) {
+	var (
+		r   = &Router{Tenant: TenantSubset}
+		err error
+	)
+
+	if r.bucket, err = ParseTemplate(bucket); err != nil {
+		return nil, err
+	}
+
+	if r.subset, err = ParseTemplate(subset); err != nil {
+		return nil, err
+	}
+
+	rules = append(rules, RouteRule{EventType: FailureEventType, Subset: FailureSubset})
+	for _, rr := range rules {
+		parsed := routeRule{eventType: rr.EventType, aggregateType: rr.AggregateType}
+
+		if rr.Bucket != "" {
+			t, err := ParseTemplate(rr.Bucket)
+			if err != nil {
+				return nil, err
+			}
+			parsed.bucket = &t
+		}
+
+		if rr.Subset != "" {
+			t, err := ParseTemplate(rr.Subset)
+			if err != nil {
+				return nil, err
+			}
+			parsed.subset = &t
+		}
+
+		r.rules = append(r.rules, parsed)
+	}
+
+	return r, nil
+}
+
+// MustRouter is like NewRouter but panics if a template is not valid
+func MustRouter(bucket, subset string, rules ...RouteRule) *Router {
+	r, err := NewRouter(bucket, subset, rules...)
+	if err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+// Route returns the destination of event, it fails with
+// ErrInvalidRouteValue when a value of the event can't be routed
+func (r *Router) Route(event Event, bucket, subset string) (string, string, error) {
+	bucketTemplate, subsetTemplate := r.bucket, r.subset
+
+	for _, rule := range r.rules {
+		if !rule.match(event) {
+			continue
+		}
+
+		if rule.bucket != nil {
+			bucketTemplate = *rule.bucket
+		}
+
+		if rule.subset != nil {
+			subsetTemplate = *rule.subset
+		}
+		break
+	}
+
+	b, err := bucketTemplate.Execute(event, bucket, subset)
+	if err != nil {
+		return "", "", err
+	}
+
+	s, err := subsetTemplate.Execute(event, bucket, subset)
+	if err != nil {
+		return "", "", err
+	}
+
+	if r.Tenant != nil {
+		return r.Tenant.Route(event, b, s)
+	}
+
+	return b, s, nil
+}
@@ -0,0 +1,131 @@
+package triper
+
+import (
+	"errors"
+	"testing"
+)
+
+type DepositPerformed struct {
+	Amount int
+}
+
+type AccountClosed struct{}
+
+type LoanGranted struct{}
+
+// reduced returns the event produced by the library for data, so the type
+// is the one given by GetTypeName
+func reduced(aggregateType, tenantID string, data interface{}) Event {
+	aggregate := &MockAggregate{}
+	ReduceHelper(aggregate, Event{AggregateType: aggregateType, Data: data}, true)
+	aggregate.AttachTenantID(tenantID)
+
+	return aggregate.Uncommited()[0]
+}
+
+func TestTemplate(t *testing.T) {
+	tpl, err := ParseTemplate("{tenant_id}.{aggregate_type}.{event_type}.{metadata.region}")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	event := reduced("Account", "", &DepositPerformed{10})
+	event.Metadata = map[string]string{"region": "eu"}
+	if got, err := tpl.Execute(event, "bank", "account"); err != nil || got != "Account.deposit_performed.eu" {
+		t.Error("expected Account.deposit_performed.eu, got", got, err)
+	}
+
+	event.TenantID = "acme"
+	if got, err := tpl.Execute(event, "bank", "account"); err != nil || got != "acme.Account.deposit_performed.eu" {
+		t.Error("expected acme.Account.deposit_performed.eu, got", got, err)
+	}
+
+	// the values can't add levels or wildcards to the destination
+	for _, region := range []string{"a.b", ">", "#", "*", "eu/west", "+"} {
+		event.Metadata["region"] = region
+		if got, err := tpl.Execute(event, "bank", "account"); !errors.Is(err, ErrInvalidRouteValue) {
+			t.Error("expected ErrInvalidRouteValue for", region, "got", got, err)
+		}
+	}
+
+	for _, source := range []string{"{aggregate}", "{bucket", "bucket}", "{metadata.}"} {
+		if _, err := ParseTemplate(source); !errors.Is(err, ErrInvalidTemplate) {
+			t.Error("expected ErrInvalidTemplate for", source, "got", err)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	router := MustRouter("{bucket}", "{aggregate_type}.{event_type}",
+		RouteRule{EventType: "account_closed", Bucket: "audit"},
+		RouteRule{AggregateType: "Loan", Subset: "loans"},
+	)
+
+	cases := []struct {
+		event          Event
+		bucket, subset string
+	}{
+		{reduced("Account", "", &DepositPerformed{10}), "bank", "Account.deposit_performed"},
+		{reduced("Account", "", AccountClosed{}), "audit", "Account.account_closed"},
+		{reduced("Loan", "", &LoanGranted{}), "bank", "loans"},
+		{Event{AggregateType: "Loan", Type: FailureEventType}, "bank", "loans"},
+		{Event{AggregateType: "Account", Type: FailureEventType}, "bank", FailureSubset},
+		{reduced("Account", "acme", &DepositPerformed{10}), "bank", "acme.Account.deposit_performed"},
+	}
+
+	for _, c := range cases {
+		bucket, subset, err := router.Route(c.event, "bank", "account")
+		if err != nil || bucket != c.bucket || subset != c.subset {
+			t.Errorf("expected %s %s for %s, got %s %s", c.bucket, c.subset, c.event.Type, bucket, subset)
+		}
+	}
+
+	// another tenant can't be reached through the aggregate id or the tenant
+	router = MustRouter("{bucket}", "{aggregate_id}.{event_type}")
+	for _, event := range []Event{
+		{AggregateID: "a.b", Type: "account_closed"},
+		{AggregateID: ">", Type: "account_closed"},
+		{AggregateID: "#", Type: "account_closed"},
+		{AggregateID: "a", Type: "account_closed", TenantID: "acme.>"},
+	} {
+		if _, _, err := router.Route(event, "bank", "account"); !errors.Is(err, ErrInvalidRouteValue) {
+			t.Errorf("expected ErrInvalidRouteValue for %+v, got %v", event, err)
+		}
+	}
+
+	if _, _, err := DefaultRouter.Route(Event{TenantID: "#"}, "bank", "account"); !errors.Is(err, ErrInvalidRouteValue) {
+		t.Error("expected ErrInvalidRouteValue from the default router, got", err)
+	}
+
+	if _, err := NewRouter("{bucket}", "{subset}", RouteRule{Subset: "{unknown}"}); !errors.Is(err, ErrInvalidTemplate) {
+		t.Error("expected ErrInvalidTemplate, got", err)
+	}
+}
+
+func TestRepositoryRouter(t *testing.T) {
+	bus := &routeBus{}
+	repository := NewRepository(&plainStore{}, bus)
+	repository.SetRouter(MustRouter("{bucket}", "{subset}.{event_type}"))
+
+	aggregate := &MockAggregate{}
+	ReduceHelper(aggregate, Event{Data: &Opened{}}, true)
+
+	repository.PublishEvents(aggregate, "bank", "account")
+	repository.PublishError(errors.New("boom"), &MockCommand{}, "bank", "account")
+
+	if len(bus.routes) != 2 || bus.routes[0] != "bank/account.opened" || bus.routes[1] != "bank/errors" {
+		t.Error("expected the routes of the router, got", bus.routes)
+	}
+
+	// an event that can't be routed is not published
+	repository.SetRouter(MustRouter("{bucket}", "{metadata.region}"))
+	aggregate.Uncommited()[0].Metadata = map[string]string{"region": "eu.>"}
+
+	if err := repository.PublishEvents(aggregate, "bank", "account"); !errors.Is(err, ErrInvalidRouteValue) {
+		t.Error("expected ErrInvalidRouteValue, got", err)
+	}
+
+	if len(bus.routes) != 2 {
+		t.Error("expected no new routes, got", bus.routes)
+	}
+}
@@ -0,0 +1,119 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/mishudark/triper/scheduler"
+)
+
+// prefix of the keys used by the scheduled commands
+const prefix = "schedule."
+
+// Store keeps the scheduled commands in badger
+type Store struct {
+	session *badger.DB
+}
+
+var _ scheduler.Store = (*Store)(nil)
+
+// NewStore opens a badger db in dbDir to store scheduled commands
+func NewStore(dbDir string) (*Store, error) {
+	options := badger.DefaultOptions(dbDir)
+	options.ValueDir = dbDir
+
+	session, err := badger.Open(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStoreWithDB(session), nil
+}
+
+// NewStoreWithDB uses an already opened badger db, it can be shared with the event store
+func NewStoreWithDB(session *badger.DB) *Store {
+	return &Store{
+		session: session,
+	}
+}
+
+// Close db connection
+func (s *Store) Close() error {
+	return s.session.Close()
+}
+
+// Save creates or replaces a record
+func (s *Store) Save(record scheduler.Record) error {
+	blob, err := encode(record)
+	if err != nil {
+		return err
+	}
+
+	return s.session.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(prefix+record.ID), blob)
+	})
+}
+
+// Delete a record
+func (s *Store) Delete(id string) error {
+	return s.session.Update(func(txn *badger.Txn) error {
+		key := []byte(prefix + id)
+
+		_, err := txn.Get(key)
+		switch err {
+		case nil:
+			return txn.Delete(key)
+		case badger.ErrKeyNotFound:
+			return scheduler.ErrNotFound
+		default:
+			return err
+		}
+	})
+}
+
+// List all the records
+func (s *Store) List() ([]scheduler.Record, error) {
+	var records []scheduler.Record
+
+	err := s.session.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek([]byte(prefix)); it.ValidForPrefix([]byte(prefix)); it.Next() {
+			err := it.Item().Value(func(v []byte) error {
+				var record scheduler.Record
+				if err := decode(v, &record); err != nil {
+					return err
+				}
+
+				records = append(records, record)
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return records, err
+}
+
+func encode(value interface{}) ([]byte, error) {
+	var buff bytes.Buffer
+	en := gob.NewEncoder(&buff)
+
+	err := en.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func decode(data []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
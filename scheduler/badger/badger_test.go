@@ -0,0 +1,54 @@
+package badger
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/scheduler"
+)
+
+func TestStore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStore(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	record := scheduler.Record{
+		ID:          "01",
+		CommandType: "close_account",
+		Payload:     []byte(`{"AggregateID":"123"}`),
+		DueAt:       time.Now(),
+		Principal:   &triper.Principal{ID: "ana", Roles: []string{"teller"}},
+	}
+
+	if err = store.Save(record); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	records, err := store.List()
+	if err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if len(records) != 1 || records[0].CommandType != "close_account" || !records[0].Principal.HasRole("teller") {
+		t.Errorf("expected the saved record, got: %+v", records)
+	}
+
+	if err = store.Delete("01"); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if err = store.Delete("01"); err != scheduler.ErrNotFound {
+		t.Error("expected ErrNotFound, got", err)
+	}
+}
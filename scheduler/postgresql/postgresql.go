@@ -0,0 +1,134 @@
+package postgresql
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	// postgres driver
+	_ "github.com/lib/pq"
+	"github.com/mishudark/triper/scheduler"
+)
+
+// Schema creates the table used to store the scheduled commands
+const Schema = `CREATE TABLE IF NOT EXISTS scheduled_commands (
+	id           TEXT PRIMARY KEY,
+	command_type TEXT NOT NULL,
+	payload      BYTEA NOT NULL,
+	due_at       TIMESTAMPTZ NOT NULL,
+	cron         TEXT NOT NULL DEFAULT '',
+	tenant_id    TEXT NOT NULL DEFAULT '',
+	principal    TEXT NOT NULL DEFAULT ''
+);
+ALTER TABLE scheduled_commands ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE scheduled_commands ADD COLUMN IF NOT EXISTS principal TEXT NOT NULL DEFAULT ''`
+
+// Store keeps the scheduled commands in postgresql
+type Store struct {
+	connector *sql.DB
+}
+
+var _ scheduler.Store = (*Store)(nil)
+
+// NewStore connects to postgresql and creates the table if it does not exist
+func NewStore(psqlInfo string) (*Store, error) {
+	connector, err := sql.Open("postgres", psqlInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	store := NewStoreWithDB(connector)
+	if err = store.Migrate(); err != nil {
+		connector.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// NewStoreWithDB uses an already opened connection
+func NewStoreWithDB(connector *sql.DB) *Store {
+	return &Store{
+		connector: connector,
+	}
+}
+
+// Migrate creates the table if it does not exist
+func (s *Store) Migrate() error {
+	_, err := s.connector.Exec(Schema)
+	return err
+}
+
+// Close db connection
+func (s *Store) Close() error {
+	return s.connector.Close()
+}
+
+// Save creates or replaces a record
+func (s *Store) Save(record scheduler.Record) error {
+	// the principal is kept as json, empty when there is none
+	var principal []byte
+	if record.Principal != nil {
+		var err error
+		if principal, err = json.Marshal(record.Principal); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.connector.Exec(`INSERT INTO scheduled_commands (id, command_type, payload, due_at, cron, tenant_id, principal)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET command_type = $2, payload = $3, due_at = $4, cron = $5, tenant_id = $6, principal = $7`,
+		record.ID, record.CommandType, record.Payload, record.DueAt, record.Cron, record.TenantID, string(principal))
+
+	return err
+}
+
+// Delete a record
+func (s *Store) Delete(id string) error {
+	result, err := s.connector.Exec("DELETE FROM scheduled_commands WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return scheduler.ErrNotFound
+	}
+
+	return nil
+}
+
+// List all the records
+func (s *Store) List() ([]scheduler.Record, error) {
+	rows, err := s.connector.Query("SELECT id, command_type, payload, due_at, cron, tenant_id, principal FROM scheduled_commands ORDER BY due_at")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var records []scheduler.Record
+	for rows.Next() {
+		var (
+			record    scheduler.Record
+			principal string
+		)
+
+		if err = rows.Scan(&record.ID, &record.CommandType, &record.Payload, &record.DueAt, &record.Cron, &record.TenantID, &principal); err != nil {
+			return nil, err
+		}
+
+		if principal != "" {
+			if err = json.Unmarshal([]byte(principal), &record.Principal); err != nil {
+				return nil, err
+			}
+		}
+
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
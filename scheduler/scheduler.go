@@ -0,0 +1,287 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// DefaultInterval is how often the scheduler looks for due commands
+const DefaultInterval = time.Second
+
+// ErrNotFound is returned when a schedule does not exist in the store
+var ErrNotFound = errors.New("scheduler: schedule not found")
+
+// Record is a scheduled command as it is persisted by a Store
+type Record struct {
+	ID          string
+	CommandType string
+	// TenantID and Principal of the command, they are not part of the
+	// payload
+	TenantID  string
+	Principal *triper.Principal
+	Payload   []byte
+	DueAt     time.Time
+	Cron      string
+}
+
+// Store persists the scheduled commands, so they survive restarts
+type Store interface {
+	// Save creates or replaces a record
+	Save(record Record) error
+	// Delete a record, ErrNotFound is returned if it does not exist
+	Delete(id string) error
+	List() ([]Record, error)
+}
+
+// Schedule is a command waiting to be dispatched
+type Schedule struct {
+	ID      string
+	Command triper.Command
+	DueAt   time.Time
+	// Cron is empty for commands that are dispatched only once
+	Cron string
+}
+
+// Scheduler dispatches commands to a command bus when they are due
+type Scheduler struct {
+	mu     sync.Mutex
+	store  Store
+	bus    triper.CommandBus
+	reg    triper.Register
+	logger triper.Logger
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewScheduler returns a scheduler that persists the commands in store,
+// reg must contain the command types to decode them, like a
+// triper.CommandTypeRegister populated with config.CommandTypes
+func NewScheduler(store Store, bus triper.CommandBus, reg triper.Register) *Scheduler {
+	return &Scheduler{
+		store:  store,
+		bus:    bus,
+		reg:    reg,
+		logger: triper.NopLogger{},
+	}
+}
+
+// SetLogger used by the scheduler, it must be called before Start
+func (s *Scheduler) SetLogger(logger triper.Logger) {
+	s.logger = logger
+}
+
+// At schedules a command to be dispatched at a given time
+func (s *Scheduler) At(command triper.Command, due time.Time) (id string, err error) {
+	return s.save(command, due, "")
+}
+
+// After schedules a command to be dispatched after a delay
+func (s *Scheduler) After(command triper.Command, delay time.Duration) (id string, err error) {
+	return s.save(command, time.Now().Add(delay), "")
+}
+
+// Cron schedules a command to be dispatched every time the cron expression matches
+func (s *Scheduler) Cron(command triper.Command, expr string) (id string, err error) {
+	cron, err := ParseCron(expr)
+	if err != nil {
+		return "", err
+	}
+
+	due := cron.Next(time.Now())
+	if due.IsZero() {
+		return "", fmt.Errorf("scheduler: cron expression %q never matches", expr)
+	}
+
+	return s.save(command, due, expr)
+}
+
+func (s *Scheduler) save(command triper.Command, due time.Time, cron string) (string, error) {
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return "", err
+	}
+
+	_, commandType := triper.GetTypeName(command)
+	record := Record{
+		ID:          triper.GenerateUUID(),
+		CommandType: commandType,
+		TenantID:    triper.TenantOf(command),
+		Principal:   triper.PrincipalOf(command),
+		Payload:     payload,
+		DueAt:       due,
+		Cron:        cron,
+	}
+
+	if err = s.store.Save(record); err != nil {
+		return "", err
+	}
+
+	return record.ID, nil
+}
+
+// List the scheduled commands sorted by due time
+func (s *Scheduler) List() ([]Schedule, error) {
+	records, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]Schedule, len(records))
+	for i, record := range records {
+		command, err := s.decode(record)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules[i] = Schedule{
+			ID:      record.ID,
+			Command: command,
+			DueAt:   record.DueAt,
+			Cron:    record.Cron,
+		}
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].DueAt.Before(schedules[j].DueAt)
+	})
+
+	return schedules, nil
+}
+
+// Cancel a scheduled command
+func (s *Scheduler) Cancel(id string) error {
+	return s.store.Delete(id)
+}
+
+// Run dispatches the commands due at now. One-time commands are removed
+// after being dispatched and cron commands are rescheduled, a crash
+// between both steps can dispatch a command twice
+func (s *Scheduler) Run(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.store.List()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].DueAt.Before(records[j].DueAt)
+	})
+
+	// a broken record should not block the rest, the first error is returned
+	var first error
+	for _, record := range records {
+		if record.DueAt.After(now) {
+			break
+		}
+
+		if err = s.dispatch(record, now); err != nil {
+			s.logger.Error("scheduled command not dispatched", "schedule_id", record.ID, triper.LogCommandType, record.CommandType, triper.LogError, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	return first
+}
+
+func (s *Scheduler) dispatch(record Record, now time.Time) error {
+	command, err := s.decode(record)
+	if err != nil {
+		return err
+	}
+
+	// the bus returns an empty id when it can't accept the command,
+	// keep it to try again in the next run
+	if id := s.bus.HandleCommand(command); id == "" {
+		return fmt.Errorf("scheduler: %s, command %s rejected by the bus", record.ID, record.CommandType)
+	}
+
+	if record.Cron == "" {
+		return s.store.Delete(record.ID)
+	}
+
+	cron, err := ParseCron(record.Cron)
+	if err != nil {
+		return err
+	}
+
+	record.DueAt = cron.Next(now)
+	if record.DueAt.IsZero() {
+		return s.store.Delete(record.ID)
+	}
+
+	return s.store.Save(record)
+}
+
+// decode creates a new command from a record
+func (s *Scheduler) decode(record Record) (triper.Command, error) {
+	value, err := s.reg.Get(record.CommandType)
+	if err != nil {
+		return nil, err
+	}
+
+	command, ok := value.(triper.Command)
+	if !ok {
+		return nil, fmt.Errorf("scheduler: %s does not implement triper.Command", record.CommandType)
+	}
+
+	if err = json.Unmarshal(record.Payload, command); err != nil {
+		return nil, err
+	}
+
+	if c, ok := command.(triper.CommandTenant); ok {
+		c.SetTenantID(record.TenantID)
+	}
+
+	if c, ok := command.(triper.CommandPrincipal); ok {
+		c.SetPrincipal(record.Principal)
+	}
+
+	return command, nil
+}
+
+// Start looking for due commands every interval until Stop is called
+func (s *Scheduler) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				s.Run(now)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the scheduler, it waits for the current run to finish
+func (s *Scheduler) Stop() {
+	if s.stop == nil {
+		return
+	}
+
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
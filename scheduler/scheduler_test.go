@@ -0,0 +1,223 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+type ExpireReservation struct {
+	triper.BaseCommand
+	Reason string
+}
+
+type storeStub struct {
+	records map[string]Record
+}
+
+func (s *storeStub) Save(record Record) error {
+	s.records[record.ID] = record
+	return nil
+}
+
+func (s *storeStub) Delete(id string) error {
+	if _, ok := s.records[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(s.records, id)
+	return nil
+}
+
+func (s *storeStub) List() ([]Record, error) {
+	var records []Record
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+type busStub struct {
+	commands []triper.Command
+}
+
+func (b *busStub) HandleCommand(command triper.Command) string {
+	command.GenerateUUID()
+	b.commands = append(b.commands, command)
+	return command.GetID()
+}
+
+func newScheduler() (*Scheduler, *storeStub, *busStub) {
+	reg := triper.NewCommandTypeRegister()
+	reg.Set(&ExpireReservation{})
+
+	store := &storeStub{records: make(map[string]Record)}
+	bus := &busStub{}
+	return NewScheduler(store, bus, reg), store, bus
+}
+
+func TestSchedulerAt(t *testing.T) {
+	sched, store, bus := newScheduler()
+	now := time.Now()
+
+	command := &ExpireReservation{Reason: "timeout"}
+	command.AggregateID = "reservation-1"
+	command.SetTenantID("acme")
+
+	if _, err := sched.At(command, now.Add(time.Minute)); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if err := sched.Run(now); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if len(bus.commands) != 0 {
+		t.Error("expected 0 commands before due, got", len(bus.commands))
+	}
+
+	if err := sched.Run(now.Add(time.Minute)); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if len(bus.commands) != 1 {
+		t.Fatal("expected 1 command, got", len(bus.commands))
+	}
+
+	dispatched, ok := bus.commands[0].(*ExpireReservation)
+	if !ok || dispatched.Reason != "timeout" || dispatched.AggregateID != "reservation-1" || dispatched.TenantID != "acme" {
+		t.Errorf("unexpected command: %+v", bus.commands[0])
+	}
+
+	if len(store.records) != 0 {
+		t.Error("expected the schedule to be removed, got", len(store.records))
+	}
+}
+
+func TestSchedulerCronAndCancel(t *testing.T) {
+	sched, store, bus := newScheduler()
+
+	id, err := sched.Cron(&ExpireReservation{}, "@hourly")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	schedules, err := sched.List()
+	if err != nil || len(schedules) != 1 {
+		t.Fatal("expected 1 schedule, got", len(schedules), err)
+	}
+
+	due := schedules[0].DueAt
+	if err = sched.Run(due); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if len(bus.commands) != 1 {
+		t.Error("expected 1 command, got", len(bus.commands))
+	}
+
+	if next := store.records[id].DueAt; !next.Equal(due.Add(time.Hour)) {
+		t.Errorf("expected next run at %s, got %s", due.Add(time.Hour), next)
+	}
+
+	if err = sched.Cancel(id); err != nil {
+		t.Error("expected nil, got", err)
+	}
+
+	if err = sched.Cancel(id); err != ErrNotFound {
+		t.Error("expected ErrNotFound, got", err)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2020, time.January, 31, 10, 7, 0, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"*/15 * * * *", time.Date(2020, time.January, 31, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2020, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 8 29 2 *", time.Date(2020, time.February, 29, 8, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2020, time.February, 2, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("[%s] expected nil, got %s", c.expr, err)
+			continue
+		}
+
+		if next := cron.Next(from); !next.Equal(c.next) {
+			t.Errorf("[%s] expected: %s, got: %s", c.expr, c.next, next)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "a * * * *", "*/0 * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("[%s] expected error, got nil", expr)
+		}
+	}
+}
+
+// authorizingBus rejects the commands denied by the authorizer, like the
+// basic command handler
+type authorizingBus struct {
+	busStub
+	authorizer *triper.Authorizer
+	denied     []error
+}
+
+func (b *authorizingBus) HandleCommand(command triper.Command) string {
+	if err := b.authorizer.Authorize(command, nil); err != nil {
+		b.denied = append(b.denied, err)
+		return ""
+	}
+
+	return b.busStub.HandleCommand(command)
+}
+
+func TestSchedulerPrincipal(t *testing.T) {
+	reg := triper.NewCommandTypeRegister()
+	reg.Set(&ExpireReservation{})
+
+	authorizer := triper.NewAuthorizer(nil)
+	authorizer.DenyUnlisted = true
+	authorizer.Add(&ExpireReservation{}, triper.RequireRoles("scheduler"))
+
+	bus := &authorizingBus{authorizer: authorizer}
+	sched := NewScheduler(&storeStub{records: make(map[string]Record)}, bus, reg)
+	now := time.Now()
+
+	allowed := &ExpireReservation{Reason: "timeout"}
+	allowed.SetPrincipal(&triper.Principal{ID: "ops", Roles: []string{"scheduler"}})
+
+	denied := &ExpireReservation{Reason: "anonymous"}
+
+	if _, err := sched.At(allowed, now); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	if _, err := sched.At(denied, now); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	// the command without principal is denied and kept for the next run
+	if err := sched.Run(now); err == nil {
+		t.Error("expected the denied command error, got nil")
+	}
+
+	if len(bus.commands) != 1 || len(bus.denied) != 1 {
+		t.Fatal("expected 1 allowed and 1 denied command, got", len(bus.commands), len(bus.denied))
+	}
+
+	dispatched := bus.commands[0].(*ExpireReservation)
+	if dispatched.Reason != "timeout" || dispatched.GetPrincipal().ID != "ops" {
+		t.Errorf("unexpected command: %+v", dispatched)
+	}
+}
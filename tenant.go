@@ -0,0 +1,104 @@
+package triper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// nolint
+var (
+	ErrTenantMismatch      = errors.New("event belongs to another tenant")
+	ErrTenantsNotSupported = errors.New("event store does not support tenants")
+	ErrInvalidTenantID     = errors.New("tenant id can't contain separators or wildcards")
+)
+
+// CommandTenant is implemented by the commands that belong to a tenant,
+// the events produced by the command belong to the same tenant
+type CommandTenant interface {
+	GetTenantID() string
+	SetTenantID(id string)
+}
+
+// TenantOf returns the tenant of a command, it is empty if the command
+// does not belong to a tenant
+func TenantOf(command Command) string {
+	if c, ok := command.(CommandTenant); ok {
+		return c.GetTenantID()
+	}
+
+	return ""
+}
+
+// ValidateTenantID checks that id can be used in the keys and routes
+func ValidateTenantID(id string) error {
+	if strings.ContainsAny(id, routeSeparators) {
+		return fmt.Errorf("%w: %q", ErrInvalidTenantID, id)
+	}
+
+	return nil
+}
+
+// StreamKey identifies the events of an aggregate across the tenants, it
+// is the aggregate id prefixed by the tenant like `acme/0000XSNJG0`, the
+// default tenant has no prefix
+func StreamKey(tenantID, aggregateID string) string {
+	if tenantID == "" {
+		return aggregateID
+	}
+
+	return tenantID + "/" + aggregateID
+}
+
+// TenantEventStore is implemented by the event stores that partition the
+// events by tenant, Save uses the tenant of the events
+type TenantEventStore interface {
+	EventStore
+	LoadTenant(tenantID, aggregateID string) ([]Event, error)
+}
+
+// LoadTenant loads the events of an aggregate that belongs to a tenant, an
+// empty tenant is the default one. It never returns events of another
+// tenant, the stores without tenants can only load the default tenant
+func LoadTenant(store EventStore, tenantID, aggregateID string) ([]Event, error) {
+	var (
+		events []Event
+		err    error
+	)
+
+	switch s := store.(type) {
+	case TenantEventStore:
+		events, err = s.LoadTenant(tenantID, aggregateID)
+	default:
+		if tenantID != "" {
+			return nil, ErrTenantsNotSupported
+		}
+
+		events, err = store.Load(aggregateID)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		if event.TenantID != tenantID {
+			return nil, fmt.Errorf("%w: %s, wanted: %q, got: %q", ErrTenantMismatch, aggregateID, tenantID, event.TenantID)
+		}
+	}
+
+	return events, nil
+}
+
+// TenantRoute returns the bucket and subset used to publish an event
+type TenantRoute func(tenantID, bucket, subset string) (string, string)
+
+// TenantSubset is the default route, the tenant prefixes the subset so the
+// events of all the tenants share the bucket, like `bank` and `acme.account`
+func TenantSubset(tenantID, bucket, subset string) (string, string) {
+	if tenantID == "" {
+		return bucket, subset
+	}
+
+	return bucket, tenantID + "." + subset
+}
@@ -0,0 +1,100 @@
+package triper
+
+import (
+	"errors"
+	"testing"
+)
+
+type plainStore struct {
+	events []Event
+}
+
+func (s *plainStore) Save(events []Event, version int) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *plainStore) SafeSave(events []Event, version int) error {
+	return s.Save(events, version)
+}
+
+func (s *plainStore) Load(aggregateID string) ([]Event, error) {
+	return s.events, nil
+}
+
+type tenantStore struct {
+	plainStore
+}
+
+func (s *tenantStore) LoadTenant(tenantID, aggregateID string) ([]Event, error) {
+	return s.events, nil
+}
+
+type routeBus struct {
+	routes []string
+}
+
+func (b *routeBus) Publish(event Event, bucket, subset string) error {
+	b.routes = append(b.routes, bucket+"/"+subset)
+	return nil
+}
+
+func TestLoadTenant(t *testing.T) {
+	plain := &plainStore{events: []Event{{AggregateID: "a"}}}
+	if _, err := LoadTenant(plain, "acme", "a"); err != ErrTenantsNotSupported {
+		t.Error("expected ErrTenantsNotSupported, got", err)
+	}
+
+	if events, err := LoadTenant(plain, "", "a"); err != nil || len(events) != 1 {
+		t.Error("expected 1 event of the default tenant, got", events, err)
+	}
+
+	store := &tenantStore{plainStore{events: []Event{{AggregateID: "a", TenantID: "acme"}}}}
+	if events, err := LoadTenant(store, "acme", "a"); err != nil || len(events) != 1 {
+		t.Error("expected 1 event of acme, got", events, err)
+	}
+
+	// a broken store must not leak the events of another tenant
+	if _, err := LoadTenant(store, "globex", "a"); !errors.Is(err, ErrTenantMismatch) {
+		t.Error("expected ErrTenantMismatch, got", err)
+	}
+
+	repository := NewRepository(store, &routeBus{})
+	if err := repository.Load(&MockAggregate{}, "a"); !errors.Is(err, ErrTenantMismatch) {
+		t.Error("expected the repository to refuse the events of acme, got", err)
+	}
+}
+
+func TestTenantRoute(t *testing.T) {
+	bus := &routeBus{}
+	repository := NewRepository(&tenantStore{}, bus)
+
+	aggregate := &MockAggregate{}
+	aggregate.AddEvent(Event{AggregateID: "a", Data: 1})
+	aggregate.AttachTenantID("acme")
+
+	command := &MockCommand{}
+	command.SetTenantID("acme")
+
+	repository.PublishEvents(aggregate, "bank", "account")
+	repository.PublishError(errors.New("boom"), command, "bank", "errors")
+
+	if len(bus.routes) != 2 || bus.routes[0] != "bank/acme.account" || bus.routes[1] != "bank/acme.errors" {
+		t.Error("expected the tenant in the subsets, got", bus.routes)
+	}
+}
+
+func TestTenantID(t *testing.T) {
+	if StreamKey("", "a") != "a" || StreamKey("acme", "a") != "acme/a" {
+		t.Error("expected the tenant to prefix the key, got", StreamKey("acme", "a"))
+	}
+
+	command := &MockCommand{}
+	command.AggregateID = "a"
+	command.SetTenantID("acme.corp")
+
+	errs, ok := ValidateCommand(command).(ValidationError)
+	if !ok || len(errs) != 1 || errs[0].Field != "TenantID" {
+		t.Error("expected a TenantID field error, got", errs)
+	}
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import "sync"
+
+// Exporter receives the spans when they end
+type Exporter interface {
+	ExportSpan(span SpanData)
+}
+
+// NoopExporter discards the spans
+type NoopExporter struct{}
+
+// ExportSpan does nothing
+func (NoopExporter) ExportSpan(span SpanData) {}
+
+// InMemoryExporter keeps the spans in memory, it is useful in tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter returns an empty exporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// ExportSpan stores the span
+func (e *InMemoryExporter) ExportSpan(span SpanData) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans returns the ended spans in the order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset removes all the spans
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// Find returns the first span with name
+func (e *InMemoryExporter) Find(name string) (SpanData, bool) {
+	for _, span := range e.Spans() {
+		if span.Name == name {
+			return span, true
+		}
+	}
+
+	return SpanData{}, false
+}
+
+// Children returns the spans whose parent is span
+func (e *InMemoryExporter) Children(span SpanData) []SpanData {
+	var children []SpanData
+	for _, s := range e.Spans() {
+		if s.TraceID == span.TraceID && s.ParentSpanID == span.SpanID {
+			children = append(children, s)
+		}
+	}
+
+	return children
+}
+
+// Tree returns the names of the spans as an indented tree, every root
+// span starts a new tree
+func (e *InMemoryExporter) Tree() string {
+	var (
+		out   string
+		write func(span SpanData, depth int)
+	)
+
+	write = func(span SpanData, depth int) {
+		for i := 0; i < depth; i++ {
+			out += "  "
+		}
+
+		out += span.Name + "\n"
+		for _, child := range e.Children(span) {
+			write(child, depth+1)
+		}
+	}
+
+	for _, span := range e.Spans() {
+		if span.ParentSpanID == "" {
+			write(span, 0)
+		}
+	}
+
+	return out
+}
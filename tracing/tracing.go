@@ -0,0 +1,262 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mishudark/triper"
+)
+
+// TraceParentKey is the header and metadata key used to propagate the
+// trace context, it follows the W3C Trace Context format used by OpenTelemetry
+const TraceParentKey = "traceparent"
+
+// SpanContext identifies a span inside a trace
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// IsValid returns true if the context belongs to a trace
+func (c SpanContext) IsValid() bool {
+	return len(c.TraceID) == 32 && len(c.SpanID) == 16
+}
+
+// TraceParent returns the context in the W3C traceparent format
+func (c SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", c.TraceID, c.SpanID)
+}
+
+// ParseTraceParent reads a context in the W3C traceparent format
+func ParseTraceParent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 {
+		return SpanContext{}, false
+	}
+
+	c := SpanContext{
+		TraceID: strings.ToLower(parts[1]),
+		SpanID:  strings.ToLower(parts[2]),
+	}
+
+	if !c.IsValid() || !isHex(c.TraceID) || !isHex(c.SpanID) {
+		return SpanContext{}, false
+	}
+
+	return c, true
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// SpanData is the info recorded by a span, it is sent to the exporter when the span ends
+type SpanData struct {
+	Name         string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]string
+	Err          error
+}
+
+// Span is an operation inside a trace
+type Span struct {
+	mu     sync.Mutex
+	tracer *Tracer
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span context to be propagated
+func (s *Span) Context() SpanContext {
+	return SpanContext{
+		TraceID: s.data.TraceID,
+		SpanID:  s.data.SpanID,
+	}
+}
+
+// SetAttribute adds a key/value to the span
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	s.data.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	s.mu.Lock()
+	s.data.Err = err
+	s.mu.Unlock()
+}
+
+// End the span and send it to the exporter, only the first call has effect
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.exporter.ExportSpan(data)
+}
+
+// Tracer creates spans and sends them to an exporter
+type Tracer struct {
+	exporter Exporter
+
+	mu sync.Mutex
+	// active keeps the spans handling a command by stream key and command
+	// id, the event store Load only receives the tenant and aggregate id
+	active map[string]map[string]SpanContext
+}
+
+// NewTracer returns a tracer that sends the spans to exporter
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{
+		exporter: exporter,
+		active:   make(map[string]map[string]SpanContext),
+	}
+}
+
+// Start a new span, if parent is not valid a new trace is created
+func (t *Tracer) Start(name string, parent SpanContext) *Span {
+	data := SpanData{
+		Name:       name,
+		SpanID:     randomHex(8),
+		StartTime:  time.Now(),
+		Attributes: make(map[string]string),
+	}
+
+	if parent.IsValid() {
+		data.TraceID = parent.TraceID
+		data.ParentSpanID = parent.SpanID
+	} else {
+		data.TraceID = randomHex(16)
+	}
+
+	return &Span{
+		tracer: t,
+		data:   data,
+	}
+}
+
+func (t *Tracer) setActive(streamKey, commandID string, c SpanContext) {
+	t.mu.Lock()
+	if t.active[streamKey] == nil {
+		t.active[streamKey] = make(map[string]SpanContext)
+	}
+
+	t.active[streamKey][commandID] = c
+	t.mu.Unlock()
+}
+
+func (t *Tracer) clearActive(streamKey, commandID string) {
+	t.mu.Lock()
+	delete(t.active[streamKey], commandID)
+	if len(t.active[streamKey]) == 0 {
+		delete(t.active, streamKey)
+	}
+	t.mu.Unlock()
+}
+
+// getActive returns the span of the command handling the stream, it is
+// empty when many commands handle it at the same time, a load can't tell
+// which one it belongs to
+func (t *Tracer) getActive(streamKey string) SpanContext {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.active[streamKey]) != 1 {
+		return SpanContext{}
+	}
+
+	for _, c := range t.active[streamKey] {
+		return c
+	}
+
+	return SpanContext{}
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// FromMetadata returns the span context stored in metadata
+func FromMetadata(metadata map[string]string) SpanContext {
+	c, _ := ParseTraceParent(metadata[TraceParentKey])
+	return c
+}
+
+// FromCommand returns the span context carried by a command
+func FromCommand(command triper.Command) SpanContext {
+	if c, ok := command.(triper.CommandMetadata); ok {
+		return FromMetadata(c.GetMetadata())
+	}
+
+	return SpanContext{}
+}
+
+// InjectCommand stores the span context in the command metadata, so
+// it is propagated to the events
+func InjectCommand(command triper.Command, c SpanContext) {
+	if carrier, ok := command.(triper.CommandMetadata); ok && c.IsValid() {
+		carrier.SetMetadata(TraceParentKey, c.TraceParent())
+	}
+}
+
+// FromEvent returns the span context carried by an event
+func FromEvent(event triper.Event) SpanContext {
+	return FromMetadata(event.Metadata)
+}
+
+// InjectEvent returns a copy of the event with the span context in its metadata
+func InjectEvent(event triper.Event, c SpanContext) triper.Event {
+	metadata := make(map[string]string, len(event.Metadata)+1)
+	for key, value := range event.Metadata {
+		metadata[key] = value
+	}
+
+	metadata[TraceParentKey] = c.TraceParent()
+	event.Metadata = metadata
+	return event
+}
+
+// FromHTTP returns the span context sent in the request headers
+func FromHTTP(header http.Header) SpanContext {
+	c, _ := ParseTraceParent(header.Get(TraceParentKey))
+	return c
+}
+
+// InjectHTTP sets the span context in the request headers
+func InjectHTTP(header http.Header, c SpanContext) {
+	header.Set(TraceParentKey, c.TraceParent())
+}
+
+// StartConsumer starts a span for an event received from a broker, as
+// child of the span that published it
+func (t *Tracer) StartConsumer(name string, event triper.Event) *Span {
+	span := t.Start(name, FromEvent(event))
+	span.SetAttribute("event.id", event.ID)
+	span.SetAttribute("event.type", event.Type)
+	span.SetAttribute("aggregate.id", event.AggregateID)
+	return span
+}
@@ -0,0 +1,228 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/commandhandler/basic"
+)
+
+type CreateAccount struct {
+	triper.BaseCommand
+}
+
+type PerformDeposit struct {
+	triper.BaseCommand
+}
+
+type AccountCreated struct{}
+
+type DepositPerformed struct{}
+
+type Account struct {
+	triper.BaseAggregate
+}
+
+func (a *Account) Reduce(event triper.Event) error {
+	switch event.Data.(type) {
+	case *AccountCreated:
+		a.ID = event.AggregateID
+	case *DepositPerformed:
+	default:
+		return errors.New("undefined event")
+	}
+
+	return nil
+}
+
+func (a *Account) HandleCommand(command triper.Command) error {
+	event := triper.Event{
+		AggregateID:   command.GetAggregateID(),
+		AggregateType: "account",
+	}
+
+	switch command.(type) {
+	case *CreateAccount:
+		event.Data = &AccountCreated{}
+	case *PerformDeposit:
+		event.Data = &DepositPerformed{}
+	}
+
+	triper.ReduceHelper(a, event, true)
+	return nil
+}
+
+type storeStub struct {
+	events map[string][]triper.Event
+}
+
+func (s *storeStub) Save(events []triper.Event, version int) error {
+	for _, event := range events {
+		s.events[event.AggregateID] = append(s.events[event.AggregateID], event)
+	}
+
+	return nil
+}
+
+func (s *storeStub) SafeSave(events []triper.Event, version int) error {
+	return s.Save(events, version)
+}
+
+func (s *storeStub) Load(aggregateID string) ([]triper.Event, error) {
+	return s.events[aggregateID], nil
+}
+
+type busStub struct {
+	events []triper.Event
+}
+
+func (b *busStub) Publish(event triper.Event, bucket, subset string) error {
+	b.events = append(b.events, event)
+	return nil
+}
+
+// syncBus handles the commands in the caller goroutine
+type syncBus struct {
+	handler triper.CommandHandler
+}
+
+func (b *syncBus) HandleCommand(command triper.Command) string {
+	command.GenerateUUID()
+	b.handler.Handle(command)
+	return command.GetID()
+}
+
+func TestTraceFromHTTPToConsumer(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	broker := &busStub{}
+	repository := triper.NewRepository(
+		tracer.EventStore(&storeStub{events: make(map[string][]triper.Event)}),
+		tracer.EventBus(broker, "stub"),
+	)
+
+	handler := tracer.HandlerConstructor(basic.NewCommandHandler)(repository, &Account{}, "bank", "account")
+	bus := tracer.CommandBus(&syncBus{handler})
+
+	create := &CreateAccount{}
+	create.AggregateID = "account-1"
+	bus.HandleCommand(create)
+	exporter.Reset()
+
+	// the request comes with a trace context from an http client
+	header := http.Header{}
+	InjectHTTP(header, SpanContext{
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:  "00f067aa0ba902b7",
+	})
+
+	request := tracer.Start("http.request", FromHTTP(header))
+	deposit := &PerformDeposit{}
+	deposit.AggregateID = "account-1"
+	deposit.Version = 1
+	InjectCommand(deposit, request.Context())
+	bus.HandleCommand(deposit)
+	request.End()
+
+	if len(broker.events) != 2 {
+		t.Fatal("expected 2 published events, got", len(broker.events))
+	}
+
+	consumer := tracer.StartConsumer("consumer.receive", broker.events[1])
+	consumer.End()
+
+	for _, span := range exporter.Spans() {
+		if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("[%s] expected the trace from the request, got: %s", span.Name, span.TraceID)
+		}
+	}
+
+	root, ok := exporter.Find("http.request")
+	if !ok {
+		t.Fatal("expected http.request span")
+	}
+
+	expected := map[string]string{
+		"commandbus.handle_command": "http.request",
+		"commandhandler.handle":     "commandbus.handle_command",
+		"eventstore.load":           "commandhandler.handle",
+		"eventstore.save":           "commandhandler.handle",
+		"eventbus.publish":          "commandhandler.handle",
+		"consumer.receive":          "eventbus.publish",
+	}
+
+	names := map[string]string{root.SpanID: root.Name}
+	for _, span := range exporter.Spans() {
+		names[span.SpanID] = span.Name
+	}
+
+	for name, parent := range expected {
+		span, ok := exporter.Find(name)
+		if !ok {
+			t.Errorf("expected span %s", name)
+			continue
+		}
+
+		if names[span.ParentSpanID] != parent {
+			t.Errorf("[%s] expected parent: %s, got: %s", name, parent, names[span.ParentSpanID])
+		}
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	c, ok := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("expected a valid context")
+	}
+
+	if c.TraceParent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Error("unexpected traceparent", c.TraceParent())
+	}
+
+	for _, value := range []string{"", "00-xyz-00f067aa0ba902b7-01", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"} {
+		if _, ok = ParseTraceParent(value); ok {
+			t.Errorf("[%s] expected invalid context", value)
+		}
+	}
+}
+
+func TestActiveByStream(t *testing.T) {
+	tracer := NewTracer(NewInMemoryExporter())
+
+	acme := tracer.Start("acme", SpanContext{}).Context()
+	first := tracer.Start("first", SpanContext{}).Context()
+	second := tracer.Start("second", SpanContext{}).Context()
+
+	// the same aggregate id in another tenant is another stream
+	tracer.setActive(triper.StreamKey("acme", "a"), "c1", acme)
+	tracer.setActive(triper.StreamKey("", "a"), "c2", first)
+
+	if c := tracer.getActive(triper.StreamKey("acme", "a")); c != acme {
+		t.Error("expected the span of acme, got", c)
+	}
+
+	if c := tracer.getActive(triper.StreamKey("", "a")); c != first {
+		t.Error("expected the span of the default tenant, got", c)
+	}
+
+	// two commands of the same stream can't be told apart
+	tracer.setActive(triper.StreamKey("", "a"), "c3", second)
+	if c := tracer.getActive(triper.StreamKey("", "a")); c.IsValid() {
+		t.Error("expected no span with two commands, got", c)
+	}
+
+	// a command that ends keeps the span of the other one
+	tracer.clearActive(triper.StreamKey("", "a"), "c3")
+	if c := tracer.getActive(triper.StreamKey("", "a")); c != first {
+		t.Error("expected the span of the first command, got", c)
+	}
+
+	tracer.clearActive(triper.StreamKey("", "a"), "c2")
+	tracer.clearActive(triper.StreamKey("acme", "a"), "c1")
+	if len(tracer.active) != 0 {
+		t.Error("expected no active spans, got", tracer.active)
+	}
+}
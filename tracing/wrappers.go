@@ -0,0 +1,215 @@
+package tracing
+
+import "github.com/mishudark/triper"
+
+// CommandBus wraps a command bus to trace the commands it receives, the
+// command carries the span context to the handler. The wrapper implements
+// triper.CommandDispatcher only if bus does it
+func (t *Tracer) CommandBus(bus triper.CommandBus) triper.CommandBus {
+	if _, ok := bus.(triper.CommandDispatcher); ok {
+		return &commandDispatcher{commandBus{bus, t}}
+	}
+
+	return &commandBus{bus, t}
+}
+
+type commandBus struct {
+	next   triper.CommandBus
+	tracer *Tracer
+}
+
+type commandDispatcher struct {
+	commandBus
+}
+
+func (b *commandBus) start(command triper.Command) *Span {
+	span := b.tracer.Start("commandbus.handle_command", FromCommand(command))
+
+	_, name := triper.GetTypeName(command)
+	span.SetAttribute("command.type", name)
+	span.SetAttribute("aggregate.id", command.GetAggregateID())
+
+	InjectCommand(command, span.Context())
+	return span
+}
+
+func (b *commandBus) HandleCommand(command triper.Command) string {
+	span := b.start(command)
+	defer span.End()
+
+	id := b.next.HandleCommand(command)
+	span.SetAttribute("command.id", id)
+	return id
+}
+
+func (b *commandDispatcher) Dispatch(command triper.Command) (id string, err error) {
+	span := b.start(command)
+	defer span.End()
+
+	id, err = b.next.(triper.CommandDispatcher).Dispatch(command)
+	span.SetAttribute("command.id", id)
+	if err != nil {
+		span.SetError(err)
+	}
+
+	return id, err
+}
+
+// CommandHandler wraps a command handler to trace how it handles the
+// commands, events produced by basic.Handler inherit the span context
+func (t *Tracer) CommandHandler(handler triper.CommandHandler) triper.CommandHandler {
+	return &commandHandler{handler, t}
+}
+
+// HandlerConstructor wraps a command handler constructor like
+// basic.NewCommandHandler, so it can be used with config.WireCommands
+func (t *Tracer) HandlerConstructor(
+	next func(*triper.Repository, triper.AggregateHandler, string, string) triper.CommandHandler,
+) func(*triper.Repository, triper.AggregateHandler, string, string) triper.CommandHandler {
+	return func(repository *triper.Repository, aggregate triper.AggregateHandler, bucket, subset string) triper.CommandHandler {
+		return t.CommandHandler(next(repository, aggregate, bucket, subset))
+	}
+}
+
+type commandHandler struct {
+	next   triper.CommandHandler
+	tracer *Tracer
+}
+
+func (h *commandHandler) Handle(command triper.Command) error {
+	span := h.tracer.Start("commandhandler.handle", FromCommand(command))
+	defer span.End()
+
+	_, name := triper.GetTypeName(command)
+	span.SetAttribute("command.id", command.GetID())
+	span.SetAttribute("command.type", name)
+	span.SetAttribute("aggregate.id", command.GetAggregateID())
+
+	InjectCommand(command, span.Context())
+
+	key := triper.StreamKey(triper.TenantOf(command), command.GetAggregateID())
+	h.tracer.setActive(key, command.GetID(), span.Context())
+	defer h.tracer.clearActive(key, command.GetID())
+
+	err := h.next.Handle(command)
+	if err != nil {
+		span.SetError(err)
+	}
+
+	return err
+}
+
+// EventStore wraps an event store to trace saves and loads, the wrapper
+// implements triper.TenantEventStore only if store does it
+func (t *Tracer) EventStore(store triper.EventStore) triper.EventStore {
+	if _, ok := store.(triper.TenantEventStore); ok {
+		return &tenantEventStore{eventStore{store, t}}
+	}
+
+	return &eventStore{store, t}
+}
+
+type eventStore struct {
+	next   triper.EventStore
+	tracer *Tracer
+}
+
+type tenantEventStore struct {
+	eventStore
+}
+
+func (s *eventStore) startSave(name string, events []triper.Event) *Span {
+	var parent SpanContext
+	if len(events) > 0 {
+		parent = FromEvent(events[0])
+	}
+
+	span := s.tracer.Start(name, parent)
+	if len(events) > 0 {
+		span.SetAttribute("aggregate.id", events[0].AggregateID)
+	}
+
+	return span
+}
+
+func (s *eventStore) Save(events []triper.Event, version int) error {
+	span := s.startSave("eventstore.save", events)
+	defer span.End()
+
+	err := s.next.Save(events, version)
+	if err != nil {
+		span.SetError(err)
+	}
+
+	return err
+}
+
+func (s *eventStore) SafeSave(events []triper.Event, version int) error {
+	span := s.startSave("eventstore.safe_save", events)
+	defer span.End()
+
+	err := s.next.SafeSave(events, version)
+	if err != nil {
+		span.SetError(err)
+	}
+
+	return err
+}
+
+func (s *eventStore) Load(aggregateID string) ([]triper.Event, error) {
+	span := s.tracer.Start("eventstore.load", s.tracer.getActive(triper.StreamKey("", aggregateID)))
+	defer span.End()
+
+	span.SetAttribute("aggregate.id", aggregateID)
+
+	events, err := s.next.Load(aggregateID)
+	if err != nil {
+		span.SetError(err)
+	}
+
+	return events, err
+}
+
+func (s *tenantEventStore) LoadTenant(tenantID, aggregateID string) ([]triper.Event, error) {
+	span := s.tracer.Start("eventstore.load", s.tracer.getActive(triper.StreamKey(tenantID, aggregateID)))
+	defer span.End()
+
+	span.SetAttribute("aggregate.id", aggregateID)
+	span.SetAttribute("tenant.id", tenantID)
+
+	events, err := s.next.(triper.TenantEventStore).LoadTenant(tenantID, aggregateID)
+	if err != nil {
+		span.SetError(err)
+	}
+
+	return events, err
+}
+
+// EventBus wraps an event bus to trace the publishing, the span context is
+// injected in the event metadata so consumers can continue the trace
+func (t *Tracer) EventBus(bus triper.EventBus, name string) triper.EventBus {
+	return &eventBus{bus, name, t}
+}
+
+type eventBus struct {
+	next   triper.EventBus
+	name   string
+	tracer *Tracer
+}
+
+func (b *eventBus) Publish(event triper.Event, bucket, subset string) error {
+	span := b.tracer.Start("eventbus.publish", FromEvent(event))
+	defer span.End()
+
+	span.SetAttribute("bus", b.name)
+	span.SetAttribute("event.id", event.ID)
+	span.SetAttribute("event.type", event.Type)
+	span.SetAttribute("destination", bucket+"."+subset)
+
+	err := b.next.Publish(InjectEvent(event, span.Context()), bucket, subset)
+	if err != nil {
+		span.SetError(err)
+	}
+
+	return err
+}
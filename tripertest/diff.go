@@ -0,0 +1,121 @@
+package tripertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mishudark/triper"
+)
+
+// diffEvents describes the differences between the expected and the
+// actual events, it is empty when they are equal
+func diffEvents(expected, actual []triper.Event) string {
+	if len(expected) != len(actual) {
+		return fmt.Sprintf("expected %d events:\n%sgot %d events:\n%s",
+			len(expected), describe(expected), len(actual), describe(actual))
+	}
+
+	var b strings.Builder
+	for i := range expected {
+		if expected[i].Type != actual[i].Type {
+			fmt.Fprintf(&b, "  event %d: expected type %s, got %s\n", i+1, expected[i].Type, actual[i].Type)
+			continue
+		}
+
+		for _, diff := range diffValues(expected[i].Data, actual[i].Data) {
+			fmt.Fprintf(&b, "  event %d, %s: %s\n", i+1, expected[i].Type, diff)
+		}
+	}
+
+	return b.String()
+}
+
+// diffValues compares two payloads by their json representation, every
+// field that differs is returned as "path: expected x, got y"
+func diffValues(expected, actual interface{}) []string {
+	want := flatten(expected)
+	got := flatten(actual)
+
+	paths := make(map[string]struct{})
+	for path := range want {
+		paths[path] = struct{}{}
+	}
+
+	for path := range got {
+		paths[path] = struct{}{}
+	}
+
+	var diffs []string
+	for path := range paths {
+		w, inWant := want[path]
+		g, inGot := got[path]
+
+		switch {
+		case !inWant:
+			diffs = append(diffs, fmt.Sprintf("%s: unexpected %s", path, g))
+		case !inGot:
+			diffs = append(diffs, fmt.Sprintf("%s: expected %s, missing", path, w))
+		case w != g:
+			diffs = append(diffs, fmt.Sprintf("%s: expected %s, got %s", path, w, g))
+		}
+	}
+
+	sort.Strings(diffs)
+	return diffs
+}
+
+// flatten returns the json values of a payload indexed by their path
+func flatten(value interface{}) map[string]string {
+	values := make(map[string]string)
+
+	var generic interface{}
+	blob, err := json.Marshal(value)
+	if err == nil {
+		err = json.Unmarshal(blob, &generic)
+	}
+
+	if err != nil {
+		values["."] = fmt.Sprintf("%#v", value)
+		return values
+	}
+
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, field := range v {
+				walk(path+"."+key, field)
+			}
+		case []interface{}:
+			if len(v) == 0 {
+				values[path] = "[]"
+			}
+
+			for i, item := range v {
+				walk(fmt.Sprintf("%s[%d]", path, i), item)
+			}
+		default:
+			blob, _ := json.Marshal(v)
+			values[path] = string(blob)
+		}
+	}
+
+	walk("", generic)
+	if len(values) == 0 {
+		values["."] = "{}"
+	}
+
+	return values
+}
+
+// format a payload as json
+func format(value interface{}) string {
+	blob, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%#v", value)
+	}
+
+	return string(blob)
+}
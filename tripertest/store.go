@@ -0,0 +1,59 @@
+package tripertest
+
+import (
+	"fmt"
+
+	"github.com/mishudark/triper"
+)
+
+// eventStore keeps the events in memory partitioned by tenant, the
+// versions are checked like in the real stores
+type eventStore struct {
+	events map[string][]triper.Event
+}
+
+func newEventStore() *eventStore {
+	return &eventStore{
+		events: make(map[string][]triper.Event),
+	}
+}
+
+func (s *eventStore) Save(events []triper.Event, version int) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	key := triper.StreamKey(events[0].TenantID, events[0].AggregateID)
+	if current := len(s.events[key]); current != version {
+		return fmt.Errorf("tripertest: %s, aggregate version missmatch, wanted: %d, got: %d", events[0].AggregateID, version, current)
+	}
+
+	return s.SafeSave(events, version)
+}
+
+func (s *eventStore) SafeSave(events []triper.Event, version int) error {
+	if len(events) > 0 {
+		key := triper.StreamKey(events[0].TenantID, events[0].AggregateID)
+		s.events[key] = append(s.events[key], events...)
+	}
+
+	return nil
+}
+
+func (s *eventStore) Load(aggregateID string) ([]triper.Event, error) {
+	return s.LoadTenant("", aggregateID)
+}
+
+func (s *eventStore) LoadTenant(tenantID, aggregateID string) ([]triper.Event, error) {
+	return s.events[triper.StreamKey(tenantID, aggregateID)], nil
+}
+
+// eventBus records the published events
+type eventBus struct {
+	events []triper.Event
+}
+
+func (b *eventBus) Publish(event triper.Event, bucket, subset string) error {
+	b.events = append(b.events, event)
+	return nil
+}
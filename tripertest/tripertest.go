@@ -0,0 +1,192 @@
+// Package tripertest tests aggregates with a Given/When/Then scenario:
+//
+//	tripertest.New(t, &bank.Account{}).
+//		Given(&bank.AccountCreated{Owner: "mishudark"}).
+//		When(&deposit).
+//		Then(&bank.DepositPerformed{Amount: 10})
+//
+// The command is handled by basic.Handler with an in memory event store and
+// event bus, so the aggregate follows the same path that in production
+package tripertest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mishudark/triper"
+	"github.com/mishudark/triper/commandhandler/basic"
+)
+
+// HandlerConstructor creates the command handler used by the scenario, it
+// has the signature of basic.NewCommandHandler used by config.WireCommands
+type HandlerConstructor func(*triper.Repository, triper.AggregateHandler, string, string) triper.CommandHandler
+
+// Scenario describes the events that already happened, the command sent to
+// the aggregate and the expected result
+type Scenario struct {
+	t          testing.TB
+	aggregate  triper.AggregateHandler
+	handler    HandlerConstructor
+	authorizer *triper.Authorizer
+	given      []interface{}
+	command    triper.Command
+}
+
+// New returns a scenario for the aggregate type of aggregate
+func New(t testing.TB, aggregate triper.AggregateHandler) *Scenario {
+	return &Scenario{
+		t:         t,
+		aggregate: aggregate,
+		handler:   basic.NewCommandHandler,
+	}
+}
+
+// Handler replaces basic.NewCommandHandler
+func (s *Scenario) Handler(constructor HandlerConstructor) *Scenario {
+	s.handler = constructor
+	return s
+}
+
+// Authorizer used by the repository, like config.Authorization
+func (s *Scenario) Authorizer(authorizer *triper.Authorizer) *Scenario {
+	s.authorizer = authorizer
+	return s
+}
+
+// Given events already stored for the aggregate, every value can be an
+// event payload or a triper.Event. The aggregate id and the versions are
+// taken from the command when they are missing
+func (s *Scenario) Given(events ...interface{}) *Scenario {
+	s.given = append(s.given, events...)
+	return s
+}
+
+// When the command is sent to the aggregate, it must carry the version of
+// the aggregate after the given events like a command sent by a client
+func (s *Scenario) When(command triper.Command) *Scenario {
+	s.command = command
+	return s
+}
+
+// Then the command produces the events, every value can be an event
+// payload or a triper.Event, only the type and the payload are compared
+func (s *Scenario) Then(events ...interface{}) {
+	s.t.Helper()
+
+	published, err := s.run()
+	if err != nil {
+		s.t.Errorf("tripertest: expected events, got failure: %s", err)
+		return
+	}
+
+	if diff := diffEvents(toEvents(events), published); diff != "" {
+		s.t.Errorf("tripertest: unexpected events:\n%s", diff)
+	}
+}
+
+// ThenFailure the command fails with typ and no events are produced
+func (s *Scenario) ThenFailure(typ triper.FailureType) {
+	s.t.Helper()
+
+	published, err := s.run()
+	if err == nil {
+		s.t.Errorf("tripertest: expected failure %s, got %d events:\n%s", typ, len(published), describe(published))
+		return
+	}
+
+	failure, ok := err.(triper.Failure)
+	if !ok {
+		s.t.Errorf("tripertest: expected failure %s, got error: %s", typ, err)
+		return
+	}
+
+	if failure.Type != typ {
+		s.t.Errorf("tripertest: expected failure %s, got %s: %s", typ, failure.Type, failure.Err)
+	}
+}
+
+// run stores the given events and handles the command, the published
+// events are returned
+func (s *Scenario) run() ([]triper.Event, error) {
+	if s.command == nil {
+		return nil, fmt.Errorf("When was not called")
+	}
+
+	store := newEventStore()
+	bus := &eventBus{}
+
+	given := toEvents(s.given)
+	for i := range given {
+		if given[i].AggregateID == "" {
+			given[i].AggregateID = s.command.GetAggregateID()
+		}
+
+		if given[i].TenantID == "" {
+			given[i].TenantID = triper.TenantOf(s.command)
+		}
+
+		if given[i].AggregateType == "" {
+			given[i].AggregateType = s.command.GetAggregateType()
+		}
+
+		if given[i].Version == 0 {
+			given[i].Version = i + 1
+		}
+	}
+
+	if len(given) > 0 {
+		if s.command.GetVersion() != len(given) {
+			return nil, fmt.Errorf("the command version is %d, but %d events were given", s.command.GetVersion(), len(given))
+		}
+
+		store.events[triper.StreamKey(given[0].TenantID, given[0].AggregateID)] = given
+	}
+
+	if s.command.GetID() == "" {
+		s.command.GenerateUUID()
+	}
+
+	repository := triper.NewRepository(store, bus)
+	repository.SetAuthorizer(s.authorizer)
+
+	handler := s.handler(repository, s.aggregate, "tripertest", "events")
+	err := handler.Handle(s.command)
+
+	// failures are published too, only the events are returned
+	var published []triper.Event
+	for _, event := range bus.events {
+		if event.Type != triper.FailureEventType {
+			published = append(published, event)
+		}
+	}
+
+	return published, err
+}
+
+// toEvents wraps the payloads in events
+func toEvents(values []interface{}) []triper.Event {
+	events := make([]triper.Event, len(values))
+	for i, value := range values {
+		if event, ok := value.(triper.Event); ok {
+			events[i] = event
+		} else {
+			events[i] = triper.Event{Data: value}
+		}
+
+		if events[i].Type == "" {
+			_, events[i].Type = triper.GetTypeName(events[i].Data)
+		}
+	}
+
+	return events
+}
+
+func describe(events []triper.Event) string {
+	var b strings.Builder
+	for i, event := range events {
+		fmt.Fprintf(&b, "  %d: %s %s\n", i+1, event.Type, format(event.Data))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package tripertest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mishudark/triper"
+)
+
+type Opened struct {
+	Owner string
+}
+
+type Deposited struct {
+	Amount int
+}
+
+type Open struct {
+	triper.BaseCommand
+	Owner string
+}
+
+type Deposit struct {
+	triper.BaseCommand
+	Amount int
+}
+
+type Account struct {
+	triper.BaseAggregate
+	Balance int
+}
+
+func (a *Account) Reduce(event triper.Event) error {
+	switch e := event.Data.(type) {
+	case *Opened:
+		a.ID = event.AggregateID
+	case *Deposited:
+		a.Balance += e.Amount
+	default:
+		return fmt.Errorf("unknown event %T", e)
+	}
+
+	return nil
+}
+
+func (a *Account) HandleCommand(command triper.Command) error {
+	event := triper.Event{
+		AggregateID:   command.GetAggregateID(),
+		AggregateType: "account",
+	}
+
+	switch c := command.(type) {
+	case *Open:
+		event.Data = &Opened{c.Owner}
+	case *Deposit:
+		if c.Amount <= 0 {
+			return errors.New("invalid amount")
+		}
+
+		event.Data = &Deposited{c.Amount + a.Balance%10}
+	}
+
+	triper.ReduceHelper(a, event, true)
+	return nil
+}
+
+// fakeT records the errors reported by the scenario
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func deposit(amount, version int) *Deposit {
+	command := &Deposit{Amount: amount}
+	command.AggregateID = "account-1"
+	command.Version = version
+	return command
+}
+
+func TestThen(t *testing.T) {
+	open := &Open{Owner: "mishudark"}
+	open.AggregateID = "account-1"
+
+	New(t, &Account{}).
+		When(open).
+		Then(&Opened{Owner: "mishudark"})
+
+	New(t, &Account{}).
+		Given(&Opened{Owner: "mishudark"}).
+		When(deposit(10, 1)).
+		Then(&Deposited{Amount: 10})
+}
+
+func TestThenDiff(t *testing.T) {
+	fake := &fakeT{}
+
+	// the balance is 3, so the amount of the event is 13
+	New(fake, &Account{}).
+		Given(&Opened{Owner: "mishudark"}, &Deposited{Amount: 3}).
+		When(deposit(10, 2)).
+		Then(&Deposited{Amount: 10})
+
+	if len(fake.errors) != 1 || !strings.Contains(fake.errors[0], ".Amount: expected 10, got 13") {
+		t.Error("expected a diff of Amount, got", fake.errors)
+	}
+}
+
+func TestThenFailure(t *testing.T) {
+	New(t, &Account{}).
+		Given(&Opened{Owner: "mishudark"}).
+		When(deposit(-1, 1)).
+		ThenFailure(triper.FailureProcessingCommand)
+
+	// the aggregate does not exist
+	New(t, &Account{}).
+		When(deposit(10, 0)).
+		ThenFailure(triper.FailureInvalidID)
+
+	fake := &fakeT{}
+	New(fake, &Account{}).
+		Given(&Opened{Owner: "mishudark"}).
+		When(deposit(10, 1)).
+		ThenFailure(triper.FailureProcessingCommand)
+
+	if len(fake.errors) != 1 {
+		t.Error("expected 1 error, got", fake.errors)
+	}
+}
+
+func TestThenForbidden(t *testing.T) {
+	authorizer := triper.NewAuthorizer(nil)
+	authorizer.Add(&Deposit{}, triper.RequireRoles("teller"))
+
+	command := deposit(10, 1)
+	New(t, &Account{}).
+		Authorizer(authorizer).
+		Given(&Opened{Owner: "mishudark"}).
+		When(command).
+		ThenFailure(triper.FailureForbidden)
+
+	command = deposit(10, 1)
+	command.SetPrincipal(&triper.Principal{ID: "ana", Roles: []string{"teller"}})
+	New(t, &Account{}).
+		Authorizer(authorizer).
+		Given(&Opened{Owner: "mishudark"}).
+		When(command).
+		Then(&Deposited{Amount: 10})
+}
@@ -0,0 +1,194 @@
+package triper
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ValidationTag is the struct tag with the rules of a field, like:
+//
+//	Owner  string `validate:"required"`
+//	Amount int    `validate:"min=1,max=1000"`
+//
+// min and max compare numbers by value and strings, slices and maps by length
+const ValidationTag = "validate"
+
+// Validator is implemented by the commands that validate themselves, it
+// should return a ValidationError to report the fields
+type Validator interface {
+	Validate() error
+}
+
+// FieldError describes why a field is not valid
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (f FieldError) Error() string {
+	if f.Field == "" {
+		return f.Message
+	}
+
+	return f.Field + ": " + f.Message
+}
+
+// ValidationError contains the fields that are not valid
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, field := range v {
+		msgs[i] = field.Error()
+	}
+
+	return "invalid command: " + strings.Join(msgs, ", ")
+}
+
+// ValidateCommand checks the rules in the struct tags, Validator and
+// IsValid, the fields that are not valid are returned as ValidationError
+func ValidateCommand(command Command) error {
+	errs := validateStruct(reflect.ValueOf(command), "")
+
+	if validator, ok := command.(Validator); ok {
+		switch err := validator.Validate().(type) {
+		case nil:
+		case ValidationError:
+			errs = append(errs, err...)
+		case FieldError:
+			errs = append(errs, err)
+		default:
+			errs = append(errs, FieldError{Rule: "validate", Message: err.Error()})
+		}
+	}
+
+	if ValidateTenantID(TenantOf(command)) != nil {
+		errs = append(errs, FieldError{Field: "TenantID", Rule: "tenant_id", Message: "can't contain separators or wildcards"})
+	}
+
+	if len(errs) == 0 && !command.IsValid() {
+		errs = append(errs, FieldError{Rule: "is_valid", Message: "command is not valid"})
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// ValidateStruct checks the rules in the struct tags of v
+func ValidateStruct(v interface{}) error {
+	if errs := validateStruct(reflect.ValueOf(v), ""); len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string) ValidationError {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs ValidationError
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+
+		// the embedded structs share the namespace
+		if field.Anonymous {
+			errs = append(errs, validateStruct(value, prefix)...)
+			continue
+		}
+
+		rules, ok := field.Tag.Lookup(ValidationTag)
+		if !ok || field.PkgPath != "" {
+			continue
+		}
+
+		name := prefix + field.Name
+		for _, rule := range strings.Split(rules, ",") {
+			if rule = strings.TrimSpace(rule); rule == "" {
+				continue
+			}
+
+			if err := checkRule(name, rule, value); err != nil {
+				errs = append(errs, *err)
+			}
+		}
+
+		if value.Kind() == reflect.Struct || value.Kind() == reflect.Ptr {
+			errs = append(errs, validateStruct(value, name+".")...)
+		}
+	}
+
+	return errs
+}
+
+func checkRule(name, rule string, value reflect.Value) *FieldError {
+	key, arg := rule, ""
+	if i := strings.IndexByte(rule, '='); i >= 0 {
+		key, arg = rule[:i], rule[i+1:]
+	}
+
+	switch key {
+	case "required":
+		if value.IsZero() {
+			return &FieldError{Field: name, Rule: key, Message: "is required"}
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return &FieldError{Field: name, Rule: rule, Message: fmt.Sprintf("invalid rule %q", rule)}
+		}
+
+		n, what, ok := measure(value)
+		if !ok {
+			return &FieldError{Field: name, Rule: rule, Message: fmt.Sprintf("%s does not support %s", value.Kind(), key)}
+		}
+
+		if key == "min" && n < limit {
+			return &FieldError{Field: name, Rule: rule, Message: fmt.Sprintf("%s must be at least %s", what, arg)}
+		}
+
+		if key == "max" && n > limit {
+			return &FieldError{Field: name, Rule: rule, Message: fmt.Sprintf("%s must be at most %s", what, arg)}
+		}
+	default:
+		return &FieldError{Field: name, Rule: rule, Message: fmt.Sprintf("unknown rule %q", key)}
+	}
+
+	return nil
+}
+
+// measure returns the number compared by min and max
+func measure(value reflect.Value) (n float64, what string, ok bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), "value", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), "value", true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), "value", true
+	case reflect.String:
+		return float64(len([]rune(value.String()))), "length", true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), "length", true
+	default:
+		return 0, "", false
+	}
+}
@@ -0,0 +1,78 @@
+package triper
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type Address struct {
+	City string `validate:"required"`
+}
+
+type OpenValidAccount struct {
+	BaseCommand
+	Owner   string   `validate:"required,max=5"`
+	Deposit int      `validate:"min=10"`
+	Tags    []string `validate:"max=1"`
+	Address Address  `validate:""`
+}
+
+func (o *OpenValidAccount) Validate() error {
+	if o.Owner == "root" {
+		return ValidationError{{Field: "Owner", Rule: "reserved", Message: "is reserved"}}
+	}
+
+	return nil
+}
+
+func TestValidateCommand(t *testing.T) {
+	command := &OpenValidAccount{Owner: "mishudark", Deposit: 5, Tags: []string{"a", "b"}}
+
+	err := ValidateCommand(command)
+	fields, ok := err.(ValidationError)
+	if !ok {
+		t.Fatal("expected ValidationError, got", err)
+	}
+
+	expected := []string{"Owner: length must be at most 5", "Deposit: value must be at least 10", "Tags: length must be at most 1", "Address.City: is required"}
+	if len(fields) != len(expected) {
+		t.Fatal("expected 4 fields, got", fields)
+	}
+
+	for i, field := range fields {
+		if field.Error() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], field.Error())
+		}
+	}
+
+	command = &OpenValidAccount{Owner: "root", Deposit: 10, Address: Address{"Oaxaca"}}
+	if fields, _ = ValidateCommand(command).(ValidationError); len(fields) != 1 || fields[0].Rule != "reserved" {
+		t.Error("expected reserved field, got", fields)
+	}
+
+	command.Owner = "ana"
+	if err = ValidateCommand(command); err != nil {
+		t.Error("expected nil, got", err)
+	}
+}
+
+func TestFailureFieldsJSON(t *testing.T) {
+	command := &OpenValidAccount{}
+	failure := NewFailure(ValidateCommand(command), FailureInvalidCommand, command)
+
+	blob, err := json.Marshal(failure)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	var decoded Failure
+	if err = json.Unmarshal(blob, &decoded); err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	var fields ValidationError
+	if !errors.As(decoded.Err, &fields) || len(fields) != 3 || fields[0].Field != "Owner" {
+		t.Errorf("expected the fields, got: %s", blob)
+	}
+}